@@ -0,0 +1,16 @@
+//go:build darwin
+
+package cache
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+func accessTime(info os.FileInfo) time.Time {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return time.Unix(stat.Atimespec.Sec, stat.Atimespec.Nsec)
+	}
+	return info.ModTime()
+}
@@ -0,0 +1,16 @@
+//go:build linux
+
+package cache
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+func accessTime(info os.FileInfo) time.Time {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return time.Unix(stat.Atim.Sec, stat.Atim.Nsec)
+	}
+	return info.ModTime()
+}
@@ -0,0 +1,14 @@
+//go:build !linux && !darwin
+
+package cache
+
+import (
+	"os"
+	"time"
+)
+
+// accessTime falls back to the modification time where the access time isn't easy to get at,
+// which makes eviction oldest-fetched first instead of least recently used
+func accessTime(info os.FileInfo) time.Time {
+	return info.ModTime()
+}
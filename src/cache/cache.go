@@ -0,0 +1,239 @@
+package cache
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/Eyepan/yap/src/filelock"
+	"github.com/Eyepan/yap/src/types"
+	"github.com/Eyepan/yap/src/utils"
+)
+
+// Entry is one packument in the metadata cache
+type Entry struct {
+	Name      string // of the package
+	Size      int64  // of its record in the cache file
+	FetchedAt time.Time
+	LastUsed  time.Time
+}
+
+// Get returns the cached packument of a package, or nil when it isn't cached. Corrupted entries are
+// reported as *utils.CorruptError, Discard them and fetch them again
+func Get(name string) (*utils.LazyMetadata, error) {
+	d, err := openDB()
+	if err != nil {
+		return nil, err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.get(name)
+}
+
+// Put caches a packument that was just fetched
+func Put(metadata types.Metadata) (*utils.LazyMetadata, error) {
+	d, err := openDB()
+	if err != nil {
+		return nil, err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	lock, err := d.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer lock.Release()
+	return d.put(metadata, time.Now())
+}
+
+// Discard forgets the cached packument of a package, so the next Get misses
+func Discard(name string) error {
+	d, err := openDB()
+	if err != nil {
+		return err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.drop(name)
+	return nil
+}
+
+// Flush writes the index of the cache, so the next run doesn't have to read what this one appended
+func Flush() error {
+	d, err := openDB()
+	if err != nil {
+		return err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	lock, err := d.lock()
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
+	return d.flush()
+}
+
+// List returns every entry in the metadata cache, sorted by name
+func List() ([]Entry, error) {
+	d, err := openDB()
+	if err != nil {
+		return nil, err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	lock, err := d.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer lock.Release()
+
+	entries := make([]Entry, 0, len(d.entries))
+	for _, entry := range d.entries {
+		entries = append(entries, Entry{
+			Name:      entry.Name,
+			Size:      entry.Length,
+			FetchedAt: time.Unix(0, entry.FetchedAt),
+			LastUsed:  time.Unix(0, entry.LastUsed),
+		})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name < entries[j].Name
+	})
+	return entries, nil
+}
+
+// View decodes the cached packument of a package
+func View(name string) (*types.Metadata, error) {
+	metadata, err := Get(name)
+	if err != nil {
+		return nil, err
+	}
+	if metadata == nil {
+		return nil, fmt.Errorf("%s isn't in the metadata cache", name)
+	}
+	return metadata.Metadata()
+}
+
+// Clean removes the cached packuments of the given packages. It returns how many entries were removed and
+// how many bytes that freed
+func Clean(names []string) (int, int64, error) {
+	if len(names) == 0 {
+		return 0, 0, nil
+	}
+	d, err := openDB()
+	if err != nil {
+		return 0, 0, err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	lock, err := d.lock()
+	if err != nil {
+		return 0, 0, err
+	}
+	defer lock.Release()
+	removed := 0
+	for _, name := range names {
+		if _, ok := d.entries[name]; ok {
+			d.drop(name)
+			removed++
+		}
+	}
+	return d.shrink(removed)
+}
+
+// CleanAll empties the metadata cache by removing the cache files themselves, which also works when they
+// can't be opened
+func CleanAll() (int, int64, error) {
+	entries, _ := List()
+	sharedMu.Lock()
+	defer sharedMu.Unlock()
+	if shared != nil {
+		shared.mu.Lock()
+		defer shared.mu.Unlock()
+		shared.close()
+		shared = nil
+	}
+
+	cacheDir, err := utils.GetCacheDir()
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to get cache directory: %w", err)
+	}
+	// other processes open the files again when they notice they're gone
+	lock, err := filelock.Acquire(filepath.Join(cacheDir, lockFileName))
+	if err != nil {
+		return 0, 0, err
+	}
+	defer lock.Release()
+	var freed int64
+	for _, name := range []string{dbFileName, indexFileName} {
+		path := filepath.Join(cacheDir, name)
+		info, err := os.Stat(path)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return 0, freed, fmt.Errorf("failed to stat %s: %w", path, err)
+		}
+		if err := os.Remove(path); err != nil {
+			return 0, freed, fmt.Errorf("failed to remove %s: %w", path, err)
+		}
+		freed += info.Size()
+	}
+	return len(entries), freed, nil
+}
+
+// Evict removes the least recently used entries until the cache fits in maxSize bytes. A maxSize of 0 means
+// the cache can grow forever
+func Evict(maxSize int64) (int, int64, error) {
+	if maxSize <= 0 {
+		return 0, 0, nil
+	}
+	d, err := openDB()
+	if err != nil {
+		return 0, 0, err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	lock, err := d.lock()
+	if err != nil {
+		return 0, 0, err
+	}
+	defer lock.Release()
+
+	entries := make([]*types.CacheIndexEntry, 0, len(d.entries))
+	var total int64
+	for _, entry := range d.entries {
+		entries = append(entries, entry)
+		total += entry.Length
+	}
+	if total <= maxSize {
+		return 0, 0, nil
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].LastUsed < entries[j].LastUsed
+	})
+	removed := 0
+	for _, entry := range entries {
+		if total <= maxSize {
+			break
+		}
+		d.drop(entry.Name)
+		total -= entry.Length
+		removed++
+	}
+	return d.shrink(removed)
+}
+
+// shrink compacts the cache after removed entries were dropped, returning how many bytes that freed
+func (d *db) shrink(removed int) (int, int64, error) {
+	if removed == 0 {
+		return 0, 0, nil
+	}
+	before := d.fileSize()
+	if err := d.compact(); err != nil {
+		return 0, 0, err
+	}
+	return removed, before - d.fileSize(), nil
+}
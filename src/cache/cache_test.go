@@ -0,0 +1,187 @@
+package cache
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Eyepan/yap/src/types"
+	"github.com/Eyepan/yap/src/utils"
+)
+
+const (
+	benchPackages = 500
+	benchVersions = 200
+)
+
+// benchMetadata makes packuments shaped like the ones on the registry, with many versions of which an
+// install only needs one
+func benchMetadata() []types.Metadata {
+	packuments := make([]types.Metadata, 0, benchPackages)
+	for i := range benchPackages {
+		name := fmt.Sprintf("@bench/pkg-%d", i)
+		metadata := types.Metadata{Name: name, Versions: make(map[string]types.VersionMetadata, benchVersions)}
+		for v := range benchVersions {
+			version := fmt.Sprintf("1.%d.0", v)
+			metadata.Versions[version] = types.VersionMetadata{
+				Name:    name,
+				Version: version,
+				Dist: types.Dist{
+					Shasum:    "0123456789abcdef0123456789abcdef01234567",
+					Integrity: "sha512-" + string(bytes.Repeat([]byte{'A'}, 86)) + "==",
+					Tarball:   fmt.Sprintf("https://registry.npmjs.org/%s/-/pkg-%d-%s.tgz", name, i, version),
+					FileCount: 12,
+				},
+				Dependencies: types.Dependencies{"left-pad": "^1.3.0", "right-pad": "^2.0.0", "is-odd": "^3.0.1"},
+			}
+		}
+		metadata.DistTags.Latest = fmt.Sprintf("1.%d.0", benchVersions-1)
+		packuments = append(packuments, metadata)
+	}
+	return packuments
+}
+
+// useTempHome points the cache at an empty home directory and forgets the cache this process had open
+func useTempHome(b testing.TB) {
+	b.Setenv("HOME", b.TempDir())
+	reset := func() {
+		sharedMu.Lock()
+		defer sharedMu.Unlock()
+		if shared != nil {
+			shared.close()
+			shared = nil
+		}
+	}
+	reset()
+	b.Cleanup(reset)
+}
+
+// BenchmarkGetVersion resolves one version of every package through the cache file, the way an install does
+func BenchmarkGetVersion(b *testing.B) {
+	useTempHome(b)
+	packuments := benchMetadata()
+	for _, metadata := range packuments {
+		if _, err := Put(metadata); err != nil {
+			b.Fatalf("failed to cache %s: %v", metadata.Name, err)
+		}
+	}
+	if err := Flush(); err != nil {
+		b.Fatalf("failed to flush the cache: %v", err)
+	}
+
+	b.ResetTimer()
+	for range b.N {
+		for _, metadata := range packuments {
+			lazy, err := Get(metadata.Name)
+			if err != nil || lazy == nil {
+				b.Fatalf("failed to get %s: %v", metadata.Name, err)
+			}
+			if _, err := lazy.Version(metadata.DistTags.Latest); err != nil {
+				b.Fatalf("failed to decode %s@%s: %v", metadata.Name, metadata.DistTags.Latest, err)
+			}
+		}
+	}
+}
+
+// BenchmarkReadMetadataPerFile resolves the same versions the way older versions of yap did, reading and
+// decoding a whole packument from its own file for every package
+func BenchmarkReadMetadataPerFile(b *testing.B) {
+	dir := b.TempDir()
+	packuments := benchMetadata()
+	for _, metadata := range packuments {
+		var buf bytes.Buffer
+		if err := utils.WriteMetadata(&buf, metadata); err != nil {
+			b.Fatalf("failed to write %s: %v", metadata.Name, err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, utils.EncodePackageName(metadata.Name)), buf.Bytes(), 0644); err != nil {
+			b.Fatalf("failed to write %s: %v", metadata.Name, err)
+		}
+	}
+
+	b.ResetTimer()
+	for range b.N {
+		for _, metadata := range packuments {
+			data, err := os.ReadFile(filepath.Join(dir, utils.EncodePackageName(metadata.Name)))
+			if err != nil {
+				b.Fatalf("failed to read %s: %v", metadata.Name, err)
+			}
+			decoded, err := utils.ReadMetadata(bytes.NewReader(data))
+			if err != nil {
+				b.Fatalf("failed to decode %s: %v", metadata.Name, err)
+			}
+			if _, ok := decoded.Versions[metadata.DistTags.Latest]; !ok {
+				b.Fatalf("%s@%s is missing", metadata.Name, metadata.DistTags.Latest)
+			}
+		}
+	}
+}
+
+// TestCorruptRecordReplacesTheFile cuts a write short and checks the cache file is rewritten rather than
+// truncated, so a process that mapped it before can still read all of it
+func TestCorruptRecordReplacesTheFile(t *testing.T) {
+	useTempHome(t)
+	packuments := benchMetadata()[:2]
+	for _, metadata := range packuments {
+		if _, err := Put(metadata); err != nil {
+			t.Fatalf("failed to cache %s: %v", metadata.Name, err)
+		}
+	}
+	cacheDir, err := utils.GetCacheDir()
+	if err != nil {
+		t.Fatalf("failed to get the cache directory: %v", err)
+	}
+	dbFile := filepath.Join(cacheDir, dbFileName)
+
+	// another process with the file mapped
+	other := &db{dir: cacheDir}
+	if err := other.load(); err != nil {
+		t.Fatalf("failed to open the cache: %v", err)
+	}
+	defer other.close()
+	mappedSize := int64(len(other.mapped))
+
+	file, err := os.OpenFile(dbFile, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatalf("failed to open the cache file: %v", err)
+	}
+	if _, err := file.Write([]byte{0xff, 0xff, 0, 0, 1, 2, 3}); err != nil {
+		t.Fatalf("failed to write to the cache file: %v", err)
+	}
+	file.Close()
+
+	// this process opens the cache again and finds the record that doesn't check out
+	sharedMu.Lock()
+	shared.close()
+	shared = nil
+	sharedMu.Unlock()
+	for _, metadata := range packuments {
+		if lazy, err := Get(metadata.Name); err != nil || lazy == nil {
+			t.Fatalf("failed to get %s after the cut short write: %v", metadata.Name, err)
+		}
+	}
+
+	info, err := os.Stat(dbFile)
+	if err != nil {
+		t.Fatalf("failed to stat the cache file: %v", err)
+	}
+	if info.Size() != mappedSize {
+		t.Errorf("the cache file has %d bytes, want the %d bytes of its records", info.Size(), mappedSize)
+	}
+	opened, err := other.file.Stat()
+	if err != nil {
+		t.Fatalf("failed to stat the mapped file: %v", err)
+	}
+	if os.SameFile(info, opened) {
+		t.Errorf("the cache file was changed in place")
+	}
+	if opened.Size() < mappedSize {
+		t.Errorf("the mapped file was cut down to %d bytes from %d", opened.Size(), mappedSize)
+	}
+	for _, metadata := range packuments {
+		if _, err := other.readRecord(other.entries[metadata.Name].Offset); err != nil {
+			t.Errorf("the other process can't read %s anymore: %v", metadata.Name, err)
+		}
+	}
+}
@@ -0,0 +1,556 @@
+package cache
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Eyepan/yap/src/filelock"
+	"github.com/Eyepan/yap/src/types"
+	"github.com/Eyepan/yap/src/utils"
+)
+
+// The metadata cache is a single file of records appended one after the other, each holding a packument,
+// and an index next to it saying where the latest record of every package is. The index is only a
+// shortcut, whatever isn't in it is found by reading the records after the ones it knows about.
+// Every yap process appends to the same file, so writing to it, compacting it and writing the index happen
+// while holding the lock file next to it
+const (
+	dbFileName    = "metadata.db"
+	indexFileName = "metadata.idx"
+	lockFileName  = "metadata.lock"
+	// left once the packuments cached one file per package were moved into the cache file
+	migratedFileName = "metadata.migrated"
+)
+
+var dbMagic = [4]byte{'Y', 'A', 'P', 'D'}
+
+const (
+	dbVersion    uint16 = 1
+	dbHeaderSize        = 6 // magic and version
+	// a record is the length and CRC32 of its payload, then the payload: when it was fetched, the package
+	// name and the packument the way utils.WriteMetadataEntry writes it
+	recordHeaderSize = 8
+)
+
+const corruptHint = "run `yap cache clean` to fetch it again"
+
+type db struct {
+	mu      sync.Mutex
+	dir     string
+	file    *os.File
+	mapped  []byte // the start of the file, mapped into memory when it was opened
+	unmap   func() error
+	size    int64 // of the file, including what was appended since it was mapped
+	entries map[string]*types.CacheIndexEntry
+	dead    int64 // bytes taken by records that were replaced or dropped
+	dirty   bool  // whether the index on disk is behind
+	corrupt bool  // whether a record past size doesn't check out, which the next one to write compacts away
+}
+
+var (
+	sharedMu sync.Mutex
+	shared   *db
+)
+
+// openDB opens the metadata cache once per process, every fetch goes through the same one
+func openDB() (*db, error) {
+	sharedMu.Lock()
+	defer sharedMu.Unlock()
+	if shared != nil {
+		return shared, nil
+	}
+	cacheDir, err := utils.GetCacheDir()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get cache directory: %w", err)
+	}
+	if err := os.MkdirAll(cacheDir, os.ModePerm); err != nil {
+		return nil, fmt.Errorf("failed to create cache directory: %w", err)
+	}
+
+	d := &db{dir: cacheDir}
+	lock, err := filelock.Acquire(filepath.Join(cacheDir, lockFileName))
+	if err != nil {
+		return nil, err
+	}
+	defer lock.Release()
+	if err := d.load(); err != nil {
+		return nil, err
+	}
+	if err := d.repair(); err != nil {
+		d.close()
+		return nil, err
+	}
+	if err := d.migrate(); err != nil {
+		d.close()
+		return nil, err
+	}
+	shared = d
+	return shared, nil
+}
+
+// load opens the cache file and reads where everything in it is, from the index and the records after it
+func (d *db) load() error {
+	d.entries, d.dead, d.dirty, d.corrupt = make(map[string]*types.CacheIndexEntry), 0, false, false
+	if err := d.open(); err != nil {
+		return err
+	}
+	scanFrom := int64(dbHeaderSize)
+	if index, err := d.readIndex(); err == nil && index.Size >= dbHeaderSize && index.Size <= d.size {
+		live := int64(0)
+		for i := range index.Entries {
+			entry := index.Entries[i]
+			d.entries[entry.Name] = &entry
+			live += entry.Length
+		}
+		d.dead = max(index.Size-dbHeaderSize-live, 0)
+		scanFrom = index.Size
+	}
+	if err := d.scan(scanFrom); err != nil {
+		d.close()
+		return err
+	}
+	return nil
+}
+
+// lock keeps other processes from reading or writing the cache until it's released, and reads whatever they
+// wrote before it
+func (d *db) lock() (*filelock.Lock, error) {
+	lock, err := filelock.Acquire(filepath.Join(d.dir, lockFileName))
+	if err != nil {
+		return nil, err
+	}
+	if err := d.refresh(); err != nil {
+		lock.Release()
+		return nil, err
+	}
+	if err := d.repair(); err != nil {
+		lock.Release()
+		return nil, err
+	}
+	return lock, nil
+}
+
+// rlock keeps other processes from writing to the cache until it's released, and reads whatever they wrote
+// before it. Any number of processes read at once
+func (d *db) rlock() (*filelock.Lock, error) {
+	lock, err := filelock.AcquireShared(filepath.Join(d.dir, lockFileName))
+	if err != nil {
+		return nil, err
+	}
+	if err := d.refresh(); err != nil {
+		lock.Release()
+		return nil, err
+	}
+	return lock, nil
+}
+
+// repair rewrites the file without the record that didn't check out, the lock has to be held. The file is
+// replaced rather than cut off, other processes may have it mapped and reading past its end would crash them
+func (d *db) repair() error {
+	if !d.corrupt {
+		return nil
+	}
+	return d.compact()
+}
+
+// refresh reads the records other processes appended, or the whole file again when one of them compacted
+// or removed it. Only safe while holding the lock, a record that's still being written looks truncated
+func (d *db) refresh() error {
+	if d.file != nil {
+		current, err := os.Stat(filepath.Join(d.dir, dbFileName))
+		opened, openedErr := d.file.Stat()
+		if err == nil && openedErr == nil && os.SameFile(current, opened) {
+			return d.catchUp()
+		}
+	}
+	d.close()
+	return d.load()
+}
+
+// open opens and maps the cache file, starting a new one when there's none or it isn't a cache file
+func (d *db) open() error {
+	dbFile := filepath.Join(d.dir, dbFileName)
+	file, err := os.OpenFile(dbFile, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open metadata cache: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to stat metadata cache: %w", err)
+	}
+
+	header := make([]byte, dbHeaderSize)
+	if info.Size() >= dbHeaderSize {
+		if _, err := file.ReadAt(header, 0); err != nil {
+			file.Close()
+			return fmt.Errorf("failed to read metadata cache: %w", err)
+		}
+	}
+	if info.Size() < dbHeaderSize || !bytes.Equal(header[:len(dbMagic)], dbMagic[:]) {
+		file.Close()
+		// replaced instead of cut off, like compact does
+		if err := replaceFile(dbFile, dbHeader()); err != nil {
+			return fmt.Errorf("failed to reset metadata cache: %w", err)
+		}
+		_ = os.Remove(filepath.Join(d.dir, indexFileName))
+		return d.open()
+	} else if version := binary.LittleEndian.Uint16(header[len(dbMagic):]); version > dbVersion {
+		file.Close()
+		return &utils.NewerVersionError{File: "metadata cache", Hint: corruptHint, Version: version, Current: dbVersion}
+	}
+
+	mapped, unmap, err := mapFile(file, info.Size())
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to map metadata cache: %w", err)
+	}
+	d.file, d.mapped, d.unmap, d.size = file, mapped, unmap, info.Size()
+	return nil
+}
+
+// replaceFile writes data to a new file and renames it over path
+func replaceFile(path string, data []byte) error {
+	tempFile, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+"-*.tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tempFile.Write(data); err != nil {
+		tempFile.Close()
+		os.Remove(tempFile.Name())
+		return err
+	}
+	if err := tempFile.Close(); err != nil {
+		os.Remove(tempFile.Name())
+		return err
+	}
+	return os.Rename(tempFile.Name(), path)
+}
+
+func dbHeader() []byte {
+	return binary.LittleEndian.AppendUint16(append([]byte{}, dbMagic[:]...), dbVersion)
+}
+
+func (d *db) close() {
+	if d.unmap != nil {
+		_ = d.unmap()
+	}
+	if d.file != nil {
+		_ = d.file.Close()
+	}
+	d.file, d.mapped, d.unmap = nil, nil, nil
+}
+
+func (d *db) readIndex() (*types.CacheIndex, error) {
+	data, err := os.ReadFile(filepath.Join(d.dir, indexFileName))
+	if err != nil {
+		return nil, err
+	}
+	return utils.ReadCacheIndex(bytes.NewReader(data))
+}
+
+func (d *db) writeIndex() error {
+	index := types.CacheIndex{Size: d.size}
+	for _, entry := range d.entries {
+		index.Entries = append(index.Entries, *entry)
+	}
+	sort.Slice(index.Entries, func(i, j int) bool {
+		return index.Entries[i].Name < index.Entries[j].Name
+	})
+	var buf bytes.Buffer
+	if err := utils.WriteCacheIndex(&buf, &index); err != nil {
+		return err
+	}
+	indexFile := filepath.Join(d.dir, indexFileName)
+	if err := os.WriteFile(indexFile+".tmp", buf.Bytes(), 0644); err != nil {
+		return fmt.Errorf("failed to write metadata cache index: %w", err)
+	}
+	if err := os.Rename(indexFile+".tmp", indexFile); err != nil {
+		return fmt.Errorf("failed to write metadata cache index: %w", err)
+	}
+	d.dirty = false
+	return nil
+}
+
+// read copies length bytes at offset out of the mapping, or out of the file for what was appended after it
+func (d *db) read(offset, length int64) ([]byte, error) {
+	if offset < 0 || length < 0 || offset+length > d.size {
+		return nil, fmt.Errorf("%d bytes at %d are past the end of the metadata cache", length, offset)
+	}
+	data := make([]byte, length)
+	if offset+length <= int64(len(d.mapped)) {
+		copy(data, d.mapped[offset:])
+		return data, nil
+	}
+	if _, err := d.file.ReadAt(data, offset); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// readRecord returns the payload of the record at offset, after checking it against its checksum
+func (d *db) readRecord(offset int64) ([]byte, error) {
+	header, err := d.read(offset, recordHeaderSize)
+	if err != nil {
+		return nil, err
+	}
+	payload, err := d.read(offset+recordHeaderSize, int64(binary.LittleEndian.Uint32(header)))
+	if err != nil {
+		return nil, err
+	}
+	if crc32.ChecksumIEEE(payload) != binary.LittleEndian.Uint32(header[4:]) {
+		return nil, fmt.Errorf("the checksum of the record at %d doesn't match", offset)
+	}
+	return payload, nil
+}
+
+// parseRecord splits a payload into when it was fetched, the package name and its packument
+func parseRecord(payload []byte) (int64, string, []byte, error) {
+	if len(payload) < 12 {
+		return 0, "", nil, fmt.Errorf("record is truncated")
+	}
+	fetchedAt := int64(binary.LittleEndian.Uint64(payload))
+	nameLength := int64(binary.LittleEndian.Uint32(payload[8:]))
+	if 12+nameLength > int64(len(payload)) {
+		return 0, "", nil, fmt.Errorf("record name is out of bounds")
+	}
+	return fetchedAt, string(payload[12 : 12+nameLength]), payload[12+nameLength:], nil
+}
+
+// scan reads the records from offset to the end of the file into the index. A record that doesn't check out
+// is where a write got cut short, so everything from there on is ignored until repair rewrites the file
+func (d *db) scan(offset int64) error {
+	for offset < d.size {
+		payload, err := d.readRecord(offset)
+		var fetchedAt int64
+		var name string
+		if err == nil {
+			fetchedAt, name, _, err = parseRecord(payload)
+		}
+		if err != nil {
+			d.size = offset
+			d.mapped = d.mapped[:min(int64(len(d.mapped)), offset)]
+			d.dirty, d.corrupt = true, true
+			return nil
+		}
+		length := recordHeaderSize + int64(len(payload))
+		if previous, ok := d.entries[name]; ok {
+			d.dead += previous.Length
+		}
+		d.entries[name] = &types.CacheIndexEntry{Name: name, Offset: offset, Length: length, FetchedAt: fetchedAt, LastUsed: fetchedAt}
+		d.dirty = true
+		offset += length
+	}
+	return nil
+}
+
+// catchUp reads the records other processes appended since the file was last looked at
+func (d *db) catchUp() error {
+	info, err := d.file.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat metadata cache: %w", err)
+	}
+	if info.Size() <= d.size {
+		return nil
+	}
+	from := d.size
+	d.size = info.Size()
+	return d.scan(from)
+}
+
+func (d *db) get(name string) (*utils.LazyMetadata, error) {
+	// another process may have cached it since the file was read, or compacted the file
+	lock, err := d.rlock()
+	if err != nil {
+		return nil, err
+	}
+	defer lock.Release()
+	entry, ok := d.entries[name]
+	if !ok {
+		return nil, nil
+	}
+	payload, err := d.readRecord(entry.Offset)
+	if err != nil {
+		return nil, &utils.CorruptError{File: "metadata cache entry", Hint: corruptHint, Err: err}
+	}
+	_, recordName, packument, err := parseRecord(payload)
+	if err == nil && recordName != name {
+		err = fmt.Errorf("the record at %d is for %s", entry.Offset, recordName)
+	}
+	if err != nil {
+		return nil, &utils.CorruptError{File: "metadata cache entry", Hint: corruptHint, Err: err}
+	}
+	metadata, err := utils.ReadMetadataEntry(name, packument)
+	if err != nil {
+		return nil, err
+	}
+	entry.LastUsed = time.Now().UnixNano()
+	d.dirty = true
+	return metadata, nil
+}
+
+// put appends a record, the lock has to be held
+func (d *db) put(metadata types.Metadata, fetchedAt time.Time) (*utils.LazyMetadata, error) {
+	var packument bytes.Buffer
+	if err := utils.WriteMetadataEntry(&packument, metadata); err != nil {
+		return nil, err
+	}
+	payload := binary.LittleEndian.AppendUint64(nil, uint64(fetchedAt.UnixNano()))
+	payload = binary.LittleEndian.AppendUint32(payload, uint32(len(metadata.Name)))
+	payload = append(payload, metadata.Name...)
+	payload = append(payload, packument.Bytes()...)
+
+	record := binary.LittleEndian.AppendUint32(nil, uint32(len(payload)))
+	record = binary.LittleEndian.AppendUint32(record, crc32.ChecksumIEEE(payload))
+	record = append(record, payload...)
+	offset := d.size
+	if _, err := d.file.WriteAt(record, offset); err != nil {
+		return nil, fmt.Errorf("failed to write metadata cache: %w", err)
+	}
+	d.size += int64(len(record))
+
+	if previous, ok := d.entries[metadata.Name]; ok {
+		d.dead += previous.Length
+	}
+	d.entries[metadata.Name] = &types.CacheIndexEntry{
+		Name:      metadata.Name,
+		Offset:    offset,
+		Length:    int64(len(record)),
+		FetchedAt: fetchedAt.UnixNano(),
+		LastUsed:  fetchedAt.UnixNano(),
+	}
+	d.dirty = true
+	return utils.ReadMetadataEntry(metadata.Name, packument.Bytes())
+}
+
+// drop forgets the packument of a package. Its record stays in the file until it's compacted
+func (d *db) drop(name string) {
+	if entry, ok := d.entries[name]; ok {
+		d.dead += entry.Length
+		delete(d.entries, name)
+		d.dirty = true
+	}
+}
+
+// flush writes the index, compacting the file first when most of it is records nothing points at anymore.
+// The lock has to be held
+func (d *db) flush() error {
+	if d.dead > (d.size-dbHeaderSize)/2 {
+		return d.compact()
+	}
+	if !d.dirty {
+		return nil
+	}
+	return d.writeIndex()
+}
+
+// compact rewrites the file with only the latest record of every package in it, the lock has to be held
+func (d *db) compact() error {
+	dbFile := filepath.Join(d.dir, dbFileName)
+	tempFile, err := os.Create(dbFile + ".tmp")
+	if err != nil {
+		return fmt.Errorf("failed to compact metadata cache: %w", err)
+	}
+	writer := bufio.NewWriter(tempFile)
+	_, _ = writer.Write(dbHeader())
+
+	names := make([]string, 0, len(d.entries))
+	for name := range d.entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	entries := make(map[string]*types.CacheIndexEntry, len(names))
+	offset := int64(dbHeaderSize)
+	for _, name := range names {
+		entry := *d.entries[name]
+		record, err := d.read(entry.Offset, entry.Length)
+		if err != nil {
+			// whatever can't be read is fetched again
+			continue
+		}
+		if _, err := writer.Write(record); err != nil {
+			tempFile.Close()
+			return fmt.Errorf("failed to compact metadata cache: %w", err)
+		}
+		entry.Offset = offset
+		entries[name] = &entry
+		offset += entry.Length
+	}
+	if err := writer.Flush(); err != nil {
+		tempFile.Close()
+		return fmt.Errorf("failed to compact metadata cache: %w", err)
+	}
+	if err := tempFile.Close(); err != nil {
+		return fmt.Errorf("failed to compact metadata cache: %w", err)
+	}
+
+	d.close()
+	if err := os.Rename(dbFile+".tmp", dbFile); err != nil {
+		return fmt.Errorf("failed to compact metadata cache: %w", err)
+	}
+	if err := d.open(); err != nil {
+		return err
+	}
+	d.entries, d.dead, d.corrupt = entries, 0, false
+	return d.writeIndex()
+}
+
+// migrate moves the packuments cached one file per package by older versions of yap into the cache file,
+// once. Only files that decode as such a packument are moved, anything else in the directory is left alone
+func (d *db) migrate() error {
+	migratedFile := filepath.Join(d.dir, migratedFileName)
+	if _, err := os.Stat(migratedFile); err == nil {
+		return nil
+	}
+	dirEntries, err := os.ReadDir(d.dir)
+	if err != nil {
+		return fmt.Errorf("failed to read cache directory: %w", err)
+	}
+	for _, dirEntry := range dirEntries {
+		switch name := dirEntry.Name(); {
+		case !dirEntry.Type().IsRegular(), name == dbFileName, name == indexFileName, name == lockFileName, filepath.Ext(name) == ".tmp":
+			continue
+		}
+		oldFile := filepath.Join(d.dir, dirEntry.Name())
+		info, err := dirEntry.Info()
+		if err != nil {
+			continue
+		}
+		data, err := os.ReadFile(oldFile)
+		if err != nil {
+			continue
+		}
+		metadata, err := utils.ReadMetadata(bytes.NewReader(data))
+		if err != nil {
+			continue
+		}
+		if _, err := d.put(*metadata, info.ModTime()); err != nil {
+			return err
+		}
+		d.entries[metadata.Name].LastUsed = accessTime(info).UnixNano()
+		if err := os.Remove(oldFile); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove old cache entry %s: %w", oldFile, err)
+		}
+	}
+	if err := os.WriteFile(migratedFile, nil, 0644); err != nil {
+		return fmt.Errorf("failed to record the metadata cache migration: %w", err)
+	}
+	return nil
+}
+
+// fileSize is how much the cache takes up on disk
+func (d *db) fileSize() int64 {
+	size := d.size
+	if info, err := os.Stat(filepath.Join(d.dir, indexFileName)); err == nil {
+		size += info.Size()
+	}
+	return size
+}
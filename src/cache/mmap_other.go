@@ -0,0 +1,17 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd && !dragonfly
+
+package cache
+
+import (
+	"io"
+	"os"
+)
+
+// mapFile reads the file into memory where mapping it isn't supported
+func mapFile(file *os.File, size int64) ([]byte, func() error, error) {
+	data := make([]byte, size)
+	if _, err := file.ReadAt(data, 0); err != nil && err != io.EOF {
+		return nil, nil, err
+	}
+	return data, func() error { return nil }, nil
+}
@@ -0,0 +1,76 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/Eyepan/yap/src/cache"
+	"github.com/Eyepan/yap/src/config"
+	"github.com/Eyepan/yap/src/utils"
+)
+
+func HandleCache() {
+	args := os.Args
+	subCommand := "ls"
+	if len(args) > 2 {
+		subCommand = args[2]
+	}
+
+	switch subCommand {
+	case "ls":
+		entries, err := cache.List()
+		if err != nil {
+			log.Fatalf("Failed to list the metadata cache: %v", err)
+		}
+		var total int64
+		for _, entry := range entries {
+			fmt.Printf("%-40s %8s  fetched %s  used %s\n", entry.Name, utils.FormatSize(entry.Size), entry.FetchedAt.Format(time.DateTime), entry.LastUsed.Format(time.DateTime))
+			total += entry.Size
+		}
+		fmt.Printf("%d entries, %s\n", len(entries), utils.FormatSize(total))
+	case "view":
+		if len(args) <= 3 {
+			log.Fatalf("Must pass in the package to view")
+		}
+		metadata, err := cache.View(args[3])
+		if err != nil {
+			log.Fatalf("Failed to read the cached metadata: %v", err)
+		}
+		jsonData, err := json.MarshalIndent(metadata, "", "\t")
+		if err != nil {
+			log.Fatalf("failed to parse cached metadata into json %v", err)
+		}
+		fmt.Println(string(jsonData))
+	case "clean":
+		var removed int
+		var freed int64
+		var err error
+		if len(args) > 3 {
+			removed, freed, err = cache.Clean(args[3:])
+		} else {
+			removed, freed, err = cache.CleanAll()
+		}
+		if err != nil {
+			log.Fatalf("Failed to clean the metadata cache: %v", err)
+		}
+		fmt.Printf("Removed %d entries, freed %s\n", removed, utils.FormatSize(freed))
+	case "evict":
+		conf, err := config.ReadYapConfig()
+		if err != nil {
+			log.Fatalf("Failed to load configurations: %v", err)
+		}
+		if conf.CacheMaxSize <= 0 {
+			log.Fatalf("There's no cache size limit, set one with `yap config set cacheMaxSize <size>`")
+		}
+		removed, freed, err := cache.Evict(conf.CacheMaxSize)
+		if err != nil {
+			log.Fatalf("Failed to shrink the metadata cache: %v", err)
+		}
+		fmt.Printf("Removed %d entries, freed %s\n", removed, utils.FormatSize(freed))
+	default:
+		log.Fatalf("Unknown cache command %s", subCommand)
+	}
+}
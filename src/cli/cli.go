@@ -5,9 +5,12 @@ import (
 	"log"
 	"log/slog"
 	"os"
+	"strconv"
+	"strings"
 
 	"github.com/Eyepan/yap/src/config"
 	"github.com/Eyepan/yap/src/logger"
+	"github.com/Eyepan/yap/src/store"
 )
 
 func HandleArgs() {
@@ -29,12 +32,27 @@ func HandleArgs() {
 	default:
 		log.Fatalf("failed to read config file, logLevel is not 'debug', 'warn', 'info', 'error' or ''")
 	}
-	args := os.Args
+	if err := store.MigrateLayout(); err != nil {
+		log.Fatalf("Failed to migrate the store: %v", err)
+	}
+	args, options, err := parseOptions(os.Args)
+	if err != nil {
+		slog.Error(err.Error())
+		HandleHelp()
+		os.Exit(-1)
+	}
+	// subcommands read their arguments from os.Args, so they shouldn't see the options
+	os.Args = args
 	if len(args) < 2 {
 		slog.Error("Must input a command")
 		HandleHelp()
 		os.Exit(-1)
 	}
+	// the other commands would quietly run on the current package only
+	if len(options.given) > 0 && args[1] != "run" {
+		slog.Error(fmt.Sprintf("%s can only be used with run, not %s", strings.Join(options.given, ", "), args[1]))
+		os.Exit(-1)
+	}
 	switch args[1] {
 	case "install":
 		logger.PrintCurrentCommand(args[1])
@@ -47,6 +65,27 @@ func HandleArgs() {
 		HandleAdd()
 	case "config":
 		HandleConfig()
+	case "store":
+		HandleStore()
+	case "cache":
+		HandleCache()
+	case "run":
+		HandleRun(&options)
+	case "init":
+		HandleInit()
+	case "import":
+		HandleImport()
+	case "lockfile":
+		HandleLockfile()
+	case "pack":
+		HandlePack()
+	case "publish":
+		logger.PrintCurrentCommand(args[1])
+		HandlePublish()
+	case "dlx":
+		HandleDlx()
+	case "exec":
+		HandleExec()
 	case "update":
 		logger.PrintCurrentCommand(args[1])
 		slog.Error("hasn't been implemented yet, sorry")
@@ -65,17 +104,115 @@ func HandleArgs() {
 
 }
 
+// Options are the flags that go before the command, e.g. `yap -r run build`
+type Options struct {
+	Recursive       bool
+	Filters         []string
+	Concurrency     int
+	ContinueOnError bool
+	given           []string // the options on the command line, which commands that don't take them refuse
+}
+
+func parseOptions(args []string) ([]string, Options, error) {
+	options := Options{Concurrency: 4}
+	i := 1
+	for ; i < len(args); i++ {
+		arg := args[i]
+		if len(arg) == 0 || arg[0] != '-' {
+			break
+		}
+		name, value, hasValue := strings.Cut(arg, "=")
+		// flags taking a value accept both --flag value and --flag=value
+		nextValue := func() (string, error) {
+			if hasValue {
+				return value, nil
+			}
+			if i+1 >= len(args) {
+				return "", fmt.Errorf("%s needs a value", name)
+			}
+			i++
+			return args[i], nil
+		}
+		options.given = append(options.given, name)
+		switch name {
+		case "-r", "--recursive":
+			options.Recursive = true
+		case "--filter", "-F":
+			filter, err := nextValue()
+			if err != nil {
+				return nil, options, err
+			}
+			options.Filters = append(options.Filters, filter)
+		case "--concurrency":
+			concurrency, err := nextValue()
+			if err != nil {
+				return nil, options, err
+			}
+			if options.Concurrency, err = strconv.Atoi(concurrency); err != nil || options.Concurrency < 1 {
+				return nil, options, fmt.Errorf("--concurrency must be a positive number, got %s", concurrency)
+			}
+		case "--continue-on-error", "--no-bail":
+			options.ContinueOnError = true
+		default:
+			return nil, options, fmt.Errorf("unknown option %s", arg)
+		}
+	}
+	return append([]string{args[0]}, args[i:]...), options, nil
+}
+
 func HandleHelp() {
 	fmt.Print(`YAP: Yet-Another-Package manager
 	Usage:
-		./yap <command>
+		./yap [options] <command>
+	Options:
+		-r, --recursive
+			runs the command in every workspace package, dependencies first
+		--filter <selector>
+			runs the command in the matching workspace packages. a selector is a package name or ./directory,
+			<selector>... adds its dependencies, ...<selector> adds its dependents and ^ leaves the match itself out
+		--concurrency <n>
+			how many workspace packages to run at the same time (default 4)
+		--continue-on-error
+			keeps going after a package fails instead of stopping
 	Commands:
 		help
 			prints this out!
-		install
-			installs a list of packages
-		list
-			list out packages from lockfile
+		install [--ignore-scripts]
+			installs a list of packages. only packages in trustedDependencies get to run their install scripts
+			then the project and its workspaces run their own preinstall, install, postinstall and prepare scripts
+			keeps the lockfiles the project has (yap.lockb for new ones), switch to a sorted, diffable yap.lock with 'yap config set lockfileFormat text'
+		list [--text]
+			list out packages from lockfile, as json or in the yap.lock format
+		run <script> [-- args]
+			runs a script from package.json along with its pre and post scripts. lists the scripts without a name
+		init [-y] [<initializer> [args]]
+			creates a package.json, or runs create-<initializer>
+		import [<lockfile>] [--force]
+			writes the yap lockfile from package-lock.json, npm-shrinkwrap.json, pnpm-lock.yaml or yarn.lock (v1)
+		lockfile export [--format npm]
+			writes package-lock.json (lockfileVersion 3) from the yap lockfile. 'yap config set lockfileExport npm' keeps it updated on every install
+		pack [--dry-run] [--pack-destination <dir>] [--ignore-scripts]
+			packs the project into <name>-<version>.tgz, the way it'd be published
+		publish [--tag <tag>] [--access public|restricted] [--dry-run] [--ignore-scripts]
+			packs the project and publishes it to the registry (or publishConfig.registry)
+		dlx [-p <package-name>] <package-name>@<!version> [args]
+			runs a binary from a package without adding it to the project
+		exec <binary> [args]
+			runs a binary from node_modules/.bin
+		store status
+			shows how big the store is and how many of its packages projects still use
+		store prune
+			removes the packages and metadata no project uses anymore
+		store verify [--no-repair]
+			checks the store against what was extracted, downloading modified or partial packages again
+		cache ls
+			lists the cached package metadata with its size, when it was fetched and last used
+		cache view <package-name>
+			prints the cached metadata of a package as json
+		cache clean [<package-name>...]
+			removes the cached metadata of the given packages, or all of it
+		cache evict
+			removes the least recently used metadata until the cache fits in cacheMaxSize (also done after every install)
 		add	<package-name>@<!version> 
 			adds this particular package to package.json and install it in the repository
 		update <package-name>
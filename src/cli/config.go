@@ -6,8 +6,11 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"slices"
+	"strings"
 
 	"github.com/Eyepan/yap/src/config"
+	"github.com/Eyepan/yap/src/lockexport"
 	"github.com/Eyepan/yap/src/utils"
 )
 
@@ -52,6 +55,18 @@ func HandleConfig() {
 				{
 					fmt.Println(conf.LogLevel)
 				}
+			case "cacheMaxSize":
+				{
+					fmt.Println(conf.CacheMaxSize)
+				}
+			case "lockfileExport":
+				{
+					fmt.Println(conf.LockfileExport)
+				}
+			case "lockfileFormat":
+				{
+					fmt.Println(conf.LockfileFormat)
+				}
 			default:
 				{
 					log.Fatalf("unknown key in config %s", args[3])
@@ -76,6 +91,29 @@ func HandleConfig() {
 				{
 					conf.LogLevel = args[4]
 				}
+			case "cacheMaxSize":
+				{
+					size, err := utils.ParseSize(args[4])
+					if err != nil {
+						log.Fatalf("failed to parse cache max size: %v", err)
+					}
+					conf.CacheMaxSize = size
+				}
+			case "lockfileExport":
+				{
+					if _, ok := lockexport.Formats[args[4]]; !ok && args[4] != "" {
+						log.Fatalf("unknown lockfile format %s, expected one of %s", args[4], lockexport.FormatNames())
+					}
+					conf.LockfileExport = args[4]
+				}
+			case "lockfileFormat":
+				{
+					// an empty format goes back to keeping whatever lockfiles each project has
+					if args[4] != "" && !slices.Contains(utils.LockfileFormats, args[4]) {
+						log.Fatalf("unknown lockfile format %s, expected one of %s, or '' to keep what each project has", args[4], strings.Join(utils.LockfileFormats, ", "))
+					}
+					conf.LockfileFormat = args[4]
+				}
 			default:
 				{
 					log.Fatalf("unknown key in config %s", args[3])
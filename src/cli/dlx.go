@@ -0,0 +1,73 @@
+package cli
+
+import (
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/Eyepan/yap/src/dlx"
+	"github.com/Eyepan/yap/src/scripts"
+)
+
+func HandleDlx() {
+	args := os.Args[2:]
+	binName := ""
+	if len(args) >= 2 && (args[0] == "--package" || args[0] == "-p") {
+		// yap dlx -p <package> <bin> runs a binary that isn't named after its package
+		if len(args) < 3 {
+			log.Fatalf("Must pass in the binary to run from %s", args[1])
+		}
+		binName = args[2]
+		args = append([]string{args[1]}, args[3:]...)
+	}
+	if len(args) == 0 {
+		log.Fatalf("Must pass in the package to run")
+	}
+
+	name, versionRange := dlx.ParsePackageArg(args[0])
+	binPath, err := dlx.Prepare(name, versionRange, binName)
+	if err != nil {
+		log.Fatalf("Failed to prepare %s: %v", args[0], err)
+	}
+	runBinary(binPath, args[1:], []string{filepath.Dir(binPath)})
+}
+
+func HandleExec() {
+	args := os.Args[2:]
+	if len(args) == 0 {
+		log.Fatalf("Must pass in the binary to run")
+	}
+	binDirs := scripts.BinDirs(".")
+	for _, binDir := range binDirs {
+		binPath := filepath.Join(binDir, args[0])
+		if _, err := os.Stat(binPath); err == nil {
+			runBinary(binPath, args[1:], binDirs)
+			return
+		}
+	}
+	log.Fatalf("%s isn't installed, it's not in any node_modules/.bin. Did you mean `yap dlx %s`?", args[0], args[0])
+}
+
+// runBinary runs a package binary in the current directory with binDirs on PATH, exiting with its exit code
+func runBinary(binPath string, args []string, binDirs []string) {
+	cmd := exec.Command(binPath, args...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(), "PATH="+joinPath(binDirs))
+	if err := cmd.Run(); err != nil {
+		slog.Error(fmt.Sprintf("%s failed: %v", filepath.Base(binPath), err))
+		os.Exit(scripts.ExitCode(err))
+	}
+}
+
+func joinPath(binDirs []string) string {
+	path := os.Getenv("PATH")
+	for i := len(binDirs) - 1; i >= 0; i-- {
+		path = binDirs[i] + string(os.PathListSeparator) + path
+	}
+	return path
+}
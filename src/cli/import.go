@@ -0,0 +1,59 @@
+package cli
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/Eyepan/yap/src/config"
+	"github.com/Eyepan/yap/src/lockimport"
+	"github.com/Eyepan/yap/src/utils"
+	"github.com/Eyepan/yap/src/workspace"
+)
+
+// HandleImport writes the yap lockfile from the lockfile of another package manager, so the first install
+// gets the same versions it had
+func HandleImport() {
+	force := false
+	source := ""
+	for _, arg := range os.Args[2:] {
+		switch arg {
+		case "--force", "-f":
+			force = true
+		default:
+			if source != "" {
+				log.Fatalf("Unknown import option %s", arg)
+			}
+			source = arg
+		}
+	}
+
+	rootDir, err := workspace.FindRoot(".")
+	if err != nil {
+		log.Fatalf("Failed to find package.json: %v", err)
+	}
+	if err := os.Chdir(rootDir); err != nil {
+		log.Fatalf("Failed to change to workspace root %s: %v", rootDir, err)
+	}
+	if exists, _ := utils.DoesLockfileExist(); exists && !force {
+		log.Fatalf("The project already has a yap lockfile, pass --force to replace it")
+	}
+	if source == "" {
+		if source, err = lockimport.Detect("."); err != nil {
+			log.Fatalf("Failed to find a lockfile to import: %v", err)
+		}
+	}
+
+	conf, err := config.ReadYapConfig()
+	if err != nil {
+		log.Fatalf("Failed to load configurations: %v", err)
+	}
+	lockfile, err := lockimport.Import(".", source, conf)
+	if err != nil {
+		log.Fatalf("Failed to import %s: %v", source, err)
+	}
+	if err := utils.WriteLock(*lockfile, conf.LockfileFormat); err != nil {
+		log.Fatalf("Failed to write lockfile: %v", err)
+	}
+	fmt.Printf("Imported %d packages from %s into the yap lockfile, run `yap install` to install them\n", len(lockfile.Resolutions), source)
+}
@@ -0,0 +1,135 @@
+package cli
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/Eyepan/yap/src/dlx"
+	"github.com/Eyepan/yap/src/packagejson"
+)
+
+var invalidNameCharacters = regexp.MustCompile(`[^a-z0-9._~-]+`)
+
+func HandleInit() {
+	args := os.Args[2:]
+	yes := false
+	if len(args) > 0 && (args[0] == "-y" || args[0] == "--yes") {
+		yes = true
+		args = args[1:]
+	}
+	if len(args) > 0 {
+		runInitializer(args[0], args[1:])
+		return
+	}
+
+	// an existing package.json is kept as it is, with its values as the defaults
+	document, err := packagejson.ReadDocument(".")
+	if os.IsNotExist(err) {
+		document = packagejson.NewDocument()
+	} else if err != nil {
+		log.Fatalf("Failed to read package.json: %v", err)
+	}
+	cwd, err := os.Getwd()
+	if err != nil {
+		log.Fatalf("Failed to get current directory: %v", err)
+	}
+
+	var reader *bufio.Reader
+	if !yes {
+		reader = bufio.NewReader(os.Stdin)
+	}
+	for _, field := range []struct {
+		key, prompt, fallback string
+	}{
+		{"name", "package name", defaultPackageName(cwd)},
+		{"version", "version", "1.0.0"},
+		{"description", "description", ""},
+		{"main", "entry point", "index.js"},
+		{"type", "type", "commonjs"},
+		{"license", "license", "ISC"},
+	} {
+		value := document.String(field.key)
+		if value == "" {
+			value = field.fallback
+		}
+		if reader != nil {
+			if err := prompt(reader, field.prompt, &value); err != nil {
+				log.Fatalf("Failed to read %s: %v", field.prompt, err)
+			}
+		}
+		if value != document.String(field.key) && (value != "" || document.Root().Has(field.key)) {
+			if err := document.SetString(field.key, value); err != nil {
+				log.Fatalf("Failed to set %s: %v", field.key, err)
+			}
+		}
+		if field.key == "main" && !document.Root().Has("scripts") {
+			// npm puts the scripts right after the entry point
+			if err := document.Root().Set("scripts", map[string]string{"test": `echo "Error: no test specified" && exit 1`}); err != nil {
+				log.Fatalf("Failed to set scripts: %v", err)
+			}
+		}
+	}
+
+	if err := document.Write("."); err != nil {
+		log.Fatalf("Failed to write package.json: %v", err)
+	}
+	fmt.Printf("Wrote to %s\n", filepath.Join(cwd, "package.json"))
+}
+
+// runInitializer runs create-<initializer> the way `yap dlx` would, so `yap init vite` runs create-vite
+func runInitializer(initializer string, args []string) {
+	name, versionRange := dlx.ParsePackageArg(initializer)
+	name = initializerPackage(name)
+	binPath, err := dlx.Prepare(name, versionRange, "")
+	if err != nil {
+		log.Fatalf("Failed to prepare %s: %v", name, err)
+	}
+	runBinary(binPath, args, []string{filepath.Dir(binPath)})
+}
+
+// initializerPackage maps foo to create-foo, @scope to @scope/create and @scope/foo to @scope/create-foo
+func initializerPackage(name string) string {
+	if !strings.HasPrefix(name, "@") {
+		return "create-" + name
+	}
+	scope, rest, ok := strings.Cut(name, "/")
+	if !ok {
+		return scope + "/create"
+	}
+	return scope + "/create-" + rest
+}
+
+func defaultPackageName(dir string) string {
+	name := invalidNameCharacters.ReplaceAllString(strings.ToLower(filepath.Base(dir)), "-")
+	name = strings.TrimLeft(name, "._-")
+	if name == "" {
+		return "package"
+	}
+	return name
+}
+
+// prompt asks for a value on stdin, keeping the current one on an empty line or when stdin is done
+func prompt(reader *bufio.Reader, question string, value *string) error {
+	if *value != "" {
+		fmt.Printf("%s: (%s) ", question, *value)
+	} else {
+		fmt.Printf("%s: ", question)
+	}
+	line, err := reader.ReadString('\n')
+	if err != nil && err != io.EOF {
+		return err
+	}
+	if answer := strings.TrimSpace(line); answer != "" {
+		*value = answer
+	}
+	if err == io.EOF {
+		fmt.Println()
+	}
+	return nil
+}
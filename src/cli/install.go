@@ -2,17 +2,49 @@ package cli
 
 import (
 	"log"
+	"os"
 
 	"github.com/Eyepan/yap/src/install"
+	"github.com/Eyepan/yap/src/overrides"
 	"github.com/Eyepan/yap/src/packagejson"
+	"github.com/Eyepan/yap/src/store"
+	"github.com/Eyepan/yap/src/workspace"
 )
 
 func HandleInstall() {
+	// workspace packages are installed from the root, so there's a single lockfile and virtual store
+	rootDir, err := workspace.FindRoot(".")
+	if err != nil {
+		log.Fatalf("Failed to find package.json: %v", err)
+	}
+	if err := os.Chdir(rootDir); err != nil {
+		log.Fatalf("Failed to change to workspace root %s: %v", rootDir, err)
+	}
+
 	pkgJSON, err := packagejson.ParsePackageJSON()
 	if err != nil {
 		log.Fatalf("Failed to parse package.json: %v", err)
 	}
-
-	baseDependencies := packagejson.GetAllDependencies(&pkgJSON)
-	install.InstallPackages(&baseDependencies)
+	importers, err := workspace.Importers(".", &pkgJSON)
+	if err != nil {
+		log.Fatalf("Failed to read the workspace: %v", err)
+	}
+	packageOverrides, err := overrides.Parse(&pkgJSON)
+	if err != nil {
+		log.Fatalf("Failed to read overrides: %v", err)
+	}
+	options := install.Options{
+		Overrides:           packageOverrides,
+		TrustedDependencies: pkgJSON.TrustedDependencies,
+		Unregistered:        os.Getenv(store.UnregisteredEnv) != "",
+	}
+	for _, arg := range os.Args[2:] {
+		switch arg {
+		case "--ignore-scripts":
+			options.IgnoreScripts = true
+		default:
+			log.Fatalf("Unknown install option %s", arg)
+		}
+	}
+	install.InstallPackages(importers, &options)
 }
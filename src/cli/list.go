@@ -1,9 +1,12 @@
 package cli
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"log"
+	"os"
+	"slices"
 
 	"github.com/Eyepan/yap/src/utils"
 )
@@ -13,6 +16,12 @@ func HandleList() {
 	if err != nil {
 		log.Fatalf("failed to read lockfile %v", err)
 	}
+	if slices.Contains(os.Args[2:], "--text") {
+		var buf bytes.Buffer
+		utils.WriteTextLockfile(&buf, *lockBin)
+		fmt.Print(buf.String())
+		return
+	}
 	jsonData, err := json.MarshalIndent(lockBin, "", "\t")
 	if err != nil {
 		log.Fatalf("failed to parse lockfile into json %v", err)
@@ -0,0 +1,48 @@
+package cli
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/Eyepan/yap/src/lockexport"
+	"github.com/Eyepan/yap/src/utils"
+	"github.com/Eyepan/yap/src/workspace"
+)
+
+func HandleLockfile() {
+	if len(os.Args) < 3 || os.Args[2] != "export" {
+		log.Fatalf("Usage: yap lockfile export [--format <%s>]", lockexport.FormatNames())
+	}
+	format := "npm"
+	args := os.Args[3:]
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "--format":
+			if i+1 >= len(args) {
+				log.Fatalf("--format needs one of %s", lockexport.FormatNames())
+			}
+			i++
+			format = args[i]
+		default:
+			log.Fatalf("Unknown option %s", args[i])
+		}
+	}
+
+	rootDir, err := workspace.FindRoot(".")
+	if err != nil {
+		log.Fatalf("Failed to find package.json: %v", err)
+	}
+	if err := os.Chdir(rootDir); err != nil {
+		log.Fatalf("Failed to change to workspace root %s: %v", rootDir, err)
+	}
+	lockfile, err := utils.ReadLock()
+	if err != nil {
+		log.Fatalf("Failed to read lockfile, run `yap install` first: %v", err)
+	}
+	fileName, err := lockexport.Export(".", format, lockfile)
+	if err != nil {
+		log.Fatalf("Failed to export the lockfile: %v", err)
+	}
+	fmt.Printf("Wrote %s\n", fileName)
+}
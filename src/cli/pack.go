@@ -0,0 +1,93 @@
+package cli
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/Eyepan/yap/src/pack"
+	"github.com/Eyepan/yap/src/packagejson"
+	"github.com/Eyepan/yap/src/scripts"
+	"github.com/Eyepan/yap/src/utils"
+)
+
+// packEvents are the scripts npm runs before packing, postpack runs once the tarball is written
+var packEvents = []string{"prepack", "prepare"}
+
+func HandlePack() {
+	dryRun, ignoreScripts := false, false
+	destination := "."
+	args := os.Args[2:]
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "--dry-run":
+			dryRun = true
+		case "--ignore-scripts":
+			ignoreScripts = true
+		case "--pack-destination":
+			if i+1 >= len(args) {
+				log.Fatalf("--pack-destination needs a directory")
+			}
+			i++
+			destination = args[i]
+		default:
+			log.Fatalf("Unknown option %s", args[i])
+		}
+	}
+
+	tarball, err := packProject(".", ignoreScripts)
+	if err != nil {
+		log.Fatalf("Failed to pack: %v", err)
+	}
+	printTarball(tarball)
+	if dryRun {
+		return
+	}
+
+	tarballPath := filepath.Join(destination, tarball.Filename)
+	if err := os.WriteFile(tarballPath, tarball.Data.Bytes(), 0644); err != nil {
+		log.Fatalf("Failed to write %s: %v", tarballPath, err)
+	}
+	if !ignoreScripts {
+		runPackEvent(".", "postpack")
+	}
+	fmt.Println(tarball.Filename)
+}
+
+// packProject runs the scripts that build the package before packing it, like npm pack does
+func packProject(dir string, ignoreScripts bool) (*pack.Tarball, error) {
+	if !ignoreScripts {
+		for _, event := range packEvents {
+			runPackEvent(dir, event)
+		}
+	}
+	return pack.Pack(dir)
+}
+
+func runPackEvent(dir, event string) {
+	pkgJSON, err := packagejson.ReadPackageJSON(dir)
+	if err != nil {
+		log.Fatalf("Failed to read package.json: %v", err)
+	}
+	if err := scripts.RunEvent(dir, &pkgJSON, event, os.Stderr, os.Stderr); err != nil {
+		os.Exit(scripts.ExitCode(err))
+	}
+}
+
+func printTarball(tarball *pack.Tarball) {
+	fmt.Printf("📦 %s@%s\n", tarball.Name, tarball.Version)
+	fmt.Println("Tarball Contents")
+	for _, file := range tarball.Files {
+		fmt.Printf("%-8s %s\n", utils.FormatSize(file.Size), file.Path)
+	}
+	fmt.Println("Tarball Details")
+	fmt.Printf("name:          %s\n", tarball.Name)
+	fmt.Printf("version:       %s\n", tarball.Version)
+	fmt.Printf("filename:      %s\n", tarball.Filename)
+	fmt.Printf("package size:  %s\n", utils.FormatSize(int64(tarball.Data.Len())))
+	fmt.Printf("unpacked size: %s\n", utils.FormatSize(tarball.UnpackedSize))
+	fmt.Printf("shasum:        %s\n", tarball.Shasum)
+	fmt.Printf("integrity:     %s\n", tarball.Integrity)
+	fmt.Printf("total files:   %d\n", len(tarball.Files))
+}
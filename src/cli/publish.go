@@ -0,0 +1,100 @@
+package cli
+
+import (
+	"fmt"
+	"log"
+	"log/slog"
+	"net/http"
+	"os"
+
+	"github.com/Eyepan/yap/src/config"
+	"github.com/Eyepan/yap/src/packagejson"
+	"github.com/Eyepan/yap/src/publish"
+)
+
+func HandlePublish() {
+	conf, err := config.ReadYapConfig()
+	if err != nil {
+		log.Fatalf("Failed to load configurations: %v", err)
+	}
+	options := publish.Options{Registry: conf.Registry}
+	dryRun, ignoreScripts := false, false
+	args := os.Args[2:]
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "--dry-run":
+			dryRun = true
+		case "--ignore-scripts":
+			ignoreScripts = true
+		case "--tag", "--access":
+			if i+1 >= len(args) {
+				log.Fatalf("%s needs a value", args[i])
+			}
+			if args[i] == "--tag" {
+				options.Tag = args[i+1]
+			} else {
+				options.Access = args[i+1]
+			}
+			i++
+		default:
+			log.Fatalf("Unknown option %s", args[i])
+		}
+	}
+
+	manifest, err := packagejson.ReadDocument(".")
+	if err != nil {
+		log.Fatalf("Failed to read package.json: %v", err)
+	}
+	publishConfig, private, err := publish.ReadPublishConfig(manifest)
+	if err != nil {
+		log.Fatalf("Failed to read package.json: %v", err)
+	}
+	if private {
+		log.Fatalf("%s is marked private, remove \"private\": true from package.json to publish it", manifest.Name())
+	}
+	// the command line wins over publishConfig, which wins over the config file
+	tag, access := options.Tag, options.Access
+	options.Apply(publishConfig)
+	options.Apply(publish.PublishConfig{Tag: tag, Access: access})
+	if err := publish.ValidateAccess(options.Access); err != nil {
+		log.Fatalf("Failed to publish: %v", err)
+	}
+	// the auth token belongs to the configured registry, publishConfig.registry may point somewhere else
+	options.AuthToken, options.TokenRegistry = config.AuthTokenFor(conf, options.Registry), conf.Registry
+	if options.AuthToken == "" && conf.AuthToken != "" {
+		slog.Warn(fmt.Sprintf("the auth token is for %s, publishing to %s without one", conf.Registry, options.Registry))
+	}
+
+	if !ignoreScripts {
+		runPackEvent(".", "prepublishOnly")
+	}
+	tarball, err := packProject(".", ignoreScripts)
+	if err != nil {
+		log.Fatalf("Failed to pack: %v", err)
+	}
+	printTarball(tarball)
+	// the scripts may have changed package.json, what's published is what got packed
+	if manifest, err = packagejson.ReadDocument("."); err != nil {
+		log.Fatalf("Failed to read package.json: %v", err)
+	}
+	if !ignoreScripts {
+		runPackEvent(".", "postpack")
+	}
+
+	tag = options.Tag
+	if tag == "" {
+		tag = "latest"
+	}
+	if dryRun {
+		fmt.Printf("Would publish %s@%s to %s with the tag %s (dry run)\n", tarball.Name, tarball.Version, options.Registry, tag)
+		return
+	}
+	if err := publish.Publish(http.DefaultClient, manifest, tarball, &options); err != nil {
+		log.Fatalf("Failed to publish %s@%s: %v", tarball.Name, tarball.Version, err)
+	}
+	if !ignoreScripts {
+		runPackEvent(".", "publish")
+		runPackEvent(".", "postpublish")
+	}
+	fmt.Printf("+ %s@%s (%s)\n", tarball.Name, tarball.Version, tag)
+}
@@ -0,0 +1,121 @@
+package cli
+
+import (
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/Eyepan/yap/src/logger"
+	"github.com/Eyepan/yap/src/packagejson"
+	"github.com/Eyepan/yap/src/scripts"
+	"github.com/Eyepan/yap/src/workspace"
+)
+
+func HandleRun(options *Options) {
+	args := os.Args[2:]
+	if len(args) == 0 {
+		listScripts()
+		return
+	}
+	script, scriptArgs := args[0], args[1:]
+	if len(scriptArgs) > 0 && scriptArgs[0] == "--" {
+		scriptArgs = scriptArgs[1:]
+	}
+
+	if options.Recursive || len(options.Filters) > 0 {
+		runRecursive(options, script, scriptArgs)
+		return
+	}
+
+	pkgJSON, err := packagejson.ParsePackageJSON()
+	if err != nil {
+		log.Fatalf("Failed to parse package.json: %v", err)
+	}
+	if _, ok := pkgJSON.Scripts[script]; !ok {
+		slog.Error(fmt.Sprintf("missing script: %s", script))
+		listScripts()
+		os.Exit(1)
+	}
+	if err := scripts.RunScript(".", &pkgJSON, script, scriptArgs, os.Stdout, os.Stderr); err != nil {
+		slog.Error(fmt.Sprintf("%s failed: %v", script, err))
+		os.Exit(scripts.ExitCode(err))
+	}
+}
+
+// listScripts prints the scripts of package.json, lifecycle hooks included
+func listScripts() {
+	pkgJSON, err := packagejson.ParsePackageJSON()
+	if err != nil {
+		log.Fatalf("Failed to parse package.json: %v", err)
+	}
+	if len(pkgJSON.Scripts) == 0 {
+		fmt.Printf("%s has no scripts\n", pkgJSON.Name)
+		return
+	}
+	names := make([]string, 0, len(pkgJSON.Scripts))
+	for name := range pkgJSON.Scripts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmt.Printf("Scripts available in %s via `yap run`:\n", pkgJSON.Name)
+	for _, name := range names {
+		fmt.Printf("  %s\n    %s\n", name, pkgJSON.Scripts[name])
+	}
+}
+
+// runRecursive runs the script in every selected workspace package that has it, dependencies first
+func runRecursive(options *Options, script string, scriptArgs []string) {
+	cwdDir, err := filepath.Abs(".")
+	if err != nil {
+		log.Fatalf("Failed to get current directory: %v", err)
+	}
+	rootDir, err := workspace.FindRoot(cwdDir)
+	if err != nil {
+		log.Fatalf("Failed to find workspace root: %v", err)
+	}
+	packages, err := workspace.DiscoverPackages(rootDir)
+	if err != nil {
+		log.Fatalf("Failed to read workspace: %v", err)
+	}
+	graph := workspace.BuildGraph(packages)
+	selected, err := graph.Select(options.Filters, rootDir, cwdDir)
+	if err != nil {
+		log.Fatalf("Failed to select workspace packages: %v", err)
+	}
+
+	var outputMu sync.Mutex
+	ran := 0
+	errs := graph.Run(selected, options.Concurrency, !options.ContinueOnError, func(pkg *workspace.Package) error {
+		if _, ok := pkg.Manifest.Scripts[script]; !ok {
+			return nil
+		}
+		outputMu.Lock()
+		ran++
+		outputMu.Unlock()
+
+		prefix := fmt.Sprintf("%s %s: ", pkg.Manifest.Name, script)
+		stdout := &logger.PrefixWriter{Writer: os.Stdout, Prefix: prefix, Mu: &outputMu}
+		stderr := &logger.PrefixWriter{Writer: os.Stderr, Prefix: prefix, Mu: &outputMu}
+		err := scripts.RunScript(filepath.Join(rootDir, filepath.FromSlash(pkg.Dir)), &pkg.Manifest, script, scriptArgs, stdout, stderr)
+		stdout.Flush()
+		stderr.Flush()
+		if err != nil {
+			return fmt.Errorf("%s (%s): %w", pkg.Manifest.Name, pkg.Dir, err)
+		}
+		return nil
+	})
+
+	if ran == 0 {
+		slog.Warn(fmt.Sprintf("none of the selected packages have a %s script", script))
+	}
+	if len(errs) > 0 {
+		for _, err := range errs {
+			slog.Error(fmt.Sprintf("%s failed in %v", script, err))
+		}
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,72 @@
+package cli
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/Eyepan/yap/src/config"
+	"github.com/Eyepan/yap/src/store"
+	"github.com/Eyepan/yap/src/utils"
+)
+
+func HandleStore() {
+	subCommand := "status"
+	if len(os.Args) > 2 {
+		subCommand = os.Args[2]
+	}
+
+	switch subCommand {
+	case "status":
+		status, err := store.GetStatus()
+		if err != nil {
+			log.Fatalf("Failed to get store status: %v", err)
+		}
+		fmt.Printf("packages:       %d (%s)\n", status.Packages, utils.FormatSize(status.Size))
+		fmt.Printf("  referenced:   %d\n", status.ReferencedPackages)
+		fmt.Printf("  unreferenced: %d\n", status.UnreferencedPackages)
+		fmt.Printf("projects:       %d\n", status.Projects)
+		fmt.Printf("metadata cache: %d (%s)\n", status.CacheEntries, utils.FormatSize(status.CacheSize))
+	case "prune":
+		result, err := store.Prune()
+		if err != nil {
+			log.Fatalf("Failed to prune the store: %v", err)
+		}
+		fmt.Printf("Removed %d packages and %d metadata cache entries, reclaimed %s\n", result.Packages, result.CacheEntries, utils.FormatSize(result.Reclaimed))
+		if result.RemovedProjects > 0 {
+			fmt.Printf("Forgot %d projects that don't exist anymore\n", result.RemovedProjects)
+		}
+	case "verify":
+		repair := !(len(os.Args) > 3 && os.Args[3] == "--no-repair")
+		conf, err := config.ReadYapConfig()
+		if err != nil {
+			log.Fatalf("Failed to load configurations: %v", err)
+		}
+		result, err := store.Verify(conf, repair)
+		if result != nil {
+			for _, problem := range result.Problems {
+				switch {
+				case !repair:
+					fmt.Printf("❌ %s: %s\n", problem.ID, problem.Reason)
+				case problem.Repaired:
+					fmt.Printf("🔧 %s: %s, downloaded it again\n", problem.ID, problem.Reason)
+				default:
+					fmt.Printf("🗑️  %s: %s, removed it so the next install brings it back\n", problem.ID, problem.Reason)
+				}
+			}
+		}
+		if err != nil {
+			log.Fatalf("Failed to verify the store: %v", err)
+		}
+		fmt.Printf("Checked %d packages, %d had problems\n", result.Checked, len(result.Problems))
+		if len(result.Problems) > 0 {
+			if repair {
+				fmt.Println("Run `yap install` in your projects to link the repaired packages")
+			} else {
+				os.Exit(1)
+			}
+		}
+	default:
+		log.Fatalf("Unknown store command %s", subCommand)
+	}
+}
@@ -3,7 +3,9 @@ package config
 import (
 	"bytes"
 	"fmt"
+	"net/url"
 	"os"
+	"strings"
 
 	"github.com/Eyepan/yap/src/types"
 	"github.com/Eyepan/yap/src/utils"
@@ -37,5 +39,39 @@ func ReadYapConfig() (*types.YapConfig, error) {
 		return nil, fmt.Errorf("failed to read config file in %s: %w", configFile, err)
 	}
 	buf := bytes.NewReader(data)
-	return utils.ReadConfig(buf)
+	conf, err := utils.ReadConfig(buf)
+	if err != nil {
+		return nil, err
+	}
+	// a config from an older yap is written again in the current format
+	var current bytes.Buffer
+	if err := utils.WriteConfig(&current, conf); err == nil && !bytes.Equal(current.Bytes(), data) {
+		if err := os.WriteFile(configFile, current.Bytes(), 0644); err != nil {
+			return nil, fmt.Errorf("failed to upgrade config file in %s: %w", configFile, err)
+		}
+	}
+	return conf, nil
+}
+
+// AuthTokenFor returns the auth token to send with a request to target. The token is for the configured
+// registry, so anything on another host, like a tarball url or another registry, gets none, and neither does
+// plain http on the host of an https registry
+func AuthTokenFor(conf *types.YapConfig, target string) string {
+	if conf.AuthToken == "" || !SameOrigin(conf.Registry, target) {
+		return ""
+	}
+	return conf.AuthToken
+}
+
+// SameOrigin reports whether two urls point at the same host and port with the same scheme
+func SameOrigin(a, b string) bool {
+	urlA, err := url.Parse(a)
+	if err != nil || urlA.Host == "" {
+		return false
+	}
+	urlB, err := url.Parse(b)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(urlA.Scheme, urlB.Scheme) && strings.EqualFold(urlA.Host, urlB.Host)
 }
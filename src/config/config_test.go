@@ -0,0 +1,29 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/Eyepan/yap/src/types"
+)
+
+func TestAuthTokenFor(t *testing.T) {
+	conf := &types.YapConfig{Registry: "https://registry.example.com/", AuthToken: "secret"}
+	for target, want := range map[string]string{
+		"https://registry.example.com/pkg":                 "secret",
+		"https://REGISTRY.example.com/pkg/-/pkg-1.0.0.tgz": "secret",
+		"http://registry.example.com/pkg":                  "",
+		"https://registry.example.com:8443/pkg":            "",
+		"https://cdn.example.com/pkg/-/pkg-1.0.0.tgz":      "",
+		"registry.example.com/pkg":                         "",
+	} {
+		if got := AuthTokenFor(conf, target); got != want {
+			t.Errorf("AuthTokenFor(%s) = %q, want %q", target, got, want)
+		}
+	}
+
+	// a registry served over plain http gets its token over plain http
+	conf.Registry = "http://localhost:4873"
+	if got := AuthTokenFor(conf, "http://localhost:4873/pkg"); got != "secret" {
+		t.Errorf("AuthTokenFor(http://localhost:4873/pkg) = %q, want the token", got)
+	}
+}
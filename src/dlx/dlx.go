@@ -0,0 +1,128 @@
+package dlx
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Eyepan/yap/src/filelock"
+	"github.com/Eyepan/yap/src/install"
+	"github.com/Eyepan/yap/src/packagejson"
+	"github.com/Eyepan/yap/src/types"
+	"github.com/Eyepan/yap/src/utils"
+)
+
+// installs older than this are redone, so `latest` doesn't stay the same forever
+const maxAge = 24 * time.Hour
+
+const installedMarker = ".yap_dlx_installed"
+
+// ParsePackageArg splits `name@spec` as typed on the command line, defaulting to latest
+func ParsePackageArg(arg string) (string, string) {
+	at := strings.LastIndex(arg, "@")
+	if at <= 0 {
+		return arg, "latest"
+	}
+	return arg[:at], arg[at+1:]
+}
+
+// Prepare installs the package and its dependencies into a cached prefix outside of the project
+// (the packages themselves come from the store) and returns the path of the binary to run.
+// An empty binName picks the package's only binary, or the one named after the package
+func Prepare(name, versionRange, binName string) (string, error) {
+	dlxDir, err := utils.GetDlxDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to get dlx directory: %w", err)
+	}
+	hash := sha256.Sum256([]byte(name + "@" + versionRange))
+	prefix := filepath.Join(dlxDir, hex.EncodeToString(hash[:])[:16])
+
+	// another dlx of the same package could be installing into the prefix, which starts by removing it. The
+	// lock sits next to the prefix so removing it leaves the lock alone
+	lock, err := filelock.Acquire(prefix + ".lock")
+	if err != nil {
+		return "", err
+	}
+	defer lock.Release()
+
+	if isStale(prefix) {
+		if err := installPrefix(prefix, name, versionRange); err != nil {
+			return "", err
+		}
+	}
+	return findBinary(prefix, name, binName)
+}
+
+func isStale(prefix string) bool {
+	info, err := os.Stat(filepath.Join(prefix, installedMarker))
+	return err != nil || time.Since(info.ModTime()) > maxAge
+}
+
+func installPrefix(prefix, name, versionRange string) error {
+	if err := os.RemoveAll(prefix); err != nil {
+		return fmt.Errorf("failed to clear %s: %w", prefix, err)
+	}
+	if err := os.MkdirAll(prefix, os.ModePerm); err != nil {
+		return fmt.Errorf("failed to create %s: %w", prefix, err)
+	}
+	dependencies := types.Dependencies{name: versionRange}
+	pkgJSON, err := json.MarshalIndent(map[string]any{"private": true, "dependencies": dependencies}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(prefix, "package.json"), pkgJSON, 0644); err != nil {
+		return fmt.Errorf("failed to write package.json in %s: %w", prefix, err)
+	}
+
+	// the installer works on the current directory, so step into the prefix for the duration of it
+	cwd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	if err := os.Chdir(prefix); err != nil {
+		return err
+	}
+	install.InstallPackages(map[string]types.Dependencies{".": dependencies}, &install.Options{Quiet: true})
+	if err := os.Chdir(cwd); err != nil {
+		return err
+	}
+
+	return os.WriteFile(filepath.Join(prefix, installedMarker), nil, 0644)
+}
+
+func findBinary(prefix, name, binName string) (string, error) {
+	pkgDir := filepath.Join(prefix, "node_modules", name)
+	pkgJSON, err := packagejson.ReadPackageJSON(pkgDir)
+	if err != nil {
+		return "", fmt.Errorf("failed to read package.json of %s: %w", name, err)
+	}
+	binaries := packagejson.GetBinaries(&pkgJSON, pkgDir)
+	if len(binaries) == 0 {
+		return "", fmt.Errorf("%s doesn't provide any binaries", name)
+	}
+
+	if binName == "" {
+		if len(binaries) == 1 {
+			for onlyBin := range binaries {
+				binName = onlyBin
+			}
+		} else {
+			binName = name[strings.LastIndex(name, "/")+1:]
+		}
+	}
+	if _, ok := binaries[binName]; !ok {
+		available := make([]string, 0, len(binaries))
+		for bin := range binaries {
+			available = append(available, bin)
+		}
+		sort.Strings(available)
+		return "", fmt.Errorf("%s doesn't provide %s, pick one of: %s", name, binName, strings.Join(available, ", "))
+	}
+	return filepath.Join(prefix, "node_modules", ".bin", binName), nil
+}
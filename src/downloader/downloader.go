@@ -9,13 +9,22 @@ import (
 	"log/slog"
 	"net/http"
 	"os"
+	"path"
 	"path/filepath"
+	"sort"
 	"strings"
+	"time"
 
+	"github.com/Eyepan/yap/src/config"
+	"github.com/Eyepan/yap/src/filelock"
+	"github.com/Eyepan/yap/src/packagejson"
 	"github.com/Eyepan/yap/src/types"
 	"github.com/Eyepan/yap/src/utils"
 )
 
+// npm uses the same date for every entry it packs, so tarballs are reproducible
+var tarballModTime = time.Date(1985, time.October, 26, 8, 15, 0, 0, time.UTC)
+
 // ProgressReader wraps an io.Reader and tracks the progress of the read operation.
 type ProgressReader struct {
 	io.Reader
@@ -34,25 +43,39 @@ func (pr *ProgressReader) Read(p []byte) (int, error) {
 	return n, err
 }
 
-func DownloadPackage(pkg *types.Package, tarballURL *string, conf *types.YapConfig, force bool) error {
+// DownloadPackage downloads a package into the store, unless it's there already. The tarball has to match the
+// integrity of dist before anything of it is extracted
+func DownloadPackage(pkg *types.Package, dist *types.Dist, conf *types.YapConfig, force bool) error {
+	if check, _ := CheckIfPackageIsAlreadyDownloaded(pkg); !force && check {
+		slog.Info(fmt.Sprintf("%s@%s has already been downloaded. Reusing this from the store", pkg.Name, pkg.Version))
+		return nil
+	}
+	lock, err := lockPackage(pkg.Name, pkg.Version)
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
+	// another yap process may have extracted it while this one waited for the lock
 	if check, _ := CheckIfPackageIsAlreadyDownloaded(pkg); !force && check {
 		slog.Info(fmt.Sprintf("%s@%s has already been downloaded. Reusing this from the store", pkg.Name, pkg.Version))
 		return nil
 	}
-	tarballData, err := DownloadTarball(tarballURL, conf)
+	tarballData, err := DownloadTarball(&dist.Tarball, conf)
 	if err != nil {
 		return fmt.Errorf("failed while downloading tarball: %w", err)
 	}
-	err = ExtractTarball(tarballData, fmt.Sprintf("%s@%s", pkg.Name, pkg.Version))
+	if err := utils.CheckIntegrity(tarballData.Bytes(), dist.Integrity, dist.Shasum); err != nil {
+		return fmt.Errorf("tarball of %s@%s from %s: %w", pkg.Name, pkg.Version, dist.Tarball, err)
+	}
+	err = extractTarball(tarballData, pkg.Name, pkg.Version, dist.Tarball)
 	if err != nil {
 		return fmt.Errorf("failed while extracting tarball: %w", err)
 	}
 	return nil
 }
 
+// DownloadTarball downloads a tarball, sending the auth token along only when it's on the configured registry
 func DownloadTarball(tarballURL *string, conf *types.YapConfig) (*bytes.Buffer, error) {
-	authToken := (*conf).AuthToken
-
 	// Create a new HTTP request
 	req, err := http.NewRequest("GET", *tarballURL, nil)
 	if err != nil {
@@ -60,7 +83,9 @@ func DownloadTarball(tarballURL *string, conf *types.YapConfig) (*bytes.Buffer,
 	}
 
 	// Add the auth token to the request headers
-	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", authToken))
+	if authToken := config.AuthTokenFor(conf, *tarballURL); authToken != "" {
+		req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", authToken))
+	}
 
 	// Send the request
 	client := &http.Client{}
@@ -95,7 +120,58 @@ func DownloadTarball(tarballURL *string, conf *types.YapConfig) (*bytes.Buffer,
 	return &tarballData, nil
 }
 
-func ExtractTarball(tarballData *bytes.Buffer, packageID string) error {
+// ExtractTarball extracts a package into the store, replacing whatever was there. It's extracted into a
+// temporary directory first and renamed into place, so nobody sees half of a package, and the store index of
+// the package is only marked complete after that. source is where the tarball can be downloaded from again,
+// if anywhere
+func ExtractTarball(tarballData *bytes.Buffer, name, version, source string) error {
+	lock, err := lockPackage(name, version)
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
+	return extractTarball(tarballData, name, version, source)
+}
+
+// lockPackage keeps other yap processes from writing a package version to the store until it's released
+func lockPackage(name, version string) (*filelock.Lock, error) {
+	lockFile, err := utils.GetPackageLockFile(name, version)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get package lock file: %w", err)
+	}
+	return filelock.Acquire(lockFile)
+}
+
+// ExtractTarballLocked is ExtractTarball for callers already holding the lock of the package, like store
+// verify while it repairs one
+func ExtractTarballLocked(tarballData *bytes.Buffer, name, version, source string) error {
+	return extractTarball(tarballData, name, version, source)
+}
+
+// entryPath returns where a tarball entry goes inside the package. Whatever the top level directory is
+// called (package/ on registries, <repo>-<sha>/ in archives of git hosts) it's stripped, and entries that
+// would end up outside of the package make the whole tarball invalid. The top level directory itself and
+// entries next to it come back empty
+func entryPath(name string) (string, error) {
+	name = strings.TrimPrefix(filepath.ToSlash(name), "./")
+	slash := strings.IndexByte(name, '/')
+	if slash < 0 {
+		return "", nil
+	}
+	relativePath := path.Clean(name[slash+1:])
+	if relativePath == "." {
+		return "", nil
+	}
+	if path.IsAbs(relativePath) || relativePath == ".." || strings.HasPrefix(relativePath, "../") || filepath.IsAbs(relativePath) || filepath.VolumeName(relativePath) != "" {
+		return "", fmt.Errorf("tarball entry %s points outside of the package", name)
+	}
+	return filepath.FromSlash(relativePath), nil
+}
+
+// extractTarball is ExtractTarball for callers already holding the lock of the package
+func extractTarball(tarballData *bytes.Buffer, name, version, source string) error {
+	index := &types.StoreIndex{Name: name, Version: version, Tarball: source, Integrity: utils.ComputeIntegrity(tarballData.Bytes())}
+
 	gzipReader, err := gzip.NewReader(tarballData)
 	if err != nil {
 		return fmt.Errorf("failed to create gzip reader: %w", err)
@@ -103,18 +179,29 @@ func ExtractTarball(tarballData *bytes.Buffer, packageID string) error {
 	defer gzipReader.Close()
 
 	tarReader := tar.NewReader(gzipReader)
-	storeDir, err := utils.GetStoreDir()
+	storeDir, err := utils.GetPackageStoreDir(name, version)
 	if err != nil {
 		return fmt.Errorf("failed to get store directory: %w", err)
 	}
-	// create dir for package
-	packageDir := filepath.Join(storeDir, utils.SanitizePackageName(packageID))
-
+	tempRoot, err := utils.GetStoreTempDir()
+	if err != nil {
+		return fmt.Errorf("failed to get store temp directory: %w", err)
+	}
+	if err := os.MkdirAll(tempRoot, 0755); err != nil {
+		return fmt.Errorf("failed to create store temp directory: %w", err)
+	}
+	// in the store itself, renaming across file systems isn't atomic
+	tempDir, err := os.MkdirTemp(tempRoot, utils.EncodePackageID(name, version)+"-")
+	if err != nil {
+		return fmt.Errorf("failed to create temp directory: %w", err)
+	}
+	defer os.RemoveAll(tempDir)
+	packageDir := filepath.Join(tempDir, "package")
 	if err := os.MkdirAll(packageDir, 0755); err != nil {
 		return fmt.Errorf("failed to create package directory: %w", err)
 	}
 
-	var topLevelDir string
+	files := make(map[string]types.StoreFile)
 	for {
 		header, err := tarReader.Next()
 		if err == io.EOF {
@@ -124,55 +211,197 @@ func ExtractTarball(tarballData *bytes.Buffer, packageID string) error {
 			return fmt.Errorf("failed to read tarball entry: %w", err)
 		}
 
-		if topLevelDir == "" && header.Typeflag == tar.TypeDir && strings.ContainsRune(header.Name, '@') {
-			topLevelDir = header.Name
-		} else {
-			topLevelDir = "package"
+		relativePath, err := entryPath(header.Name)
+		if err != nil {
+			return err
 		}
+		if relativePath == "" {
+			continue
+		}
+		filePath := filepath.Join(packageDir, relativePath)
 
-		if topLevelDir != "" {
-			relativePath := strings.TrimPrefix(header.Name, topLevelDir)
-			filePath := filepath.Join(packageDir, relativePath)
-
-			switch header.Typeflag {
-			case tar.TypeDir:
-				if err := os.MkdirAll(filePath, 0755); err != nil {
-					return fmt.Errorf("failed to create directory %s: %w", filePath, err)
-				}
-			case tar.TypeReg:
-				if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
-					return fmt.Errorf("failed to create directory for file %s: %w", filePath, err)
-				}
-				file, err := os.Create(filePath)
-				if err != nil {
-					return fmt.Errorf("failed to create file %s: %w", filePath, err)
-				}
-				if _, err := io.Copy(file, tarReader); err != nil {
-					file.Close()
-					return fmt.Errorf("failed to write to file %s: %w", filePath, err)
-				}
-				file.Close()
-			default:
-				slog.Warn(fmt.Sprintf("skipping unsupported tarball entry type %c: %s", header.Typeflag, header.Name))
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(filePath, 0755); err != nil {
+				return fmt.Errorf("failed to create directory %s: %w", filePath, err)
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+				return fmt.Errorf("failed to create directory for file %s: %w", filePath, err)
+			}
+			data, err := io.ReadAll(tarReader)
+			if err != nil {
+				return fmt.Errorf("failed to read %s from tarball: %w", header.Name, err)
 			}
+			// the store copy is hard linked into projects, so it's made executable here and not after linking
+			mode := os.FileMode(0644)
+			if header.Mode&0111 != 0 {
+				mode = 0755
+			}
+			if err := os.WriteFile(filePath, data, mode); err != nil {
+				return fmt.Errorf("failed to write to file %s: %w", filePath, err)
+			}
+			storePath := filepath.ToSlash(relativePath)
+			files[storePath] = types.StoreFile{Path: storePath, Size: int64(len(data)), Integrity: utils.ComputeIntegrity(data)}
+		case tar.TypeSymlink, tar.TypeLink:
+			// links could point anywhere, npm doesn't extract them either
+			slog.Warn(fmt.Sprintf("skipping link in tarball of %s@%s: %s", name, version, header.Name))
+		default:
+			slog.Warn(fmt.Sprintf("skipping unsupported tarball entry type %c: %s", header.Typeflag, header.Name))
+		}
+	}
+
+	if err := markBinsExecutable(packageDir); err != nil {
+		return err
+	}
+	for _, file := range files {
+		index.Files = append(index.Files, file)
+	}
+	sort.Slice(index.Files, func(i, j int) bool {
+		return index.Files[i].Path < index.Files[j].Path
+	})
+
+	// the index says it's partial until the new directory is in place
+	if err := WriteStoreIndex(index); err != nil {
+		return err
+	}
+	// moving the old one aside instead of overwriting keeps the files projects hard linked intact
+	if err := os.Rename(storeDir, filepath.Join(tempDir, "replaced")); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to clear package directory: %w", err)
+	}
+	if err := os.Rename(packageDir, storeDir); err != nil {
+		return fmt.Errorf("failed to move package into the store: %w", err)
+	}
+	index.Complete = true
+	return WriteStoreIndex(index)
+}
+
+// markBinsExecutable makes the commands a package declares executable, which the tarball often doesn't
+func markBinsExecutable(packageDir string) error {
+	pkgJSON, err := packagejson.ReadPackageJSON(packageDir)
+	if err != nil {
+		// packages without a readable package.json have no commands to link either
+		return nil
+	}
+	for _, path := range packagejson.GetBinaries(&pkgJSON, packageDir) {
+		target := filepath.Join(packageDir, filepath.FromSlash(path))
+		if relativeTarget, err := filepath.Rel(packageDir, target); err != nil || strings.HasPrefix(relativeTarget, "..") {
+			continue
+		}
+		info, err := os.Lstat(target)
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		if err := os.Chmod(target, info.Mode().Perm()|0111); err != nil {
+			return fmt.Errorf("failed to make %s executable: %w", target, err)
 		}
 	}
 	return nil
 }
 
+// CheckIfPackageIsAlreadyDownloaded reports whether the package was completely extracted into the store
 func CheckIfPackageIsAlreadyDownloaded(pkg *types.Package) (bool, error) {
-	storeDir, err := utils.GetStoreDir()
+	packagePath, err := utils.GetPackageStoreDir(pkg.Name, pkg.Version)
 	if err != nil {
 		return false, fmt.Errorf("failed to get store directory: %w", err)
 	}
 
-	packagePath := filepath.Join(storeDir, utils.SanitizePackageName(fmt.Sprintf("%s@%s", pkg.Name, pkg.Version)))
-
 	if _, err := os.Stat(packagePath); os.IsNotExist(err) {
 		return false, nil
 	} else if err != nil {
 		return false, fmt.Errorf("failed to check package path: %w", err)
 	}
 
-	return true, nil
+	index, err := ReadStoreIndex(pkg.Name, pkg.Version)
+	if err != nil {
+		// a package without an index is what's left of an extraction that never finished
+		return false, nil
+	}
+	return index.Complete, nil
+}
+
+// ReadStoreIndex reads the store index of a package version
+func ReadStoreIndex(name, version string) (*types.StoreIndex, error) {
+	indexFile, err := utils.GetPackageIndexFile(name, version)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get store index path: %w", err)
+	}
+	return ReadStoreIndexFile(indexFile)
+}
+
+func ReadStoreIndexFile(indexFile string) (*types.StoreIndex, error) {
+	data, err := os.ReadFile(indexFile)
+	if err != nil {
+		return nil, err
+	}
+	return utils.ReadStoreIndex(bytes.NewReader(data))
+}
+
+// WriteStoreIndex replaces the store index of a package in one go, through a temporary file
+func WriteStoreIndex(index *types.StoreIndex) error {
+	indexFile, err := utils.GetPackageIndexFile(index.Name, index.Version)
+	if err != nil {
+		return fmt.Errorf("failed to get store index path: %w", err)
+	}
+	var buf bytes.Buffer
+	if err := utils.WriteStoreIndex(&buf, index); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(indexFile), 0755); err != nil {
+		return fmt.Errorf("failed to create store index directory: %w", err)
+	}
+	tempFile := indexFile + ".tmp"
+	if err := os.WriteFile(tempFile, buf.Bytes(), 0644); err != nil {
+		return fmt.Errorf("failed to write store index: %w", err)
+	}
+	if err := os.Rename(tempFile, indexFile); err != nil {
+		return fmt.Errorf("failed to write store index: %w", err)
+	}
+	return nil
+}
+
+// CreateTarball is the reverse of ExtractTarball, it gzips the given files (relative to dir) into an npm style
+// tarball with every entry under package/. Entries get a fixed mtime so the same files always hash the same
+func CreateTarball(dir string, files []string) (*bytes.Buffer, error) {
+	var tarballData bytes.Buffer
+	gzipWriter := gzip.NewWriter(&tarballData)
+	tarWriter := tar.NewWriter(gzipWriter)
+
+	for _, file := range files {
+		filePath := filepath.Join(dir, file)
+		info, err := os.Stat(filePath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to stat %s: %w", filePath, err)
+		}
+		mode := int64(0644)
+		if info.Mode().Perm()&0111 != 0 {
+			mode = 0755
+		}
+		header := &tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     "package/" + filepath.ToSlash(file),
+			Size:     info.Size(),
+			Mode:     mode,
+			ModTime:  tarballModTime,
+			Format:   tar.FormatPAX,
+		}
+		if err := tarWriter.WriteHeader(header); err != nil {
+			return nil, fmt.Errorf("failed to write tarball header for %s: %w", file, err)
+		}
+		data, err := os.ReadFile(filePath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", filePath, err)
+		}
+		if _, err := tarWriter.Write(data); err != nil {
+			return nil, fmt.Errorf("failed to write %s to tarball: %w", file, err)
+		}
+	}
+
+	if err := tarWriter.Close(); err != nil {
+		return nil, fmt.Errorf("failed to finish tarball: %w", err)
+	}
+	if err := gzipWriter.Close(); err != nil {
+		return nil, fmt.Errorf("failed to finish gzip stream: %w", err)
+	}
+	return &tarballData, nil
 }
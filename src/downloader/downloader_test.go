@@ -0,0 +1,32 @@
+package downloader
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestEntryPath(t *testing.T) {
+	for name, want := range map[string]string{
+		"package/index.js":          "index.js",
+		"package/lib/a.js":          "lib/a.js",
+		"./package/lib/a.js":        "lib/a.js",
+		"repo-0123abc/package.json": "package.json",
+		"package/lib/../index.js":   "index.js",
+		"package/":                  "",
+		"package":                   "",
+		"pax_global_header":         "",
+	} {
+		got, err := entryPath(name)
+		if err != nil {
+			t.Errorf("entryPath(%q) failed: %v", name, err)
+		} else if got != filepath.FromSlash(want) {
+			t.Errorf("entryPath(%q) = %q, want %q", name, got, want)
+		}
+	}
+
+	for _, name := range []string{"package/../../.bashrc", "package/..", "package//etc/passwd", "package/lib/../../../x"} {
+		if got, err := entryPath(name); err == nil {
+			t.Errorf("entryPath(%q) = %q, want an error", name, got)
+		}
+	}
+}
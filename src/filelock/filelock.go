@@ -0,0 +1,81 @@
+package filelock
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+)
+
+// Lock is an advisory lock on a file, held until it's released. Every yap process takes the same locks
+// before writing to the store or the metadata cache, which keeps them from writing the same things at once.
+// Nothing stops anything else from touching the files
+type Lock struct {
+	file   *os.File
+	shared bool
+}
+
+// Acquire locks the file at path, creating it if needed, and waits for whoever holds it to release it first
+func Acquire(path string) (*Lock, error) {
+	return acquire(path, false)
+}
+
+// AcquireShared locks the file at path along with any other shared holders, waiting only for an exclusive one
+func AcquireShared(path string) (*Lock, error) {
+	return acquire(path, true)
+}
+
+// TryAcquire locks the file at path if nobody holds it, returning nil instead of waiting when someone does
+func TryAcquire(path string) (*Lock, error) {
+	file, err := open(path)
+	if err != nil {
+		return nil, err
+	}
+	locked, err := tryLock(file, false)
+	if err != nil || !locked {
+		file.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to lock %s: %w", path, err)
+		}
+		return nil, nil
+	}
+	return &Lock{file: file}, nil
+}
+
+func acquire(path string, shared bool) (*Lock, error) {
+	file, err := open(path)
+	if err != nil {
+		return nil, err
+	}
+	locked, err := tryLock(file, shared)
+	if err == nil && !locked {
+		slog.Info(fmt.Sprintf("waiting for another yap process to release %s", path))
+		err = lock(file, shared)
+	}
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to lock %s: %w", path, err)
+	}
+	return &Lock{file: file, shared: shared}, nil
+}
+
+func open(path string) (*os.File, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create lock directory: %w", err)
+	}
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open lock file: %w", err)
+	}
+	return file, nil
+}
+
+// Release unlocks the file. The file itself stays, removing it could let two processes lock different files
+// under the same name
+func (l *Lock) Release() error {
+	if err := unlock(l.file, l.shared); err != nil {
+		l.file.Close()
+		return fmt.Errorf("failed to unlock %s: %w", l.file.Name(), err)
+	}
+	return l.file.Close()
+}
@@ -0,0 +1,48 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd && !dragonfly && !windows
+
+package filelock
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Without flock the locks only keep the goroutines of one process apart, separate yap processes can still
+// run into each other
+var locks sync.Map
+
+func mutexOf(file *os.File) *sync.RWMutex {
+	path, err := filepath.Abs(file.Name())
+	if err != nil {
+		path = file.Name()
+	}
+	mu, _ := locks.LoadOrStore(path, &sync.RWMutex{})
+	return mu.(*sync.RWMutex)
+}
+
+// tryLock takes the lock if nobody holds it (or only shared holders do, for a shared lock), reporting whether it did
+func tryLock(file *os.File, shared bool) (bool, error) {
+	if shared {
+		return mutexOf(file).TryRLock(), nil
+	}
+	return mutexOf(file).TryLock(), nil
+}
+
+func lock(file *os.File, shared bool) error {
+	if shared {
+		mutexOf(file).RLock()
+	} else {
+		mutexOf(file).Lock()
+	}
+	return nil
+}
+
+func unlock(file *os.File, shared bool) error {
+	if shared {
+		mutexOf(file).RUnlock()
+	} else {
+		mutexOf(file).Unlock()
+	}
+	return nil
+}
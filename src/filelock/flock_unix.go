@@ -0,0 +1,41 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly
+
+package filelock
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// tryLock takes the lock if nobody holds it (or only shared holders do, for a shared lock), reporting whether it did
+func tryLock(file *os.File, shared bool) (bool, error) {
+	err := flock(file, mode(shared)|syscall.LOCK_NB)
+	if errors.Is(err, syscall.EWOULDBLOCK) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func lock(file *os.File, shared bool) error {
+	return flock(file, mode(shared))
+}
+
+func unlock(file *os.File, shared bool) error {
+	return flock(file, syscall.LOCK_UN)
+}
+
+func mode(shared bool) int {
+	if shared {
+		return syscall.LOCK_SH
+	}
+	return syscall.LOCK_EX
+}
+
+func flock(file *os.File, how int) error {
+	for {
+		if err := syscall.Flock(int(file.Fd()), how); err != syscall.EINTR {
+			return err
+		}
+	}
+}
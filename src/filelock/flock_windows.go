@@ -0,0 +1,66 @@
+//go:build windows
+
+package filelock
+
+import (
+	"errors"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	kernel32         = syscall.NewLazyDLL("kernel32.dll")
+	procLockFileEx   = kernel32.NewProc("LockFileEx")
+	procUnlockFileEx = kernel32.NewProc("UnlockFileEx")
+)
+
+const (
+	lockfileFailImmediately = 0x1
+	lockfileExclusiveLock   = 0x2
+
+	// the low and high halves of the length of the range, which covers every byte the file could ever have
+	allBytes = 0xFFFFFFFF
+
+	errorLockViolation syscall.Errno = 33
+)
+
+// tryLock takes the lock if nobody holds it (or only shared holders do, for a shared lock), reporting whether it did
+func tryLock(file *os.File, shared bool) (bool, error) {
+	err := lockFileEx(file, flags(shared)|lockfileFailImmediately)
+	if errors.Is(err, errorLockViolation) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func lock(file *os.File, shared bool) error {
+	return lockFileEx(file, flags(shared))
+}
+
+// LockFileEx takes a shared lock unless it's asked for an exclusive one
+func flags(shared bool) uint32 {
+	if shared {
+		return 0
+	}
+	return lockfileExclusiveLock
+}
+
+// the whole file is locked, the way flock does it
+func lockFileEx(file *os.File, flags uint32) error {
+	var overlapped syscall.Overlapped
+	ok, _, err := procLockFileEx.Call(file.Fd(), uintptr(flags), 0, allBytes, allBytes, uintptr(unsafe.Pointer(&overlapped)))
+	if ok == 0 {
+		return err
+	}
+	return nil
+}
+
+func unlock(file *os.File, shared bool) error {
+	var overlapped syscall.Overlapped
+	ok, _, err := procUnlockFileEx.Call(file.Fd(), 0, allBytes, allBytes, uintptr(unsafe.Pointer(&overlapped)))
+	if ok == 0 {
+		return err
+	}
+	return nil
+}
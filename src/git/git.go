@@ -0,0 +1,258 @@
+package git
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/Eyepan/yap/src/downloader"
+	"github.com/Eyepan/yap/src/filelock"
+	"github.com/Eyepan/yap/src/pack"
+	"github.com/Eyepan/yap/src/packagejson"
+	"github.com/Eyepan/yap/src/scripts"
+	"github.com/Eyepan/yap/src/spec"
+	"github.com/Eyepan/yap/src/store"
+	"github.com/Eyepan/yap/src/types"
+	"github.com/Eyepan/yap/src/utils"
+	"github.com/Masterminds/semver/v3"
+)
+
+var commitHash = regexp.MustCompile(`^[0-9a-f]{40}$`)
+
+// every repository gets one mutex, so concurrent resolutions of the same repo in this process don't fetch over
+// each other. Other yap processes are kept out by the file lock of the repository
+var repoLocks sync.Map
+
+// FetchPackage resolves a git dependency to an exact commit and makes sure its packed contents are in the store.
+// The returned metadata looks like it came from the registry, with the pinned git url as both version and tarball.
+// Its prepare script only runs when allowPrepare is set, which is up to trustedDependencies and --ignore-scripts
+func FetchPackage(name string, gitSpec spec.GitSpec, allowPrepare bool) (types.VersionMetadata, error) {
+	// a pinned commit that's already in the store doesn't need the repository at all
+	if commitHash.MatchString(gitSpec.Committish) {
+		if vmd, err := readFromStore(name, gitSpec.Resolved(gitSpec.Committish)); err == nil {
+			return vmd, nil
+		}
+	}
+
+	repoDir, err := syncRepository(gitSpec)
+	if err != nil {
+		return types.VersionMetadata{}, err
+	}
+	commit, err := resolveCommit(repoDir, gitSpec)
+	if err != nil {
+		return types.VersionMetadata{}, err
+	}
+	resolved := gitSpec.Resolved(commit)
+
+	if vmd, err := readFromStore(name, resolved); err == nil {
+		return vmd, nil
+	}
+	if err := storePackage(repoDir, commit, name, resolved, allowPrepare); err != nil {
+		return types.VersionMetadata{}, fmt.Errorf("failed to store %s at %s: %w", gitSpec.URL, commit, err)
+	}
+	return readFromStore(name, resolved)
+}
+
+func readFromStore(name, resolved string) (types.VersionMetadata, error) {
+	if complete, _ := downloader.CheckIfPackageIsAlreadyDownloaded(&types.Package{Name: name, Version: resolved}); !complete {
+		return types.VersionMetadata{}, fmt.Errorf("%s@%s isn't in the store", name, resolved)
+	}
+	storeDir, err := utils.GetPackageStoreDir(name, resolved)
+	if err != nil {
+		return types.VersionMetadata{}, err
+	}
+	pkgJSON, err := packagejson.ReadPackageJSON(storeDir)
+	if err != nil {
+		return types.VersionMetadata{}, err
+	}
+	return types.VersionMetadata{
+		Name:         name,
+		Version:      resolved,
+		Dist:         types.Dist{Tarball: resolved},
+		Dependencies: pkgJSON.Dependencies,
+	}, nil
+}
+
+// syncRepository keeps a bare mirror of the repository in the git cache, cloning it the first time
+// and fetching it afterwards unless the requested commit is already there
+func syncRepository(gitSpec spec.GitSpec) (string, error) {
+	cacheDir, err := utils.GetGitCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to get git cache directory: %w", err)
+	}
+	hash := sha256.Sum256([]byte(gitSpec.URL))
+	repoName := hex.EncodeToString(hash[:])[:16]
+	repoDir := filepath.Join(cacheDir, repoName)
+
+	lock, _ := repoLocks.LoadOrStore(repoDir, &sync.Mutex{})
+	lock.(*sync.Mutex).Lock()
+	defer lock.(*sync.Mutex).Unlock()
+	// the mirror is shared by every yap process
+	lockFile, err := utils.GetGitLockFile(repoName)
+	if err != nil {
+		return "", fmt.Errorf("failed to get git lock file: %w", err)
+	}
+	fileLock, err := filelock.Acquire(lockFile)
+	if err != nil {
+		return "", err
+	}
+	defer fileLock.Release()
+
+	if _, err := os.Stat(repoDir); err != nil {
+		if err := os.MkdirAll(cacheDir, os.ModePerm); err != nil {
+			return "", err
+		}
+		slog.Info(fmt.Sprintf("[GIT] cloning %s", gitSpec.URL))
+		if _, err := runGit(cacheDir, "clone", "--mirror", "--quiet", "--", gitSpec.URL, repoDir); err != nil {
+			return "", err
+		}
+		return repoDir, nil
+	}
+
+	if commitHash.MatchString(gitSpec.Committish) {
+		if _, err := runGit(repoDir, "cat-file", "-e", gitSpec.Committish+"^{commit}"); err == nil {
+			return repoDir, nil
+		}
+	}
+	slog.Info(fmt.Sprintf("[GIT] fetching %s", gitSpec.URL))
+	if _, err := runGit(repoDir, "remote", "update", "--prune"); err != nil {
+		return "", err
+	}
+	return repoDir, nil
+}
+
+// resolveCommit turns the committish (or the highest tag matching the semver range) into a commit hash
+func resolveCommit(repoDir string, gitSpec spec.GitSpec) (string, error) {
+	committish := gitSpec.Committish
+	if gitSpec.SemverRange != "" {
+		tag, err := resolveSemverTag(repoDir, gitSpec.SemverRange)
+		if err != nil {
+			return "", fmt.Errorf("failed to resolve %s#semver:%s: %w", gitSpec.URL, gitSpec.SemverRange, err)
+		}
+		committish = tag
+	}
+	if committish == "" {
+		committish = "HEAD"
+	}
+	commit, err := runGit(repoDir, "rev-parse", "--verify", "--quiet", committish+"^{commit}")
+	if err != nil {
+		return "", fmt.Errorf("failed to find %s in %s: %w", committish, gitSpec.URL, err)
+	}
+	return commit, nil
+}
+
+func resolveSemverTag(repoDir, versionRange string) (string, error) {
+	constraint, err := semver.NewConstraint(versionRange)
+	if err != nil {
+		return "", err
+	}
+	output, err := runGit(repoDir, "tag", "--list")
+	if err != nil {
+		return "", err
+	}
+
+	tags := make(map[*semver.Version]string)
+	var versions []*semver.Version
+	for _, tag := range strings.Fields(output) {
+		version, err := semver.NewVersion(tag)
+		if err != nil {
+			continue
+		}
+		tags[version] = tag
+		versions = append(versions, version)
+	}
+	sort.Sort(sort.Reverse(semver.Collection(versions)))
+
+	for _, version := range versions {
+		if constraint.Check(version) {
+			return tags[version], nil
+		}
+	}
+	return "", fmt.Errorf("no tag matches %s", versionRange)
+}
+
+// storePackage checks the commit out, runs its prepare script if allowed and extracts the packed result into the store
+func storePackage(repoDir, commit, name, resolved string, allowPrepare bool) error {
+	checkoutDir, err := os.MkdirTemp("", "yap-git-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(checkoutDir)
+
+	if _, err := runGit(checkoutDir, "clone", "--quiet", "--no-checkout", repoDir, checkoutDir); err != nil {
+		return err
+	}
+	if _, err := runGit(checkoutDir, "-c", "advice.detachedHead=false", "checkout", "--quiet", commit); err != nil {
+		return err
+	}
+
+	if err := runPrepare(checkoutDir, name, allowPrepare); err != nil {
+		return err
+	}
+
+	tarball, _, err := pack.PackDirectory(checkoutDir)
+	if err != nil {
+		return err
+	}
+	return downloader.ExtractTarball(tarball, name, resolved, "")
+}
+
+// runPrepare runs the `prepare` script of a checkout, after installing its dependencies with this very binary.
+// The nested install ignores scripts, so the checkout's own prepare only runs here, once, and it stays out
+// of the store's projects, since the checkout is gone once it's packed.
+// Without allow it's only reported, the checkout is packed as it is
+func runPrepare(checkoutDir, name string, allow bool) error {
+	pkgJSON, err := packagejson.ReadPackageJSON(checkoutDir)
+	if err != nil {
+		return fmt.Errorf("failed to read package.json of git dependency: %w", err)
+	}
+	if _, ok := pkgJSON.Scripts["prepare"]; !ok {
+		return nil
+	}
+	if !allow {
+		slog.Warn(fmt.Sprintf("prepare script of git dependency %s was not run, add it to trustedDependencies in package.json to allow it", name))
+		return nil
+	}
+
+	if len(pkgJSON.Dependencies)+len(pkgJSON.DevDependencies) > 0 {
+		self, err := os.Executable()
+		if err != nil {
+			return err
+		}
+		install := exec.Command(self, "install", "--ignore-scripts")
+		install.Dir = checkoutDir
+		install.Env = append(os.Environ(), store.UnregisteredEnv+"=1")
+		if output, err := install.CombinedOutput(); err != nil {
+			return fmt.Errorf("failed to install dependencies before prepare: %w: %s", err, output)
+		}
+	}
+
+	slog.Info(fmt.Sprintf("[GIT] running prepare for %s", pkgJSON.Name))
+	var output bytes.Buffer
+	if err := scripts.RunEvent(checkoutDir, &pkgJSON, "prepare", &output, &output); err != nil {
+		return fmt.Errorf("%w: %s", err, output.Bytes())
+	}
+	return nil
+}
+
+func runGit(dir string, args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("git %s: %w: %s", strings.Join(args, " "), err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(string(output)), nil
+}
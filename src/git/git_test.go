@@ -0,0 +1,199 @@
+package git
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Eyepan/yap/src/spec"
+)
+
+// remote is a bare repository in a temp dir along with a working clone that pushes to it, so the tests
+// mirror and fetch the way they would from a real host without touching the network
+type remote struct {
+	t    *testing.T
+	bare string
+	work string
+}
+
+func newRemote(t *testing.T) *remote {
+	// the git cache and the store live under HOME, and nothing from the user's git config should leak in
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("GIT_CONFIG_NOSYSTEM", "1")
+	t.Setenv("GIT_AUTHOR_NAME", "yap")
+	t.Setenv("GIT_AUTHOR_EMAIL", "yap@example.com")
+	t.Setenv("GIT_COMMITTER_NAME", "yap")
+	t.Setenv("GIT_COMMITTER_EMAIL", "yap@example.com")
+
+	r := &remote{t: t, bare: filepath.Join(t.TempDir(), "pkg.git"), work: t.TempDir()}
+	r.git(".", "init", "--bare", "--quiet", r.bare)
+	r.git(r.work, "init", "--quiet")
+	r.git(r.work, "remote", "add", "origin", r.bare)
+	return r
+}
+
+func (r *remote) git(dir string, args ...string) string {
+	r.t.Helper()
+	output, err := runGit(dir, args...)
+	if err != nil {
+		r.t.Fatalf("failed to run git: %v", err)
+	}
+	return output
+}
+
+// commit writes package.json at version, commits it, tags it when tag is set and pushes everything
+func (r *remote) commit(version, tag string) string {
+	r.t.Helper()
+	manifest := `{"name": "pkg", "version": "` + version + `", "main": "index.js"}`
+	if err := os.WriteFile(filepath.Join(r.work, "package.json"), []byte(manifest), 0644); err != nil {
+		r.t.Fatalf("failed to write package.json: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(r.work, "index.js"), []byte("module.exports = '"+version+"'\n"), 0644); err != nil {
+		r.t.Fatalf("failed to write index.js: %v", err)
+	}
+	r.git(r.work, "add", "-A")
+	r.git(r.work, "commit", "--quiet", "-m", version)
+	if tag != "" {
+		r.git(r.work, "tag", tag)
+	}
+	r.git(r.work, "push", "--quiet", "--tags", "origin", "HEAD:refs/heads/main")
+	r.git(r.bare, "symbolic-ref", "HEAD", "refs/heads/main")
+	return r.git(r.work, "rev-parse", "HEAD")
+}
+
+func (r *remote) spec(fragment string) spec.GitSpec {
+	r.t.Helper()
+	specifier := "git+file://" + r.bare
+	if fragment != "" {
+		specifier += "#" + fragment
+	}
+	gitSpec, ok := spec.ParseGit(specifier)
+	if !ok {
+		r.t.Fatalf("%s isn't a git specifier", specifier)
+	}
+	return gitSpec
+}
+
+func TestSyncRepositoryFetchesNewCommits(t *testing.T) {
+	r := newRemote(t)
+	first := r.commit("1.0.0", "")
+
+	repoDir, err := syncRepository(r.spec(""))
+	if err != nil {
+		t.Fatalf("failed to mirror the repository: %v", err)
+	}
+	if commit, err := resolveCommit(repoDir, r.spec("")); err != nil || commit != first {
+		t.Fatalf("HEAD of the mirror is %q (%v), want %s", commit, err, first)
+	}
+
+	second := r.commit("1.1.0", "")
+	if _, err := syncRepository(r.spec("")); err != nil {
+		t.Fatalf("failed to fetch the repository: %v", err)
+	}
+	if commit, err := resolveCommit(repoDir, r.spec("")); err != nil || commit != second {
+		t.Fatalf("HEAD of the mirror is %q (%v) after fetching, want %s", commit, err, second)
+	}
+	if commit, err := resolveCommit(repoDir, r.spec(first)); err != nil || commit != first {
+		t.Fatalf("%s resolved to %q (%v)", first, commit, err)
+	}
+}
+
+func TestSyncRepositorySkipsFetchForKnownCommit(t *testing.T) {
+	r := newRemote(t)
+	first := r.commit("1.0.0", "")
+	repoDir, err := syncRepository(r.spec(""))
+	if err != nil {
+		t.Fatalf("failed to mirror the repository: %v", err)
+	}
+
+	second := r.commit("1.1.0", "")
+	if _, err := syncRepository(r.spec(first)); err != nil {
+		t.Fatalf("failed to sync the repository: %v", err)
+	}
+	if _, err := runGit(repoDir, "cat-file", "-e", second+"^{commit}"); err == nil {
+		t.Fatalf("the mirror was fetched although %s was already in it", first)
+	}
+}
+
+func TestResolveCommitSemverTag(t *testing.T) {
+	r := newRemote(t)
+	r.commit("1.0.0", "v1.0.0")
+	want := r.commit("1.2.0", "v1.2.0")
+	r.commit("2.0.0", "v2.0.0")
+	// tags that aren't versions are left out of semver ranges
+	r.commit("2.1.0", "nightly")
+
+	repoDir, err := syncRepository(r.spec(""))
+	if err != nil {
+		t.Fatalf("failed to mirror the repository: %v", err)
+	}
+	if commit, err := resolveCommit(repoDir, r.spec("semver:^1")); err != nil || commit != want {
+		t.Fatalf("semver:^1 resolved to %q (%v), want %s", commit, err, want)
+	}
+	if _, err := resolveCommit(repoDir, r.spec("semver:^3")); err == nil {
+		t.Fatalf("semver:^3 resolved although no tag matches it")
+	}
+	if _, err := resolveCommit(repoDir, r.spec("no-such-branch")); err == nil {
+		t.Fatalf("no-such-branch resolved although it doesn't exist")
+	}
+}
+
+func TestFetchPackageStoresCommit(t *testing.T) {
+	r := newRemote(t)
+	commit := r.commit("1.0.0", "v1.0.0")
+
+	vmd, err := FetchPackage("pkg", r.spec("v1.0.0"), false)
+	if err != nil {
+		t.Fatalf("failed to fetch the package: %v", err)
+	}
+	resolved := r.spec("").Resolved(commit)
+	if vmd.Version != resolved || vmd.Dist.Tarball != resolved {
+		t.Fatalf("package resolved to %s (tarball %s), want %s", vmd.Version, vmd.Dist.Tarball, resolved)
+	}
+
+	// the pinned commit is in the store now, so the repository isn't needed anymore
+	if err := os.RemoveAll(r.bare); err != nil {
+		t.Fatalf("failed to remove the repository: %v", err)
+	}
+	again, err := FetchPackage("pkg", r.spec(commit), false)
+	if err != nil {
+		t.Fatalf("failed to fetch the pinned package from the store: %v", err)
+	}
+	if again.Version != resolved {
+		t.Fatalf("pinned package resolved to %s, want %s", again.Version, resolved)
+	}
+}
+
+func TestRunPrepareRunsTheScriptOnce(t *testing.T) {
+	checkoutDir := t.TempDir()
+	manifest := `{"name": "pkg", "version": "1.0.0", "scripts": {"prepare": "echo $npm_lifecycle_event >> prepared.txt"}}`
+	if err := os.WriteFile(filepath.Join(checkoutDir, "package.json"), []byte(manifest), 0644); err != nil {
+		t.Fatalf("failed to write package.json: %v", err)
+	}
+
+	if err := runPrepare(checkoutDir, "pkg", false); err != nil {
+		t.Fatalf("runPrepare failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(checkoutDir, "prepared.txt")); !os.IsNotExist(err) {
+		t.Fatalf("prepare ran without being allowed")
+	}
+
+	if err := runPrepare(checkoutDir, "pkg", true); err != nil {
+		t.Fatalf("runPrepare failed: %v", err)
+	}
+	prepared, err := os.ReadFile(filepath.Join(checkoutDir, "prepared.txt"))
+	if err != nil {
+		t.Fatalf("prepare didn't run: %v", err)
+	}
+	if string(prepared) != "prepare\n" {
+		t.Errorf("prepare wrote %q, want it to run once as the prepare event", prepared)
+	}
+}
+
+func TestParseGitRejectsOptions(t *testing.T) {
+	for _, specifier := range []string{"git+--upload-pack=touch /tmp/pwned", "git+https://github.com/org/repo.git#--output=/tmp/pwned"} {
+		if gitSpec, ok := spec.ParseGit(specifier); ok {
+			t.Errorf("%s parsed as %+v, git would take it for an option", specifier, gitSpec)
+		}
+	}
+}
@@ -1,27 +1,98 @@
 package install
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"log/slog"
+	"maps"
+	"os"
 	"runtime"
+	"slices"
 	"sync"
 
+	"github.com/Eyepan/yap/src/cache"
 	"github.com/Eyepan/yap/src/config"
 	"github.com/Eyepan/yap/src/downloader"
+	"github.com/Eyepan/yap/src/linker"
+	"github.com/Eyepan/yap/src/lockexport"
 	"github.com/Eyepan/yap/src/logger"
-	"github.com/Eyepan/yap/src/metadata"
+	"github.com/Eyepan/yap/src/overrides"
+	"github.com/Eyepan/yap/src/scripts"
+	"github.com/Eyepan/yap/src/spec"
+	"github.com/Eyepan/yap/src/store"
 	"github.com/Eyepan/yap/src/types"
+	"github.com/Eyepan/yap/src/utils"
 )
 
-func InstallPackages(listOfPackages *types.Dependencies) {
+// Request is a dependency waiting to be resolved, along with the resolved packages that led to it
+type Request struct {
+	Package  types.Package
+	Parents  []types.Package
+	Override *overrides.Rule // the rule that changed what Package asks for, if any
+	Dir      string          // where the dependency was declared, relative file: and link: paths are relative to it
+}
+
+// Failures collects what the workers failed to resolve or download, so the install stops before it writes
+// a lockfile for a tree with holes in it
+type Failures struct {
+	mu   sync.Mutex
+	errs []error
+}
+
+func (f *Failures) Add(err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.errs = append(f.errs, err)
+}
+
+// Err joins everything that failed, nil when nothing did
+func (f *Failures) Err() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return errors.Join(f.errs...)
+}
+
+// Options tweak what InstallPackages does on top of resolving, downloading and linking
+type Options struct {
+	Overrides           *overrides.Overrides
+	IgnoreScripts       bool
+	TrustedDependencies []string // packages allowed to run their install scripts
+	Quiet               bool     // skips the progress and the final Done, for commands installing on the side like dlx
+	Unregistered        bool     // keeps the project out of the store's projects, for installs in throwaway directories
+}
+
+// allowsScripts reports whether a package may run scripts while it's installed
+func (o *Options) allowsScripts(name string) bool {
+	return !o.IgnoreScripts && slices.Contains(o.TrustedDependencies, name)
+}
+
+// InstallPackages installs the dependencies of every importer in one pass. Importers are keyed
+// by their directory relative to the workspace root, the root itself being "."
+func InstallPackages(importers map[string]types.Dependencies, options *Options) {
+	packageOverrides := options.Overrides
 	config, err := config.ReadYapConfig()
 	if err != nil {
 		log.Fatalf("Failed to load configurations: %v", err)
 	}
-	stats := logger.Stats{}
+	// until the project is registered, the packages extracted for it aren't safe from prune
+	storeUse, err := store.Use()
+	if err != nil {
+		log.Fatalf("Failed to lock the store: %v", err)
+	}
+	stats := logger.Stats{Quiet: options.Quiet}
+	var failures Failures
+	resolutions := Resolutions{Overrides: packageOverrides, AllowsScripts: options.allowsScripts}
 
-	baseDependencies := *listOfPackages
+	if previousLockfile, err := utils.ReadLock(); err == nil {
+		if packageOverrides.Equal(previousLockfile.Overrides) {
+			resolutions.Locked = NewLocked(previousLockfile)
+		} else {
+			slog.Warn("overrides changed since the lockfile was written, resolving everything again")
+		}
+	} else if utils.LockfileExistsIn(".") {
+		slog.Warn(fmt.Sprintf("ignoring the lockfile and resolving everything again: %v", err))
+	}
 
 	numWorkers := runtime.NumCPU()
 	slog.Info(fmt.Sprintf("Running on %d CPU Cores", numWorkers))
@@ -29,15 +100,15 @@ func InstallPackages(listOfPackages *types.Dependencies) {
 	var metadataWg sync.WaitGroup
 	var downloadWg sync.WaitGroup
 
-	metadataChannel := make(chan *types.Package)
+	metadataChannel := make(chan *Request)
 	downloadChannel := make(chan *types.MPackage)
 	// install map
 	var installedPackages sync.Map
 
 	for i := 0; i < numWorkers; i++ {
 		go func() {
-			for pkg := range metadataChannel {
-				ResolvePackageMetadata(&metadataWg, &downloadWg, pkg, config, downloadChannel, metadataChannel, &stats, &installedPackages)
+			for request := range metadataChannel {
+				ResolvePackageMetadata(&metadataWg, &downloadWg, request, config, downloadChannel, metadataChannel, &stats, &failures, &installedPackages, &resolutions)
 			}
 		}()
 	}
@@ -45,18 +116,19 @@ func InstallPackages(listOfPackages *types.Dependencies) {
 	for i := 0; i < numWorkers; i++ {
 		go func() {
 			for mPkg := range downloadChannel {
-				DownloadPackageTarball(&downloadWg, mPkg, config, &stats)
+				DownloadPackageTarball(&downloadWg, mPkg, config, &stats, &failures)
 			}
 		}()
 	}
 
-	metadataWg.Add(len(baseDependencies))
-	for name, version := range baseDependencies {
-		go func(name, version string) {
-			basePackage := types.Package{Name: name, Version: version}
-			metadataChannel <- &basePackage
-			stats.IncrementTotalResolveCount()
-		}(name, version)
+	for _, baseDependencies := range importers {
+		metadataWg.Add(len(baseDependencies))
+		for name, version := range baseDependencies {
+			go func(name, version string) {
+				metadataChannel <- resolutions.NewRequest(name, version, nil, "")
+				stats.IncrementTotalResolveCount()
+			}(name, version)
+		}
 	}
 
 	metadataWg.Wait()
@@ -65,22 +137,69 @@ func InstallPackages(listOfPackages *types.Dependencies) {
 	downloadWg.Wait()
 	close(downloadChannel)
 
-	fmt.Println("\n💫 Done!")
+	if err := failures.Err(); err != nil {
+		log.Fatalf("Failed to install dependencies, the lockfile and node_modules were left as they were:\n%v", err)
+	}
+
+	lockfile := resolutions.Lockfile(importers)
+	if err := utils.WriteLock(lockfile, config.LockfileFormat); err != nil {
+		log.Fatalf("Failed to write lockfile: %v", err)
+	}
+	if err := linker.LinkPackages(".", &lockfile); err != nil {
+		log.Fatalf("Failed to link node_modules: %v", err)
+	}
+	if config.LockfileExport != "" {
+		if _, err := lockexport.Export(".", config.LockfileExport, &lockfile); err != nil {
+			slog.Warn(fmt.Sprintf("failed to export the lockfile: %v", err))
+		}
+	}
+	if !options.Unregistered {
+		if err := store.RegisterProject("."); err != nil {
+			slog.Warn(fmt.Sprintf("failed to register the project with the store, `yap store prune` may remove its packages: %v", err))
+		}
+	}
+	storeUse.Release()
+	if err := cache.Flush(); err != nil {
+		slog.Warn(fmt.Sprintf("failed to write the metadata cache index: %v", err))
+	}
+	if _, _, err := cache.Evict(config.CacheMaxSize); err != nil {
+		slog.Warn(fmt.Sprintf("failed to shrink the metadata cache: %v", err))
+	}
+	if !options.IgnoreScripts {
+		if err := scripts.RunInstallScripts(".", &lockfile, options.TrustedDependencies); err != nil {
+			log.Fatalf("Failed to run install scripts: %v", err)
+		}
+		if err := scripts.RunImporterScripts(".", slices.Collect(maps.Keys(importers)), os.Stdout, os.Stderr); err != nil {
+			log.Fatalf("Failed to run the project's scripts: %v", err)
+		}
+	}
+
+	if !options.Quiet {
+		fmt.Println("\n💫 Done!")
+	}
 }
 
-func ResolvePackageMetadata(metadataWg, downloadWg *sync.WaitGroup, pkg *types.Package, config *types.YapConfig, downloadChannel chan<- *types.MPackage, metadataChannel chan<- *types.Package, stats *logger.Stats, installedPackages *sync.Map) {
+func ResolvePackageMetadata(metadataWg, downloadWg *sync.WaitGroup, request *Request, config *types.YapConfig, downloadChannel chan<- *types.MPackage, metadataChannel chan<- *Request, stats *logger.Stats, failures *Failures, installedPackages *sync.Map, resolutions *Resolutions) {
 	defer metadataWg.Done()
-	if _, loaded := installedPackages.LoadOrStore(fmt.Sprintf("%s@%s", pkg.Name, pkg.Version), true); loaded {
+	pkg := &request.Package
+	// the same range in the same override context resolves the same and gets the same overrides below it
+	key := fmt.Sprintf("%s@%s\x00%s", pkg.Name, pkg.Version, resolutions.Overrides.Context(request.Parents))
+	if _, loaded := installedPackages.LoadOrStore(key, true); loaded {
 		stats.IncrementResolveCount()
 		return
 	}
 	slog.Info(fmt.Sprintf("[METADATA] 🔃 %s@%s", pkg.Name, pkg.Version))
 
-	vmd, err := metadata.FetchVersionMetadata(pkg, config, false)
+	vmd, ok := resolutions.Locked.Find(pkg)
+	var err error
+	if !ok {
+		vmd, err = fetchVersionMetadata(pkg, config, resolutions)
+	}
 	stats.IncrementResolveCount()
 
 	if err != nil {
 		slog.Error(fmt.Sprintf("[METADATA] ❌ %s@%s\t%v", pkg.Name, pkg.Version, err))
+		failures.Add(fmt.Errorf("failed to resolve %s@%s: %w", pkg.Name, pkg.Version, err))
 		return
 	}
 
@@ -90,30 +209,45 @@ func ResolvePackageMetadata(metadataWg, downloadWg *sync.WaitGroup, pkg *types.P
 		Name:    vmd.Name,
 		Version: vmd.Version,
 		Dist:    vmd.Dist,
-		// Dependencies: vmd.Dependencies, // make this return a types.MPackage and redo this?
 	}
-	stats.IncrementTotalDownloadCount()
-
-	downloadWg.Add(1)
-	downloadChannel <- &packageToBeDownloaded
 
+	parents := append(append(make([]types.Package, 0, len(request.Parents)+1), request.Parents...), types.Package{Name: vmd.Name, Version: vmd.Version})
+	dir := declaringDir(vmd.Version)
+	requests := make([]*Request, 0, len(vmd.Dependencies))
 	for depName, depVersion := range vmd.Dependencies {
-		depPkg := &types.Package{Name: depName, Version: depVersion}
+		requests = append(requests, resolutions.NewRequest(depName, depVersion, parents, dir))
+	}
+	// another range already resolved to this exact version in the same context, so its dependencies are
+	// being walked already
+	isNew, isNewContext := resolutions.Resolve(pkg, &packageToBeDownloaded, requests, resolutions.Overrides.Context(parents))
+	if !isNewContext {
+		return
+	}
+	// linked packages are used straight from disk, there's nothing to download
+	if _, isLink := spec.ParseLink(vmd.Version); isNew && !isLink {
+		stats.IncrementTotalDownloadCount()
+
+		downloadWg.Add(1)
+		downloadChannel <- &packageToBeDownloaded
+	}
+
+	for _, depRequest := range requests {
 		stats.IncrementTotalResolveCount()
 
 		metadataWg.Add(1)
-		go func(depPkg *types.Package) {
-			metadataChannel <- depPkg
-		}(depPkg)
+		go func(depRequest *Request) {
+			metadataChannel <- depRequest
+		}(depRequest)
 	}
 }
 
-func DownloadPackageTarball(downloadWg *sync.WaitGroup, mPkg *types.MPackage, config *types.YapConfig, stats *logger.Stats) {
+func DownloadPackageTarball(downloadWg *sync.WaitGroup, mPkg *types.MPackage, config *types.YapConfig, stats *logger.Stats, failures *Failures) {
 	defer downloadWg.Done()
 	slog.Info(fmt.Sprintf("[TARBALL] 🚚 %s@%s", mPkg.Name, mPkg.Version))
 
-	if err := downloader.DownloadPackage(&types.Package{Name: mPkg.Name, Version: mPkg.Version}, &mPkg.Dist.Tarball, config, false); err != nil {
+	if err := downloader.DownloadPackage(&types.Package{Name: mPkg.Name, Version: mPkg.Version}, &mPkg.Dist, config, false); err != nil {
 		slog.Error(fmt.Sprintf("[TARBALL] ❌ %s@%s\t%v", mPkg.Name, mPkg.Version, err))
+		failures.Add(fmt.Errorf("failed to download %s@%s: %w", mPkg.Name, mPkg.Version, err))
 		return
 	}
 
@@ -0,0 +1,100 @@
+package install
+
+import (
+	"fmt"
+
+	"github.com/Eyepan/yap/src/types"
+	"github.com/Masterminds/semver/v3"
+)
+
+// Locked answers requests from the previous lockfile, so installs stay on the versions it pinned
+// unless package.json asks for something they don't satisfy
+type Locked struct {
+	byName map[string][]*types.MPackage
+	direct map[string]bool // name@version of what the importers depend on
+	// which versions the overrides forced, since the ranges they matched are gone once pinned
+	overrides []types.LockedOverride
+}
+
+func NewLocked(lockfile *types.Lockfile) *Locked {
+	locked := &Locked{byName: make(map[string][]*types.MPackage), direct: make(map[string]bool), overrides: lockfile.Overrides}
+	for i := range lockfile.Resolutions {
+		mPkg := &lockfile.Resolutions[i]
+		locked.byName[mPkg.Name] = append(locked.byName[mPkg.Name], mPkg)
+	}
+	deps := append([]types.Package{}, lockfile.CoreDependencies...)
+	for _, importer := range lockfile.Importers {
+		deps = append(deps, importer.Dependencies...)
+	}
+	for _, dep := range deps {
+		locked.direct[fmt.Sprintf("%s@%s", dep.Name, dep.Version)] = true
+	}
+	return locked
+}
+
+// Find returns the highest locked registry version satisfying pkg, preferring the versions importers depend
+// on so a lockfile with several versions of a package (like an imported one) keeps them where they were.
+// Packages from git, local paths or tarball urls always go through their fetchers, which are cheap for the
+// pinned specs the lockfile records
+func (l *Locked) Find(pkg *types.Package) (types.VersionMetadata, bool) {
+	if l == nil {
+		return types.VersionMetadata{}, false
+	}
+	constraint, err := semver.NewConstraint(pkg.Version)
+	if err != nil {
+		return types.VersionMetadata{}, false
+	}
+
+	var best *types.MPackage
+	var bestVersion *semver.Version
+	bestDirect := false
+	for _, mPkg := range l.byName[pkg.Name] {
+		version, err := semver.NewVersion(mPkg.Version)
+		if err != nil || !constraint.Check(version) {
+			continue
+		}
+		direct := l.direct[fmt.Sprintf("%s@%s", mPkg.Name, mPkg.Version)]
+		if bestVersion == nil || (direct && !bestDirect) || (direct == bestDirect && version.GreaterThan(bestVersion)) {
+			best, bestVersion, bestDirect = mPkg, version, direct
+		}
+	}
+	if best == nil {
+		return types.VersionMetadata{}, false
+	}
+
+	vmd := types.VersionMetadata{
+		Name:         best.Name,
+		Version:      best.Version,
+		Dist:         best.Dist,
+		Dependencies: make(types.Dependencies, len(best.Dependencies)),
+	}
+	for _, dep := range best.Dependencies {
+		if dep.Alias != "" {
+			vmd.Dependencies[dep.Alias] = fmt.Sprintf("npm:%s@%s", dep.Name, dep.Version)
+		} else {
+			vmd.Dependencies[dep.Name] = dep.Version
+		}
+	}
+	return vmd, true
+}
+
+// Pinned returns the locked package pkg asks for exactly, which is how packages from tarball urls are locked
+func (l *Locked) Pinned(pkg *types.Package) (*types.MPackage, bool) {
+	if l == nil {
+		return nil, false
+	}
+	for _, mPkg := range l.byName[pkg.Name] {
+		if mPkg.Version == pkg.Version {
+			return mPkg, true
+		}
+	}
+	return nil, false
+}
+
+// Overrides returns the overrides the lockfile recorded and the versions they forced
+func (l *Locked) Overrides() []types.LockedOverride {
+	if l == nil {
+		return nil
+	}
+	return l.overrides
+}
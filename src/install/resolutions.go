@@ -0,0 +1,229 @@
+package install
+
+import (
+	"fmt"
+	"log/slog"
+	"slices"
+	"sort"
+	"sync"
+
+	"github.com/Eyepan/yap/src/overrides"
+	"github.com/Eyepan/yap/src/spec"
+	"github.com/Eyepan/yap/src/types"
+	"github.com/Eyepan/yap/src/utils"
+)
+
+// Resolutions keeps track of what every requested range resolved to, so the lockfile
+// and node_modules can be built once all the metadata workers are done
+type Resolutions struct {
+	Overrides *overrides.Overrides
+	Locked    *Locked // nil when there's no usable lockfile
+	// whether a package may run scripts, like the prepare script of a git dependency
+	AllowsScripts func(name string) bool
+
+	ranges   sync.Map // "name@range" -> resolved version
+	versions sync.Map // "name@version" -> true
+	packages sync.Map // "name@version\x00context" -> *resolvedPackage
+}
+
+type resolvedPackage struct {
+	mPkg     *types.MPackage
+	requests []*Request
+}
+
+// NewRequest builds the request for a dependency entry declared in dir, with the overrides matching its
+// parents applied. Dependencies pinned by the lockfile also match the overrides it recorded forcing them.
+// Relative file: and link: paths are made relative to the root, so the same package is the
+// same request wherever it's declared from
+func (r *Resolutions) NewRequest(name, specifier string, parents []types.Package, dir string) *Request {
+	if dir != "" {
+		specifier = spec.Rebase(specifier, dir)
+	}
+	request := &Request{Package: spec.ParseDependency(name, specifier), Parents: parents, Dir: dir}
+	rule := r.Overrides.Apply(&request.Package, parents, r.Locked.Overrides())
+	if rule == nil {
+		return request
+	}
+
+	request.Override = rule
+	if realName, versionRange, ok := spec.ParseAlias(rule.Version); ok {
+		if realName != name {
+			request.Package.Alias = name
+		}
+		request.Package.Name, request.Package.Version = realName, versionRange
+	} else {
+		request.Package.Version = rule.Version
+	}
+	return request
+}
+
+func rangeKey(pkg *types.Package) string {
+	return fmt.Sprintf("%s@%s", pkg.Name, pkg.Version)
+}
+
+// Resolve records that pkg resolved to mPkg along parents summed up by context (see Overrides.Context). It
+// reports whether the version is new, so it has to be downloaded, and whether it's new in that context, so
+// its dependencies have to be walked
+func (r *Resolutions) Resolve(pkg *types.Package, mPkg *types.MPackage, requests []*Request, context string) (bool, bool) {
+	r.ranges.Store(rangeKey(pkg), mPkg.Version)
+	id := fmt.Sprintf("%s@%s", mPkg.Name, mPkg.Version)
+	_, knownVersion := r.versions.LoadOrStore(id, true)
+	_, knownContext := r.packages.LoadOrStore(id+"\x00"+context, &resolvedPackage{mPkg: mPkg, requests: requests})
+	return !knownVersion, !knownContext
+}
+
+func (r *Resolutions) resolveAll(baseDependencies types.Dependencies, applied map[string][]string) []types.Package {
+	var deps []types.Package
+	for name, version := range baseDependencies {
+		request := r.NewRequest(name, version, nil, "")
+		pkg, ok := r.lookup(request.Package)
+		if !ok {
+			slog.Warn(fmt.Sprintf("%s@%s was not resolved, leaving it out of the lockfile", name, version))
+			continue
+		}
+		recordOverride(applied, request, pkg)
+		deps = append(deps, pkg)
+	}
+	utils.SortPackages(deps)
+	return deps
+}
+
+func (r *Resolutions) lookup(pkg types.Package) (types.Package, bool) {
+	version, ok := r.ranges.Load(rangeKey(&pkg))
+	if !ok {
+		return types.Package{}, false
+	}
+	return types.Package{Name: pkg.Name, Version: version.(string), Alias: pkg.Alias}, true
+}
+
+// recordOverride remembers which package the override of request forced, for the lockfile
+func recordOverride(applied map[string][]string, request *Request, pkg types.Package) {
+	if request.Override == nil {
+		return
+	}
+	key := request.Override.Selector() + "\x00" + request.Override.Version
+	id := fmt.Sprintf("%s@%s", pkg.Name, pkg.Version)
+	if !slices.Contains(applied[key], id) {
+		applied[key] = append(applied[key], id)
+	}
+}
+
+// dependencyName is the name a request is installed under in node_modules
+func dependencyName(request *Request) string {
+	if request.Package.Alias != "" {
+		return request.Package.Alias
+	}
+	return request.Package.Name
+}
+
+// preferred picks between the requests for the same dependency of a package reached along different parent
+// chains. The one decided by the more specific override wins, so the lockfile doesn't depend on which chain
+// got there first
+func preferred(a, b *Request) *Request {
+	specificity := func(request *Request) int {
+		if request.Override == nil {
+			return -1
+		}
+		return len(request.Override.Parents)
+	}
+	if specificity(a) != specificity(b) {
+		if specificity(a) > specificity(b) {
+			return a
+		}
+		return b
+	}
+	key := func(request *Request) string {
+		key := rangeKey(&request.Package)
+		if request.Override != nil {
+			key += "\x00" + request.Override.Selector()
+		}
+		return key
+	}
+	if key(a) <= key(b) {
+		return a
+	}
+	return b
+}
+
+// Lockfile builds the lockfile for the resolved tree. Dependencies of every resolution only carry
+// the name, version and alias of the package they point at, the full entry is in Resolutions. A version
+// reached along chains that parent-scoped overrides treat differently gets, for each of its dependencies,
+// the one decided by the most specific override, and whatever is left unreachable after that is dropped
+func (r *Resolutions) Lockfile(importers map[string]types.Dependencies) types.Lockfile {
+	var lockfile types.Lockfile
+	applied := make(map[string][]string)
+	var roots []types.Package
+	for dir, baseDependencies := range importers {
+		deps := r.resolveAll(baseDependencies, applied)
+		roots = append(roots, deps...)
+		if dir == "." {
+			lockfile.CoreDependencies = deps
+			continue
+		}
+		lockfile.Importers = append(lockfile.Importers, types.Importer{Path: dir, Dependencies: deps})
+	}
+	sort.Slice(lockfile.Importers, func(i, j int) bool {
+		return lockfile.Importers[i].Path < lockfile.Importers[j].Path
+	})
+
+	// merge the contexts every version was walked in, one request per dependency
+	resolved := make(map[string]*types.MPackage)
+	edges := make(map[string]map[string]*Request)
+	r.packages.Range(func(_, value any) bool {
+		pkg := value.(*resolvedPackage)
+		id := fmt.Sprintf("%s@%s", pkg.mPkg.Name, pkg.mPkg.Version)
+		if resolved[id] == nil {
+			resolved[id] = pkg.mPkg
+			edges[id] = make(map[string]*Request)
+		}
+		for _, request := range pkg.requests {
+			name := dependencyName(request)
+			if current, ok := edges[id][name]; ok {
+				request = preferred(current, request)
+			}
+			edges[id][name] = request
+		}
+		return true
+	})
+
+	reached := make(map[string]bool)
+	for len(roots) > 0 {
+		root := roots[len(roots)-1]
+		roots = roots[:len(roots)-1]
+		id := fmt.Sprintf("%s@%s", root.Name, root.Version)
+		if reached[id] || resolved[id] == nil {
+			continue
+		}
+		reached[id] = true
+
+		mPkg := *resolved[id]
+		mPkg.Dependencies = nil
+		for _, request := range edges[id] {
+			dep, ok := r.lookup(request.Package)
+			if !ok {
+				slog.Warn(fmt.Sprintf("%s@%s (dependency of %s@%s) was not resolved", request.Package.Name, request.Package.Version, mPkg.Name, mPkg.Version))
+				continue
+			}
+			recordOverride(applied, request, dep)
+			mPkg.Dependencies = append(mPkg.Dependencies, &types.MPackage{Name: dep.Name, Version: dep.Version, Alias: dep.Alias})
+			roots = append(roots, dep)
+		}
+		utils.SortDependencies(mPkg.Dependencies)
+		lockfile.Resolutions = append(lockfile.Resolutions, mPkg)
+	}
+	sort.Slice(lockfile.Resolutions, func(i, j int) bool {
+		if lockfile.Resolutions[i].Name != lockfile.Resolutions[j].Name {
+			return lockfile.Resolutions[i].Name < lockfile.Resolutions[j].Name
+		}
+		return lockfile.Resolutions[i].Version < lockfile.Resolutions[j].Version
+	})
+
+	if r.Overrides != nil {
+		for _, rule := range r.Overrides.Rules {
+			ids := append([]string{}, applied[rule.Selector()+"\x00"+rule.Version]...)
+			sort.Strings(ids)
+			lockfile.Overrides = append(lockfile.Overrides, types.LockedOverride{Selector: rule.Selector(), Version: rule.Version, Applied: ids})
+		}
+	}
+	return lockfile
+}
@@ -0,0 +1,153 @@
+package install
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/Eyepan/yap/src/downloader"
+	"github.com/Eyepan/yap/src/git"
+	"github.com/Eyepan/yap/src/metadata"
+	"github.com/Eyepan/yap/src/pack"
+	"github.com/Eyepan/yap/src/packagejson"
+	"github.com/Eyepan/yap/src/spec"
+	"github.com/Eyepan/yap/src/types"
+	"github.com/Eyepan/yap/src/utils"
+)
+
+// fetchVersionMetadata resolves pkg from wherever its specifier points to. Anything that isn't
+// a registry range gets its pinned specifier as the version, like npm does in package-lock.json
+func fetchVersionMetadata(pkg *types.Package, config *types.YapConfig, resolutions *Resolutions) (types.VersionMetadata, error) {
+	if path, ok := spec.ParseLink(pkg.Version); ok {
+		return fetchLinkedPackage(pkg, path)
+	}
+	if path, ok := spec.ParseFile(pkg.Version); ok {
+		return fetchFilePackage(pkg, path)
+	}
+	if gitSpec, ok := spec.ParseGit(pkg.Version); ok {
+		return git.FetchPackage(pkg.Name, gitSpec, resolutions.AllowsScripts(pkg.Name))
+	}
+	if spec.IsTarballURL(pkg.Version) {
+		return fetchRemoteTarball(pkg, config, resolutions.Locked)
+	}
+	return metadata.FetchVersionMetadata(pkg, config, false)
+}
+
+// fetchLinkedPackage doesn't touch the store, the linker symlinks the directory as is
+// and whoever owns it is responsible for its dependencies
+func fetchLinkedPackage(pkg *types.Package, path string) (types.VersionMetadata, error) {
+	if _, err := os.Stat(filepath.FromSlash(path)); err != nil {
+		return types.VersionMetadata{}, fmt.Errorf("failed to find linked package %s: %w", path, err)
+	}
+	return types.VersionMetadata{Name: pkg.Name, Version: "link:" + path}, nil
+}
+
+// fetchFilePackage packs a local directory (or reads a local tarball) and extracts it into the store.
+// Local files can change under the same path, so they're always extracted again
+func fetchFilePackage(pkg *types.Package, path string) (types.VersionMetadata, error) {
+	localPath := filepath.FromSlash(path)
+	info, err := os.Stat(localPath)
+	if err != nil {
+		return types.VersionMetadata{}, fmt.Errorf("failed to find local package %s: %w", path, err)
+	}
+
+	var tarball *bytes.Buffer
+	var fileCount int
+	if info.IsDir() {
+		if tarball, fileCount, err = pack.PackDirectory(localPath); err != nil {
+			return types.VersionMetadata{}, err
+		}
+	} else {
+		data, err := os.ReadFile(localPath)
+		if err != nil {
+			return types.VersionMetadata{}, fmt.Errorf("failed to read local tarball %s: %w", path, err)
+		}
+		tarball = bytes.NewBuffer(data)
+	}
+
+	vmd := types.VersionMetadata{
+		Name:    pkg.Name,
+		Version: "file:" + path,
+		Dist: types.Dist{
+			Tarball:   "file:" + path,
+			Shasum:    utils.ComputeShasum(tarball.Bytes()),
+			Integrity: utils.ComputeIntegrity(tarball.Bytes()),
+			FileCount: int64(fileCount),
+		},
+	}
+	if err := extractIntoStore(pkg.Name, vmd.Version, "", tarball, true); err != nil {
+		return types.VersionMetadata{}, err
+	}
+	return withStoredDependencies(vmd)
+}
+
+// declaringDir is the directory the dependencies of a resolved package are declared in, for local packages.
+// Everything else declares them nowhere on disk, so relative paths in them stay relative to the root
+func declaringDir(version string) string {
+	localPath, ok := spec.ParseFile(version)
+	if !ok {
+		return ""
+	}
+	if info, err := os.Stat(filepath.FromSlash(localPath)); err == nil && !info.IsDir() {
+		// a local tarball, next to the packages it refers to
+		return path.Dir(localPath)
+	}
+	return localPath
+}
+
+// fetchRemoteTarball downloads a tarball url, so its integrity can be locked along with the url. Once it's
+// locked, the copy in the store is used when it has the same integrity, and a download that doesn't match
+// the lockfile fails instead of changing what's installed
+func fetchRemoteTarball(pkg *types.Package, config *types.YapConfig, locked *Locked) (types.VersionMetadata, error) {
+	vmd := types.VersionMetadata{Name: pkg.Name, Version: pkg.Version, Dist: types.Dist{Tarball: pkg.Version}}
+	if mPkg, ok := locked.Pinned(pkg); ok {
+		vmd.Dist = mPkg.Dist
+	}
+	if index, err := downloader.ReadStoreIndex(pkg.Name, pkg.Version); err == nil && index.Complete && index.Integrity != "" &&
+		(vmd.Dist.Integrity == "" || index.Integrity == vmd.Dist.Integrity) {
+		vmd.Dist.Integrity = index.Integrity
+		return withStoredDependencies(vmd)
+	}
+
+	tarball, err := downloader.DownloadTarball(&pkg.Version, config)
+	if err != nil {
+		return types.VersionMetadata{}, fmt.Errorf("failed to download %s: %w", pkg.Version, err)
+	}
+	if err := utils.CheckIntegrity(tarball.Bytes(), vmd.Dist.Integrity, vmd.Dist.Shasum); err != nil {
+		return types.VersionMetadata{}, fmt.Errorf("%s doesn't match the lockfile: %w", pkg.Version, err)
+	}
+	vmd.Dist.Shasum = utils.ComputeShasum(tarball.Bytes())
+	vmd.Dist.Integrity = utils.ComputeIntegrity(tarball.Bytes())
+	// the store may have an older download of the url that doesn't match anymore
+	if err := extractIntoStore(pkg.Name, vmd.Version, pkg.Version, tarball, true); err != nil {
+		return types.VersionMetadata{}, err
+	}
+	return withStoredDependencies(vmd)
+}
+
+// extractIntoStore extracts the tarball unless the store already has a complete copy, or replace is set
+func extractIntoStore(name, version, source string, tarball *bytes.Buffer, replace bool) error {
+	if complete, _ := downloader.CheckIfPackageIsAlreadyDownloaded(&types.Package{Name: name, Version: version}); complete && !replace {
+		return nil
+	}
+	if err := downloader.ExtractTarball(tarball, name, version, source); err != nil {
+		return fmt.Errorf("failed while extracting tarball: %w", err)
+	}
+	return nil
+}
+
+// withStoredDependencies fills in the dependencies from the package.json of the extracted package
+func withStoredDependencies(vmd types.VersionMetadata) (types.VersionMetadata, error) {
+	storeDir, err := utils.GetPackageStoreDir(vmd.Name, vmd.Version)
+	if err != nil {
+		return types.VersionMetadata{}, fmt.Errorf("failed to get store directory: %w", err)
+	}
+	pkgJSON, err := packagejson.ReadPackageJSON(storeDir)
+	if err != nil {
+		return types.VersionMetadata{}, fmt.Errorf("failed to read package.json of %s@%s: %w", vmd.Name, vmd.Version, err)
+	}
+	vmd.Dependencies = pkgJSON.Dependencies
+	return vmd, nil
+}
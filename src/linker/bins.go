@@ -0,0 +1,71 @@
+package linker
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Eyepan/yap/src/packagejson"
+)
+
+// LinkBins symlinks the commands of the package in pkgDir into binDir, making sure their targets are
+// executable and fixing windows line endings on their shebang so `env` can find the interpreter
+func LinkBins(binDir, pkgDir string) error {
+	pkgJSON, err := packagejson.ReadPackageJSON(pkgDir)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to read package.json in %s: %w", pkgDir, err)
+	}
+
+	for name, path := range packagejson.GetBinaries(&pkgJSON, pkgDir) {
+		if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+			slog.Warn(fmt.Sprintf("skipping invalid bin name %q of %s", name, pkgJSON.Name))
+			continue
+		}
+		target := filepath.Join(pkgDir, filepath.FromSlash(path))
+		if relativeTarget, err := filepath.Rel(pkgDir, target); err != nil || strings.HasPrefix(relativeTarget, "..") {
+			slog.Warn(fmt.Sprintf("skipping bin %s of %s, it points outside of the package", name, pkgJSON.Name))
+			continue
+		}
+		info, err := os.Stat(target)
+		if err != nil {
+			slog.Warn(fmt.Sprintf("skipping bin %s of %s: %v", name, pkgJSON.Name, err))
+			continue
+		}
+
+		if err := prepareBin(target, info.Mode().Perm()); err != nil {
+			return fmt.Errorf("failed to make %s executable: %w", target, err)
+		}
+		if err := Symlink(target, filepath.Join(binDir, name)); err != nil {
+			return fmt.Errorf("failed to link bin %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// prepareBin makes a command executable and turns a `#!/usr/bin/env node\r\n` first line into
+// `#!/usr/bin/env node\n`. The store extracts commands executable already, but packages extracted by older
+// versions of yap aren't, and the file is replaced rather than changed in place, since it's usually hard
+// linked to the store
+func prepareBin(path string, mode os.FileMode) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	fixed := data
+	if lineEnd := bytes.IndexByte(data, '\n'); bytes.HasPrefix(data, []byte("#!")) && lineEnd >= 1 && data[lineEnd-1] == '\r' {
+		fixed = append(append([]byte{}, data[:lineEnd-1]...), data[lineEnd:]...)
+	} else if mode&0111 == 0111 {
+		return nil
+	}
+
+	replacement := path + ".yap-bin"
+	if err := os.WriteFile(replacement, fixed, mode|0111); err != nil {
+		return err
+	}
+	return os.Rename(replacement, path)
+}
@@ -0,0 +1,51 @@
+package linker
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Eyepan/yap/src/packagejson"
+)
+
+func TestLinkBinsReadsDependenciesWithOldManifestFields(t *testing.T) {
+	pkgDir := filepath.Join(t.TempDir(), "node_modules", "legacy")
+	binDir := filepath.Join(filepath.Dir(pkgDir), ".bin")
+	if err := os.MkdirAll(filepath.Join(pkgDir, "bin"), 0755); err != nil {
+		t.Fatalf("failed to create %s: %v", pkgDir, err)
+	}
+	// the deprecated object license, and main and files that don't follow the schema either
+	manifest := `{
+  "name": "legacy",
+  "version": "1.0.0",
+  "license": {"type": "MIT", "url": "https://opensource.org/licenses/MIT"},
+  "main": ["index.js"],
+  "files": "bin",
+  "bin": {"legacy": "bin/legacy.js"}
+}`
+	if err := os.WriteFile(filepath.Join(pkgDir, "package.json"), []byte(manifest), 0644); err != nil {
+		t.Fatalf("failed to write package.json: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(pkgDir, "bin", "legacy.js"), []byte("#!/usr/bin/env node\n"), 0644); err != nil {
+		t.Fatalf("failed to write bin: %v", err)
+	}
+
+	pkgJSON, err := packagejson.ReadPackageJSON(pkgDir)
+	if err != nil {
+		t.Fatalf("failed to read package.json: %v", err)
+	}
+	if pkgJSON.License != "MIT" || pkgJSON.Main != "" || len(pkgJSON.Files) != 1 || pkgJSON.Files[0] != "bin" {
+		t.Errorf("read license %q, main %q and files %q", pkgJSON.License, pkgJSON.Main, pkgJSON.Files)
+	}
+
+	if err := LinkBins(binDir, pkgDir); err != nil {
+		t.Fatalf("LinkBins failed: %v", err)
+	}
+	target, err := filepath.EvalSymlinks(filepath.Join(binDir, "legacy"))
+	if err != nil {
+		t.Fatalf("legacy wasn't linked: %v", err)
+	}
+	if want, _ := filepath.EvalSymlinks(filepath.Join(pkgDir, "bin", "legacy.js")); target != want {
+		t.Errorf("legacy links to %s, want %s", target, want)
+	}
+}
@@ -0,0 +1,194 @@
+package linker
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/Eyepan/yap/src/spec"
+	"github.com/Eyepan/yap/src/types"
+	"github.com/Eyepan/yap/src/utils"
+)
+
+// GetVirtualStoreDir returns the directory inside node_modules that holds one folder per resolved package
+func GetVirtualStoreDir(projectDir string) string {
+	return filepath.Join(projectDir, "node_modules", ".yap")
+}
+
+// GetVirtualModulesDir returns the node_modules folder a resolved package and its dependencies live in.
+// The package itself is at <dir>/<name>, its dependencies are symlinked next to it so node can find them
+func GetVirtualModulesDir(projectDir, name, version string) string {
+	return filepath.Join(GetVirtualStoreDir(projectDir), utils.EncodePackageID(name, version), "node_modules")
+}
+
+// GetPackageDir returns where a resolved package lives inside the project, which for
+// link: dependencies is the linked directory itself
+func GetPackageDir(projectDir, name, version string) string {
+	if path, ok := spec.ParseLink(version); ok {
+		if filepath.IsAbs(filepath.FromSlash(path)) {
+			return filepath.FromSlash(path)
+		}
+		return filepath.Join(projectDir, filepath.FromSlash(path))
+	}
+	return filepath.Join(GetVirtualModulesDir(projectDir, name, version), name)
+}
+
+// LinkName is the directory name a package is reachable under from its dependent
+func LinkName(name, alias string) string {
+	if alias != "" {
+		return alias
+	}
+	return name
+}
+
+// LinkPackages lays out projectDir/node_modules from the lockfile, much like pnpm does.
+// Every resolved package gets a copy in the virtual store made of hard links into ~/.yap_store,
+// its dependencies are symlinked next to it and the direct dependencies are symlinked into node_modules.
+// The commands of every dependency go into the node_modules/.bin of whoever depends on it.
+func LinkPackages(projectDir string, lockfile *types.Lockfile) error {
+	for i := range lockfile.Resolutions {
+		mPkg := &lockfile.Resolutions[i]
+		if _, isLink := spec.ParseLink(mPkg.Version); isLink {
+			continue
+		}
+		modulesDir := GetVirtualModulesDir(projectDir, mPkg.Name, mPkg.Version)
+		if err := populatePackage(mPkg, filepath.Join(modulesDir, mPkg.Name)); err != nil {
+			return fmt.Errorf("failed to link %s@%s from the store: %w", mPkg.Name, mPkg.Version, err)
+		}
+		for _, dep := range mPkg.Dependencies {
+			depName := LinkName(dep.Name, dep.Alias)
+			if depName == mPkg.Name {
+				continue
+			}
+			target := GetPackageDir(projectDir, dep.Name, dep.Version)
+			if err := Symlink(target, filepath.Join(modulesDir, depName)); err != nil {
+				return fmt.Errorf("failed to link dependency %s of %s@%s: %w", depName, mPkg.Name, mPkg.Version, err)
+			}
+			if err := LinkBins(filepath.Join(modulesDir, mPkg.Name, "node_modules", ".bin"), target); err != nil {
+				return fmt.Errorf("failed to link bins of %s for %s@%s: %w", depName, mPkg.Name, mPkg.Version, err)
+			}
+		}
+	}
+
+	if err := linkImporter(projectDir, projectDir, lockfile.CoreDependencies); err != nil {
+		return err
+	}
+	for _, importer := range lockfile.Importers {
+		if err := linkImporter(projectDir, filepath.Join(projectDir, filepath.FromSlash(importer.Path)), importer.Dependencies); err != nil {
+			return fmt.Errorf("failed to link workspace package %s: %w", importer.Path, err)
+		}
+	}
+	return nil
+}
+
+// linkImporter symlinks the direct dependencies of an importer into its own node_modules
+func linkImporter(projectDir, importerDir string, deps []types.Package) error {
+	for _, pkg := range deps {
+		target := GetPackageDir(projectDir, pkg.Name, pkg.Version)
+		if err := Symlink(target, filepath.Join(importerDir, "node_modules", LinkName(pkg.Name, pkg.Alias))); err != nil {
+			return fmt.Errorf("failed to link %s: %w", LinkName(pkg.Name, pkg.Alias), err)
+		}
+		if err := LinkBins(filepath.Join(importerDir, "node_modules", ".bin"), target); err != nil {
+			return fmt.Errorf("failed to link bins of %s: %w", LinkName(pkg.Name, pkg.Alias), err)
+		}
+	}
+	return nil
+}
+
+// populatePackage recreates the store contents of a package at pkgDir using hard links,
+// falling back to copying when the store lives on another device
+func populatePackage(mPkg *types.MPackage, pkgDir string) error {
+	if _, err := os.Stat(pkgDir); err == nil {
+		if !spec.IsMutable(mPkg.Version) {
+			return nil
+		}
+		if err := os.RemoveAll(pkgDir); err != nil {
+			return fmt.Errorf("failed to remove stale copy: %w", err)
+		}
+	}
+	storeDir, err := utils.GetPackageStoreDir(mPkg.Name, mPkg.Version)
+	if err != nil {
+		return fmt.Errorf("failed to get store directory: %w", err)
+	}
+	if _, err := os.Stat(storeDir); err != nil {
+		return fmt.Errorf("package is missing from the store: %w", err)
+	}
+
+	return filepath.WalkDir(storeDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		relativePath, err := filepath.Rel(storeDir, path)
+		if err != nil {
+			return err
+		}
+		destination := filepath.Join(pkgDir, relativePath)
+		if d.IsDir() {
+			return os.MkdirAll(destination, 0755)
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		if err := os.Link(path, destination); err != nil {
+			return copyFile(path, destination)
+		}
+		return nil
+	})
+}
+
+// DetachFromStore replaces the hard links inside pkgDir with copies, so whatever gets written
+// there (by install scripts for example) doesn't end up in the store and every other project
+func DetachFromStore(pkgDir string) error {
+	return filepath.WalkDir(pkgDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+		detached := path + ".yap-detach"
+		if err := copyFile(path, detached); err != nil {
+			return err
+		}
+		return os.Rename(detached, path)
+	})
+}
+
+func copyFile(source, destination string) error {
+	info, err := os.Stat(source)
+	if err != nil {
+		return err
+	}
+	in, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(destination, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	if _, err := io.Copy(out, in); err != nil {
+		return fmt.Errorf("failed to copy %s: %w", source, err)
+	}
+	return nil
+}
+
+// Symlink points link at target with a relative path, replacing whatever was at link before
+func Symlink(target, link string) error {
+	if err := os.MkdirAll(filepath.Dir(link), 0755); err != nil {
+		return fmt.Errorf("failed to create directory for %s: %w", link, err)
+	}
+	if _, err := os.Lstat(link); err == nil {
+		if err := os.RemoveAll(link); err != nil {
+			return fmt.Errorf("failed to remove %s: %w", link, err)
+		}
+	}
+	relativeTarget, err := filepath.Rel(filepath.Dir(link), target)
+	if err != nil {
+		return err
+	}
+	return os.Symlink(relativeTarget, link)
+}
@@ -0,0 +1,49 @@
+package lockexport
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/Eyepan/yap/src/types"
+)
+
+// Formats maps the lockfile formats yap can export to the file they're written to
+var Formats = map[string]string{
+	"npm": "package-lock.json",
+}
+
+// FormatNames lists the supported formats, for error messages
+func FormatNames() string {
+	names := make([]string, 0, len(Formats))
+	for name := range Formats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// Export writes the lockfile in another package manager's format next to the yap lockfile in rootDir and
+// returns the name of the file it wrote
+func Export(rootDir, format string, lockfile *types.Lockfile) (string, error) {
+	fileName, ok := Formats[format]
+	if !ok {
+		return "", fmt.Errorf("unknown lockfile format %s, expected one of %s", format, FormatNames())
+	}
+
+	var data []byte
+	var err error
+	switch format {
+	case "npm":
+		data, err = Npm(rootDir, lockfile)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to build %s: %w", fileName, err)
+	}
+	if err := os.WriteFile(filepath.Join(rootDir, fileName), data, 0644); err != nil {
+		return "", fmt.Errorf("failed to write %s: %w", fileName, err)
+	}
+	return fileName, nil
+}
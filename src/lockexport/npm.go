@@ -0,0 +1,363 @@
+package lockexport
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/Eyepan/yap/src/packagejson"
+	"github.com/Eyepan/yap/src/spec"
+	"github.com/Eyepan/yap/src/types"
+	"github.com/Eyepan/yap/src/utils"
+	"github.com/Eyepan/yap/src/workspace"
+)
+
+type npmLockfile struct {
+	Name            string               `json:"name,omitempty"`
+	Version         string               `json:"version,omitempty"`
+	LockfileVersion int                  `json:"lockfileVersion"`
+	Requires        bool                 `json:"requires"`
+	Packages        map[string]*npmEntry `json:"packages"`
+}
+
+// npmEntry is an entry of the packages field, with the fields in the order npm writes them
+type npmEntry struct {
+	Name                 string             `json:"name,omitempty"`
+	Version              string             `json:"version,omitempty"`
+	Resolved             string             `json:"resolved,omitempty"`
+	Integrity            string             `json:"integrity,omitempty"`
+	Link                 bool               `json:"link,omitempty"`
+	Dev                  bool               `json:"dev,omitempty"`
+	Optional             bool               `json:"optional,omitempty"`
+	DevOptional          bool               `json:"devOptional,omitempty"`
+	Peer                 bool               `json:"peer,omitempty"`
+	License              string             `json:"license,omitempty"`
+	Workspaces           types.Workspaces   `json:"workspaces,omitempty"`
+	Dependencies         types.Dependencies `json:"dependencies,omitempty"`
+	DevDependencies      types.Dependencies `json:"devDependencies,omitempty"`
+	OptionalDependencies types.Dependencies `json:"optionalDependencies,omitempty"`
+	PeerDependencies     types.Dependencies `json:"peerDependencies,omitempty"`
+	Bin                  map[string]string  `json:"bin,omitempty"`
+	Engines              json.RawMessage    `json:"engines,omitempty"`
+}
+
+// npmEdge is a dependency that was placed, remembered so hoisting never shadows it
+type npmEdge struct {
+	from, name, to string
+}
+
+// npmLayout places every package in a hoisted node_modules tree, the way npm lays it out
+type npmLayout struct {
+	resolutions map[string]*types.MPackage // by name@version
+	placed      map[string]string          // location -> name@version
+	edges       []npmEdge
+	queue       []string
+}
+
+// Npm builds a lockfileVersion 3 package-lock.json. yap links packages into an isolated virtual store,
+// so the packages field describes the hoisted layout npm would install the same versions in
+func Npm(rootDir string, lockfile *types.Lockfile) ([]byte, error) {
+	rootManifest, err := readManifest(rootDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read package.json: %w", err)
+	}
+	workspacePackages, err := workspace.DiscoverPackages(rootDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read workspace: %w", err)
+	}
+	manifests := map[string]*manifest{".": rootManifest}
+	for _, pkg := range workspacePackages {
+		if manifests[pkg.Dir], err = readManifest(filepath.Join(rootDir, filepath.FromSlash(pkg.Dir))); err != nil {
+			return nil, fmt.Errorf("failed to read package.json of %s: %w", pkg.Dir, err)
+		}
+	}
+
+	layout := &npmLayout{resolutions: make(map[string]*types.MPackage), placed: make(map[string]string)}
+	for i := range lockfile.Resolutions {
+		mPkg := &lockfile.Resolutions[i]
+		layout.resolutions[packageID(mPkg.Name, mPkg.Version)] = mPkg
+	}
+	importers := append([]types.Importer{{Path: ".", Dependencies: lockfile.CoreDependencies}}, lockfile.Importers...)
+	for _, importer := range importers {
+		location := importerLocation(importer.Path)
+		for _, dep := range importer.Dependencies {
+			layout.place(location, requiredAs(dep.Name, dep.Alias), packageID(dep.Name, dep.Version))
+		}
+	}
+	for len(layout.queue) > 0 {
+		location := layout.queue[0]
+		layout.queue = layout.queue[1:]
+		if mPkg, ok := layout.resolutions[layout.placed[location]]; ok {
+			for _, dep := range mPkg.Dependencies {
+				layout.place(location, requiredAs(dep.Name, dep.Alias), packageID(dep.Name, dep.Version))
+			}
+		}
+	}
+
+	flags := dependencyFlags(importers, manifests, layout.resolutions)
+	npmLock := npmLockfile{Name: rootManifest.Name, Version: rootManifest.Version, LockfileVersion: 3, Requires: true, Packages: make(map[string]*npmEntry)}
+	for _, importer := range importers {
+		if manifest, ok := manifests[importer.Path]; ok {
+			npmLock.Packages[importerLocation(importer.Path)] = importerEntry(manifest)
+		}
+	}
+	// npm links every workspace package into the root, whether something depends on it or not
+	for _, pkg := range workspacePackages {
+		npmLock.Packages["node_modules/"+pkg.Manifest.Name] = &npmEntry{Resolved: pkg.Dir, Link: true}
+	}
+	for location, id := range layout.placed {
+		mPkg, ok := layout.resolutions[id]
+		if !ok {
+			return nil, fmt.Errorf("%s is a dependency but isn't in the lockfile", id)
+		}
+		entry := packageEntry(mPkg)
+		if entry.Link {
+			npmLock.Packages[location] = entry
+			continue
+		}
+		if mPkg.Name != location[strings.LastIndex(location, "node_modules/")+len("node_modules/"):] {
+			entry.Name = mPkg.Name
+		}
+		flag := flags[id]
+		entry.Dev = flag == "dev"
+		entry.Optional = flag == "optional"
+		entry.DevOptional = flag == "devOptional"
+		entry.Peer = flag == "peer"
+		npmLock.Packages[location] = entry
+	}
+
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(npmLock); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// place puts a dependency of the package at from as close to the root as it can go, which is below any
+// other version of it on the way up, and below any package that already resolved another version through there
+func (l *npmLayout) place(from, name, id string) {
+	chain := ancestors(from)
+	candidates := chain
+	for i, dir := range chain {
+		if existing, ok := l.placed[childLocation(dir, name)]; ok {
+			if existing == id {
+				l.edges = append(l.edges, npmEdge{from: from, name: name, to: childLocation(dir, name)})
+				return
+			}
+			candidates = chain[:i]
+			break
+		}
+	}
+
+	target := from
+	for i := len(candidates) - 1; i >= 0; i-- {
+		if !l.shadows(candidates[i], name) {
+			target = candidates[i]
+			break
+		}
+	}
+	location := childLocation(target, name)
+	l.placed[location] = id
+	l.edges = append(l.edges, npmEdge{from: from, name: name, to: location})
+	l.queue = append(l.queue, location)
+}
+
+// shadows reports whether a package named name in dir would hide the one a package under dir already uses
+func (l *npmLayout) shadows(dir, name string) bool {
+	for _, edge := range l.edges {
+		if edge.name == name && isInside(edge.from, dir) && !isInside(edge.to, dir) {
+			return true
+		}
+	}
+	return false
+}
+
+func isInside(location, dir string) bool {
+	return dir == "" || location == dir || strings.HasPrefix(location, dir+"/")
+}
+
+// ancestors lists the directories node looks in for the dependencies of the package at location, nearest first
+func ancestors(location string) []string {
+	chain := []string{location}
+	for location != "" {
+		location = strings.TrimSuffix(location[:max(strings.LastIndex(location, "node_modules/"), 0)], "/")
+		chain = append(chain, location)
+	}
+	return chain
+}
+
+func childLocation(dir, name string) string {
+	if dir == "" {
+		return "node_modules/" + name
+	}
+	return dir + "/node_modules/" + name
+}
+
+func importerLocation(dir string) string {
+	if dir == "." {
+		return ""
+	}
+	return dir
+}
+
+func packageID(name, version string) string {
+	return name + "@" + version
+}
+
+func requiredAs(name, alias string) string {
+	if alias != "" {
+		return alias
+	}
+	return name
+}
+
+func importerEntry(manifest *manifest) *npmEntry {
+	return &npmEntry{
+		Name:                 manifest.Name,
+		Version:              manifest.Version,
+		License:              string(manifest.License),
+		Workspaces:           manifest.Workspaces,
+		Dependencies:         manifest.Dependencies,
+		DevDependencies:      manifest.DevDependencies,
+		PeerDependencies:     manifest.PeerDependencies,
+		OptionalDependencies: manifest.optionalDependencies(),
+		Bin:                  packagejson.GetBinaries(&manifest.PackageJSON, ""),
+		Engines:              manifest.engines(),
+	}
+}
+
+// packageEntry describes a locked package. The ranges it asks its dependencies with, its license and
+// binaries come from its package.json in the store, falling back to the exact versions it was locked with
+func packageEntry(mPkg *types.MPackage) *npmEntry {
+	if localPath, ok := spec.ParseLink(mPkg.Version); ok {
+		return &npmEntry{Resolved: path.Clean(localPath), Link: true}
+	}
+
+	entry := &npmEntry{Resolved: mPkg.Dist.Tarball, Integrity: mPkg.Dist.Integrity}
+	if strings.Contains(mPkg.Version, ":") {
+		// git, local and tarball packages are locked by where they came from, npm records that as resolved
+		entry.Resolved = mPkg.Version
+	} else {
+		entry.Version = mPkg.Version
+	}
+
+	if storeDir, err := utils.GetPackageStoreDir(mPkg.Name, mPkg.Version); err == nil {
+		if manifest, err := readManifest(storeDir); err == nil {
+			if entry.Version == "" {
+				entry.Version = manifest.Version
+			}
+			entry.License = string(manifest.License)
+			entry.Dependencies = manifest.Dependencies
+			entry.OptionalDependencies = manifest.optionalDependencies()
+			entry.PeerDependencies = manifest.PeerDependencies
+			entry.Bin = packagejson.GetBinaries(&manifest.PackageJSON, storeDir)
+			entry.Engines = manifest.engines()
+			return entry
+		}
+	}
+	if len(mPkg.Dependencies) > 0 {
+		entry.Dependencies = make(types.Dependencies, len(mPkg.Dependencies))
+		for _, dep := range mPkg.Dependencies {
+			if dep.Alias != "" {
+				entry.Dependencies[dep.Alias] = fmt.Sprintf("npm:%s@%s", dep.Name, dep.Version)
+			} else {
+				entry.Dependencies[dep.Name] = dep.Version
+			}
+		}
+	}
+	return entry
+}
+
+// manifest is a package.json, typed for the fields yap uses and as a document for the ones npm also records
+type manifest struct {
+	types.PackageJSON
+	document *packagejson.Document
+}
+
+func readManifest(dir string) (*manifest, error) {
+	document, err := packagejson.ReadDocument(dir)
+	if err != nil {
+		return nil, err
+	}
+	pkgJSON, err := document.Manifest()
+	if err != nil {
+		return nil, err
+	}
+	return &manifest{PackageJSON: pkgJSON, document: document}, nil
+}
+
+func (m *manifest) optionalDependencies() types.Dependencies {
+	var deps types.Dependencies
+	_ = m.document.Root().Get("optionalDependencies", &deps)
+	return deps
+}
+
+func (m *manifest) engines() json.RawMessage {
+	var engines json.RawMessage
+	_ = m.document.Root().Get("engines", &engines)
+	return engines
+}
+
+// dependencyFlags works out how every package is reached from the importers, the way npm flags them:
+// dev when only dev dependencies need it, optional when only optional ones do, devOptional when it takes
+// both, peer when only peer dependencies do, and nothing when a regular dependency needs it
+func dependencyFlags(importers []types.Importer, manifests map[string]*manifest, resolutions map[string]*types.MPackage) map[string]string {
+	reached := map[string]map[string]bool{"prod": {}, "dev": {}, "optional": {}, "peer": {}}
+	for _, importer := range importers {
+		manifest, ok := manifests[importer.Path]
+		if !ok {
+			continue
+		}
+		optional := manifest.optionalDependencies()
+		for _, dep := range importer.Dependencies {
+			name := requiredAs(dep.Name, dep.Alias)
+			kind := "prod"
+			switch {
+			case manifest.Dependencies[name] != "":
+			case manifest.DevDependencies[name] != "":
+				kind = "dev"
+			case optional[name] != "":
+				kind = "optional"
+			case manifest.PeerDependencies[name] != "":
+				kind = "peer"
+			}
+			reach(resolutions, packageID(dep.Name, dep.Version), reached[kind])
+		}
+	}
+
+	flags := make(map[string]string)
+	for id := range resolutions {
+		switch {
+		case reached["prod"][id]:
+		case reached["dev"][id] && reached["optional"][id]:
+			flags[id] = "devOptional"
+		case reached["dev"][id]:
+			flags[id] = "dev"
+		case reached["optional"][id]:
+			flags[id] = "optional"
+		case reached["peer"][id]:
+			flags[id] = "peer"
+		}
+	}
+	return flags
+}
+
+func reach(resolutions map[string]*types.MPackage, id string, reached map[string]bool) {
+	if reached[id] {
+		return
+	}
+	reached[id] = true
+	mPkg, ok := resolutions[id]
+	if !ok {
+		return
+	}
+	for _, dep := range mPkg.Dependencies {
+		reach(resolutions, packageID(dep.Name, dep.Version), reached)
+	}
+}
@@ -0,0 +1,117 @@
+package lockexport
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/Eyepan/yap/src/types"
+)
+
+func dependency(name, version string) *types.MPackage {
+	return &types.MPackage{Name: name, Version: version}
+}
+
+func TestNpmHoistsTheLayout(t *testing.T) {
+	// nothing is in the store, so the entries fall back to what the lockfile has
+	t.Setenv("HOME", t.TempDir())
+	rootDir := t.TempDir()
+	manifest := `{
+  "name": "root",
+  "version": "1.0.0",
+  "dependencies": {"a": "1.0.0", "b": "1.0.0", "p": "2.0.0", "r": "2.0.0", "alias-c": "npm:c@1.0.0"},
+  "devDependencies": {"d": "1.0.0"},
+  "optionalDependencies": {"e": "1.0.0"}
+}`
+	if err := os.WriteFile(filepath.Join(rootDir, "package.json"), []byte(manifest), 0644); err != nil {
+		t.Fatalf("failed to write package.json: %v", err)
+	}
+	lockfile := &types.Lockfile{
+		CoreDependencies: []types.Package{
+			{Name: "a", Version: "1.0.0"},
+			{Name: "c", Version: "1.0.0", Alias: "alias-c"},
+			{Name: "b", Version: "1.0.0"},
+			{Name: "d", Version: "1.0.0"},
+			{Name: "e", Version: "1.0.0"},
+			{Name: "p", Version: "2.0.0"},
+			{Name: "r", Version: "2.0.0"},
+		},
+		Resolutions: []types.MPackage{
+			{Name: "a", Version: "1.0.0"},
+			{Name: "a", Version: "2.0.0"},
+			{Name: "b", Version: "1.0.0", Dependencies: []*types.MPackage{dependency("p", "1.0.0"), dependency("r", "1.0.0")}},
+			{Name: "c", Version: "1.0.0"},
+			{Name: "d", Version: "1.0.0", Dependencies: []*types.MPackage{dependency("e", "1.0.0")}},
+			{Name: "e", Version: "1.0.0"},
+			{Name: "p", Version: "1.0.0", Dependencies: []*types.MPackage{dependency("a", "1.0.0")}},
+			{Name: "p", Version: "2.0.0"},
+			{Name: "r", Version: "1.0.0", Dependencies: []*types.MPackage{dependency("a", "2.0.0")}},
+			{Name: "r", Version: "2.0.0"},
+		},
+	}
+
+	data, err := Npm(rootDir, lockfile)
+	if err != nil {
+		t.Fatalf("Npm failed: %v", err)
+	}
+	var npmLock npmLockfile
+	if err := json.Unmarshal(data, &npmLock); err != nil {
+		t.Fatalf("failed to decode package-lock.json: %v", err)
+	}
+
+	// the versions the root already has go below b, and a@2 goes below r because hoisting it into b would
+	// hide a@1 from b's p
+	want := map[string]string{
+		"":                              "root@1.0.0",
+		"node_modules/a":                "a@1.0.0",
+		"node_modules/alias-c":          "c@1.0.0",
+		"node_modules/b":                "b@1.0.0",
+		"node_modules/b/node_modules/p": "p@1.0.0",
+		"node_modules/b/node_modules/r": "r@1.0.0",
+		"node_modules/b/node_modules/r/node_modules/a": "a@2.0.0",
+		"node_modules/d": "d@1.0.0",
+		"node_modules/e": "e@1.0.0",
+		"node_modules/p": "p@2.0.0",
+		"node_modules/r": "r@2.0.0",
+	}
+	var got []string
+	for location, entry := range npmLock.Packages {
+		name := entry.Name
+		if name == "" {
+			name = location[strings.LastIndex(location, "/")+1:]
+		}
+		if want[location] != name+"@"+entry.Version {
+			t.Errorf("%s is %s@%s, want %s", location, name, entry.Version, want[location])
+		}
+		got = append(got, location)
+	}
+	if len(got) != len(want) {
+		sort.Strings(got)
+		t.Errorf("got the locations %v, want %d of them", got, len(want))
+	}
+
+	// e is an optional dependency the dev dependency d also needs, what the regular dependencies need is not flagged
+	for location, flag := range map[string]string{"node_modules/d": "dev", "node_modules/e": "devOptional", "node_modules/b/node_modules/r/node_modules/a": ""} {
+		entry, ok := npmLock.Packages[location]
+		if !ok {
+			continue
+		}
+		var got string
+		switch {
+		case entry.Dev:
+			got = "dev"
+		case entry.Optional:
+			got = "optional"
+		case entry.DevOptional:
+			got = "devOptional"
+		case entry.Peer:
+			got = "peer"
+		}
+		if got != flag {
+			t.Errorf("%s is flagged %q, want %q", location, got, flag)
+		}
+	}
+}
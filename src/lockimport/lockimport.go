@@ -0,0 +1,217 @@
+package lockimport
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/Eyepan/yap/src/overrides"
+	"github.com/Eyepan/yap/src/pack"
+	"github.com/Eyepan/yap/src/packagejson"
+	"github.com/Eyepan/yap/src/publish"
+	"github.com/Eyepan/yap/src/spec"
+	"github.com/Eyepan/yap/src/types"
+	"github.com/Eyepan/yap/src/utils"
+	"github.com/Eyepan/yap/src/workspace"
+	"github.com/Masterminds/semver/v3"
+)
+
+// Sources are the lockfiles of other package managers that can be imported, in the order they're looked for
+var Sources = []string{"package-lock.json", "npm-shrinkwrap.json", "pnpm-lock.yaml", "yarn.lock"}
+
+// node is a package from another lockfile, with its dependencies already resolved to other nodes
+type node struct {
+	name    string
+	version string // pinned the way yap records it, e.g. git+<url>#<commit> for git dependencies
+	dist    types.Dist
+	deps    map[string]*node // keyed by the name it's required as, which differs from name for aliases
+}
+
+// tree maps every importer directory ("." for the root) to what its dependencies resolved to
+type tree map[string]map[string]*node
+
+// Detect returns the first lockfile of another package manager found in dir
+func Detect(dir string) (string, error) {
+	for _, source := range Sources {
+		if _, err := os.Stat(filepath.Join(dir, source)); err == nil {
+			return source, nil
+		}
+	}
+	return "", fmt.Errorf("none of %s were found in %s", strings.Join(Sources, ", "), dir)
+}
+
+// Import converts the lockfile of another package manager into a yap lockfile with the same versions,
+// tarballs and integrity hashes. The dependencies of every importer are read from package.json the way
+// install reads them, and looked up in what the other package manager resolved them to
+func Import(rootDir, source string, conf *types.YapConfig) (*types.Lockfile, error) {
+	data, err := os.ReadFile(filepath.Join(rootDir, source))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", source, err)
+	}
+	rootPkgJSON, err := packagejson.ReadPackageJSON(rootDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read package.json: %w", err)
+	}
+	importers, err := workspace.Importers(rootDir, &rootPkgJSON)
+	if err != nil {
+		return nil, err
+	}
+
+	var parsed tree
+	switch source {
+	case "package-lock.json", "npm-shrinkwrap.json":
+		parsed, err = parseNpm(data, importers, conf.Registry)
+	case "pnpm-lock.yaml":
+		parsed, err = parsePnpm(data, importers, conf.Registry)
+	case "yarn.lock":
+		parsed, err = parseYarn(data, importers, conf.Registry)
+	default:
+		return nil, fmt.Errorf("can't import %s, only %s", source, strings.Join(Sources, ", "))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", source, err)
+	}
+
+	packageOverrides, err := overrides.Parse(&rootPkgJSON)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read overrides: %w", err)
+	}
+	return build(importers, parsed, packageOverrides), nil
+}
+
+// build walks the imported tree from every importer, so the lockfile only has the packages yap would install
+func build(importers map[string]types.Dependencies, parsed tree, packageOverrides *overrides.Overrides) *types.Lockfile {
+	lockfile := &types.Lockfile{}
+	resolutions := make(map[string]*types.MPackage)
+	for dir, baseDependencies := range importers {
+		var deps []types.Package
+		for name, specifier := range baseDependencies {
+			imported, ok := parsed[dir][name]
+			if localPath, isLink := spec.ParseLink(specifier); isLink {
+				imported = &node{name: spec.ParseDependency(name, specifier).Name, version: "link:" + localPath}
+			} else if localPath, isFile := spec.ParseFile(specifier); isFile {
+				// local packages are packed again on every install, so only their path has to match
+				imported = &node{name: name, version: "file:" + localPath}
+				if ok {
+					imported.dist, imported.deps = parsed[dir][name].dist, parsed[dir][name].deps
+				}
+			} else if !ok {
+				slog.Warn(fmt.Sprintf("%s@%s isn't in the imported lockfile, install will resolve it", name, specifier))
+				continue
+			}
+			addNode(resolutions, imported)
+			deps = append(deps, dependency(name, imported))
+		}
+		utils.SortPackages(deps)
+		if dir == "." {
+			lockfile.CoreDependencies = deps
+			continue
+		}
+		lockfile.Importers = append(lockfile.Importers, types.Importer{Path: dir, Dependencies: deps})
+	}
+	sort.Slice(lockfile.Importers, func(i, j int) bool {
+		return lockfile.Importers[i].Path < lockfile.Importers[j].Path
+	})
+
+	// the rules are recorded so install knows the lockfile was resolved with them, which packages
+	// they forced isn't something the other lockfiles keep
+	for _, rule := range packageOverrides.Rules {
+		lockfile.Overrides = append(lockfile.Overrides, types.LockedOverride{Selector: rule.Selector(), Version: rule.Version})
+	}
+
+	for _, mPkg := range resolutions {
+		lockfile.Resolutions = append(lockfile.Resolutions, *mPkg)
+	}
+	sort.Slice(lockfile.Resolutions, func(i, j int) bool {
+		if lockfile.Resolutions[i].Name != lockfile.Resolutions[j].Name {
+			return lockfile.Resolutions[i].Name < lockfile.Resolutions[j].Name
+		}
+		return lockfile.Resolutions[i].Version < lockfile.Resolutions[j].Version
+	})
+	return lockfile
+}
+
+// addNode records n and everything it depends on, once per name@version
+func addNode(resolutions map[string]*types.MPackage, n *node) {
+	id := n.name + "@" + n.version
+	if _, ok := resolutions[id]; ok {
+		return
+	}
+	mPkg := &types.MPackage{Name: n.name, Version: n.version, Dist: n.dist}
+	resolutions[id] = mPkg
+	for requiredAs, dep := range n.deps {
+		pkg := dependency(requiredAs, dep)
+		mPkg.Dependencies = append(mPkg.Dependencies, &types.MPackage{Name: pkg.Name, Version: pkg.Version, Alias: pkg.Alias})
+		addNode(resolutions, dep)
+	}
+	utils.SortDependencies(mPkg.Dependencies)
+}
+
+func dependency(requiredAs string, n *node) types.Package {
+	pkg := types.Package{Name: n.name, Version: n.version}
+	if requiredAs != n.name {
+		pkg.Alias = requiredAs
+	}
+	return pkg
+}
+
+// pinnedVersion turns what another package manager resolved into the version yap records. specifier is
+// what was asked for when the lockfile knows it, otherwise tarball urls that don't look like they came
+// from a registry are taken as tarball dependencies
+func pinnedVersion(name, specifier, version, resolved string) string {
+	if localPath, ok := spec.ParseLink(specifier); ok {
+		return "link:" + localPath
+	}
+	if localPath, ok := spec.ParseFile(specifier); ok {
+		return "file:" + localPath
+	}
+	if localPath, ok := strings.CutPrefix(resolved, "file:"); ok {
+		return "file:" + filepath.ToSlash(filepath.Clean(localPath))
+	}
+	if gitSpec, ok := spec.ParseGit(resolved); ok && gitSpec.Committish != "" {
+		return gitSpec.Resolved(gitSpec.Committish)
+	}
+	if spec.IsTarballURL(specifier) {
+		return specifier
+	}
+	if _, err := semver.NewConstraint(specifier); (specifier == "" || err != nil) && spec.IsTarballURL(resolved) && !isRegistryTarball(name, version, resolved) {
+		return withoutFragment(resolved)
+	}
+	return version
+}
+
+// isRegistryTarball reports whether url has the /<name>/-/<name>-<version>.tgz shape registries serve
+func isRegistryTarball(name, version, url string) bool {
+	return strings.HasSuffix(withoutFragment(url), "/-/"+pack.TarballFilename(name[strings.LastIndex(name, "/")+1:], version))
+}
+
+func withoutFragment(url string) string {
+	url, _, _ = strings.Cut(url, "#")
+	return url
+}
+
+// newDist builds the dist of an imported package. A registry package without a tarball url gets the
+// one of the configured registry, and the shasum comes from a sha1 integrity when there is one
+func newDist(name, version, tarball, integrity, registry string) types.Dist {
+	if tarball == "" && version != "" && !strings.Contains(version, ":") {
+		tarball = publish.TarballURL(registry, name, version)
+	}
+	dist := types.Dist{Tarball: tarball, Integrity: integrity}
+	if strings.Contains(version, ":") {
+		// git and local packages use their pinned version as the tarball, like their fetchers do
+		dist.Tarball = version
+	}
+	for _, hash := range strings.Fields(integrity) {
+		if encoded, ok := strings.CutPrefix(hash, "sha1-"); ok {
+			if sum, err := base64.StdEncoding.DecodeString(encoded); err == nil {
+				dist.Shasum = hex.EncodeToString(sum)
+			}
+		}
+	}
+	return dist
+}
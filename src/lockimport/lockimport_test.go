@@ -0,0 +1,372 @@
+package lockimport
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Eyepan/yap/src/types"
+)
+
+const (
+	registry = "https://registry.npmjs.org"
+	commit   = "0123456789abcdef0123456789abcdef01234567"
+)
+
+// manifest asks for a registry package, an alias, a git and a tarball dependency, and a dev dependency
+// with a peer
+const manifest = `{
+  "name": "root",
+  "version": "1.0.0",
+  "dependencies": {
+    "a": "^1.0.0",
+    "alias-c": "npm:c@^1.0.0",
+    "g": "git+https://github.com/user/g.git",
+    "t": "https://example.com/t.tgz"
+  },
+  "devDependencies": {"p": "^1.0.0"}
+}`
+
+// registryManifest only asks for registry packages, which is what the older pnpm fixtures lock
+const registryManifest = `{
+  "name": "root",
+  "version": "1.0.0",
+  "dependencies": {"a": "^1.0.0", "alias-c": "npm:c@^1.0.0"},
+  "devDependencies": {"p": "^1.0.0"}
+}`
+
+const npmV3 = `{
+  "name": "root",
+  "version": "1.0.0",
+  "lockfileVersion": 3,
+  "requires": true,
+  "packages": {
+    "": {"name": "root", "version": "1.0.0"},
+    "node_modules/a": {
+      "version": "1.0.0",
+      "resolved": "https://registry.npmjs.org/a/-/a-1.0.0.tgz",
+      "integrity": "sha512-a",
+      "dependencies": {"b": "^2.0.0"}
+    },
+    "node_modules/a/node_modules/b": {"version": "2.0.0", "resolved": "https://registry.npmjs.org/b/-/b-2.0.0.tgz"},
+    "node_modules/alias-c": {"name": "c", "version": "1.0.0", "resolved": "https://registry.npmjs.org/c/-/c-1.0.0.tgz"},
+    "node_modules/b": {"version": "1.0.0", "resolved": "https://registry.npmjs.org/b/-/b-1.0.0.tgz"},
+    "node_modules/g": {"version": "1.0.0", "resolved": "git+https://github.com/user/g.git#` + commit + `"},
+    "node_modules/p": {
+      "version": "1.0.0",
+      "resolved": "https://registry.npmjs.org/p/-/p-1.0.0.tgz",
+      "dev": true,
+      "peerDependencies": {"a": "^1.0.0"}
+    },
+    "node_modules/t": {"version": "1.0.0", "resolved": "https://example.com/t.tgz"}
+  }
+}`
+
+// npmV2 also has the dependencies field npm 6 reads, which is left alone
+const npmV2 = `{
+  "name": "root",
+  "version": "1.0.0",
+  "lockfileVersion": 2,
+  "requires": true,
+  "packages": {
+    "": {"name": "root", "version": "1.0.0"},
+    "node_modules/a": {
+      "version": "1.0.0",
+      "resolved": "https://registry.npmjs.org/a/-/a-1.0.0.tgz",
+      "integrity": "sha512-a",
+      "dependencies": {"b": "^2.0.0"}
+    },
+    "node_modules/alias-c": {"name": "c", "version": "1.0.0", "resolved": "https://registry.npmjs.org/c/-/c-1.0.0.tgz"},
+    "node_modules/b": {"version": "2.0.0", "resolved": "https://registry.npmjs.org/b/-/b-2.0.0.tgz"},
+    "node_modules/g": {"version": "1.0.0", "resolved": "git+https://github.com/user/g.git#` + commit + `"},
+    "node_modules/p": {"version": "1.0.0", "resolved": "https://registry.npmjs.org/p/-/p-1.0.0.tgz", "dev": true},
+    "node_modules/t": {"version": "1.0.0", "resolved": "https://example.com/t.tgz"}
+  },
+  "dependencies": {
+    "a": {"version": "1.0.0", "requires": {"b": "^2.0.0"}}
+  }
+}`
+
+const yarnV1 = `# THIS IS AN AUTOGENERATED FILE. DO NOT EDIT THIS FILE DIRECTLY.
+# yarn lockfile v1
+
+
+a@^1.0.0:
+  version "1.0.0"
+  resolved "https://registry.npmjs.org/a/-/a-1.0.0.tgz#da39a3ee5e6b4b0d3255bfef95601890afd80709"
+  integrity sha512-a
+  dependencies:
+    b "^2.0.0"
+
+"alias-c@npm:c@^1.0.0":
+  version "1.0.0"
+  resolved "https://registry.npmjs.org/c/-/c-1.0.0.tgz"
+
+b@^2.0.0, b@^2.0.0-0:
+  version "2.0.0"
+  resolved "https://registry.npmjs.org/b/-/b-2.0.0.tgz"
+
+"g@git+https://github.com/user/g.git":
+  version "1.0.0"
+  resolved "git+https://github.com/user/g.git#` + commit + `"
+
+p@^1.0.0:
+  version "1.0.0"
+  resolved "https://registry.npmjs.org/p/-/p-1.0.0.tgz"
+
+"t@https://example.com/t.tgz":
+  version "1.0.0"
+  resolved "https://example.com/t.tgz"
+`
+
+const pnpm5 = `lockfileVersion: 5.4
+
+specifiers:
+  a: ^1.0.0
+  alias-c: npm:c@^1.0.0
+  p: ^1.0.0
+
+dependencies:
+  a: 1.0.0
+  alias-c: /c/1.0.0
+
+devDependencies:
+  p: 1.0.0_a@1.0.0
+
+packages:
+
+  /a/1.0.0:
+    resolution: {integrity: sha512-a}
+    dependencies:
+      b: 2.0.0
+    dev: false
+
+  /b/2.0.0:
+    resolution: {integrity: sha512-b}
+    dev: false
+
+  /c/1.0.0:
+    resolution: {integrity: sha512-c}
+    dev: false
+
+  /p/1.0.0_a@1.0.0:
+    resolution: {integrity: sha512-p}
+    peerDependencies:
+      a: ^1.0.0
+    dependencies:
+      a: 1.0.0
+    dev: true
+`
+
+const pnpm6 = `lockfileVersion: '6.0'
+
+dependencies:
+  a:
+    specifier: ^1.0.0
+    version: 1.0.0
+  alias-c:
+    specifier: npm:c@^1.0.0
+    version: /c@1.0.0
+
+devDependencies:
+  p:
+    specifier: ^1.0.0
+    version: 1.0.0(a@1.0.0)
+
+packages:
+
+  /a@1.0.0:
+    resolution: {integrity: sha512-a}
+    dependencies:
+      b: 2.0.0
+    dev: false
+
+  /b@2.0.0:
+    resolution: {integrity: sha512-b}
+    dev: false
+
+  /c@1.0.0:
+    resolution: {integrity: sha512-c}
+    dev: false
+
+  /p@1.0.0(a@1.0.0):
+    resolution: {integrity: sha512-p}
+    peerDependencies:
+      a: ^1.0.0
+    dependencies:
+      a: 1.0.0
+    dev: true
+`
+
+const pnpm9 = `lockfileVersion: '9.0'
+
+settings:
+  autoInstallPeers: true
+  excludeLinksFromLockfile: false
+
+importers:
+
+  .:
+    dependencies:
+      a:
+        specifier: ^1.0.0
+        version: 1.0.0
+      alias-c:
+        specifier: npm:c@^1.0.0
+        version: c@1.0.0
+      g:
+        specifier: git+https://github.com/user/g.git
+        version: git+https://github.com/user/g.git#` + commit + `
+      t:
+        specifier: https://example.com/t.tgz
+        version: https://example.com/t.tgz
+    devDependencies:
+      p:
+        specifier: ^1.0.0
+        version: 1.0.0(a@1.0.0)
+
+packages:
+
+  a@1.0.0:
+    resolution: {integrity: sha512-a}
+
+  b@2.0.0:
+    resolution: {integrity: sha512-b}
+
+  c@1.0.0:
+    resolution: {integrity: sha512-c}
+
+  g@git+https://github.com/user/g.git#` + commit + `:
+    resolution: {commit: ` + commit + `, repo: https://github.com/user/g.git, type: git}
+    version: 1.0.0
+
+  p@1.0.0:
+    resolution: {integrity: sha512-p}
+    peerDependencies:
+      a: ^1.0.0
+
+  t@https://example.com/t.tgz:
+    resolution: {tarball: https://example.com/t.tgz}
+    version: 1.0.0
+
+snapshots:
+
+  a@1.0.0:
+    dependencies:
+      b: 2.0.0
+
+  b@2.0.0: {}
+
+  c@1.0.0: {}
+
+  g@git+https://github.com/user/g.git#` + commit + `: {}
+
+  p@1.0.0(a@1.0.0):
+    dependencies:
+      a: 1.0.0
+
+  t@https://example.com/t.tgz: {}
+`
+
+// describe lists the dependencies of every importer and the packages they resolve to, one per line
+func describe(lockfile *types.Lockfile) string {
+	var lines []string
+	importers := append([]types.Importer{{Path: ".", Dependencies: lockfile.CoreDependencies}}, lockfile.Importers...)
+	for _, importer := range importers {
+		for _, dep := range importer.Dependencies {
+			lines = append(lines, fmt.Sprintf("importer %s %s", importer.Path, describePackage(dep.Alias, dep.Name, dep.Version)))
+		}
+	}
+	for _, mPkg := range lockfile.Resolutions {
+		line := fmt.Sprintf("%s@%s %s", mPkg.Name, mPkg.Version, mPkg.Dist.Tarball)
+		if mPkg.Dist.Shasum != "" {
+			line += " shasum " + mPkg.Dist.Shasum
+		}
+		for _, dep := range mPkg.Dependencies {
+			line += " -> " + describePackage(dep.Alias, dep.Name, dep.Version)
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+func describePackage(alias, name, version string) string {
+	if alias != "" {
+		return alias + "=" + name + "@" + version
+	}
+	return name + "@" + version
+}
+
+func TestImport(t *testing.T) {
+	gitVersion := "git+https://github.com/user/g.git#" + commit
+	importers := []string{
+		"importer . a@1.0.0",
+		"importer . alias-c=c@1.0.0",
+		"importer . g@" + gitVersion,
+		"importer . p@1.0.0",
+		"importer . t@https://example.com/t.tgz",
+	}
+	registryImporters := []string{importers[0], importers[1], importers[3]}
+	a := "a@1.0.0 https://registry.npmjs.org/a/-/a-1.0.0.tgz -> b@2.0.0"
+	b := "b@2.0.0 https://registry.npmjs.org/b/-/b-2.0.0.tgz"
+	c := "c@1.0.0 https://registry.npmjs.org/c/-/c-1.0.0.tgz"
+	g := "g@" + gitVersion + " " + gitVersion
+	p := "p@1.0.0 https://registry.npmjs.org/p/-/p-1.0.0.tgz"
+	// pnpm records the peers a package was resolved with as its dependencies
+	pWithPeer := p + " -> a@1.0.0"
+	tarball := "t@https://example.com/t.tgz https://example.com/t.tgz"
+
+	for _, test := range []struct {
+		name     string
+		source   string
+		lockfile string
+		manifest string
+		want     []string
+	}{
+		// b@1.0.0 is hoisted but nothing the project needs uses it
+		{"npm v3", "package-lock.json", npmV3, manifest, append(importers, a, b, c, g, p, tarball)},
+		{"npm v2", "npm-shrinkwrap.json", npmV2, manifest, append(importers, a, b, c, g, p, tarball)},
+		// yarn appends the sha1 of registry tarballs to their url
+		{"yarn v1", "yarn.lock", yarnV1, manifest, append(importers, strings.Replace(a, " ->", " shasum da39a3ee5e6b4b0d3255bfef95601890afd80709 ->", 1), b, c, g, p, tarball)},
+		{"pnpm 5", "pnpm-lock.yaml", pnpm5, registryManifest, append(registryImporters, a, b, c, pWithPeer)},
+		{"pnpm 6", "pnpm-lock.yaml", pnpm6, registryManifest, append(registryImporters, a, b, c, pWithPeer)},
+		{"pnpm 9", "pnpm-lock.yaml", pnpm9, manifest, append(importers, a, b, c, g, pWithPeer, tarball)},
+	} {
+		rootDir := t.TempDir()
+		if err := os.WriteFile(filepath.Join(rootDir, "package.json"), []byte(test.manifest), 0644); err != nil {
+			t.Fatalf("failed to write package.json: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(rootDir, test.source), []byte(test.lockfile), 0644); err != nil {
+			t.Fatalf("failed to write %s: %v", test.source, err)
+		}
+		lockfile, err := Import(rootDir, test.source, &types.YapConfig{Registry: registry})
+		if err != nil {
+			t.Errorf("%s: Import failed: %v", test.name, err)
+			continue
+		}
+		if got, want := describe(lockfile), strings.Join(test.want, "\n"); got != want {
+			t.Errorf("%s: imported\n%s\nwant\n%s", test.name, got, want)
+		}
+	}
+}
+
+func TestImportRejectsOldLockfiles(t *testing.T) {
+	for source, lockfile := range map[string]string{
+		"package-lock.json": `{"lockfileVersion": 1, "dependencies": {}}`,
+		"pnpm-lock.yaml":    "lockfileVersion: 4.0\n",
+		"yarn.lock":         "__metadata:\n  version: 6\n",
+	} {
+		rootDir := t.TempDir()
+		if err := os.WriteFile(filepath.Join(rootDir, "package.json"), []byte(registryManifest), 0644); err != nil {
+			t.Fatalf("failed to write package.json: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(rootDir, source), []byte(lockfile), 0644); err != nil {
+			t.Fatalf("failed to write %s: %v", source, err)
+		}
+		if _, err := Import(rootDir, source, &types.YapConfig{Registry: registry}); err == nil {
+			t.Errorf("imported an unsupported %s", source)
+		}
+	}
+}
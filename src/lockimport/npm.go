@@ -0,0 +1,127 @@
+package lockimport
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/Eyepan/yap/src/spec"
+	"github.com/Eyepan/yap/src/types"
+)
+
+type npmLockfile struct {
+	LockfileVersion int                   `json:"lockfileVersion"`
+	Packages        map[string]npmPackage `json:"packages"`
+}
+
+// npmPackage is an entry of the packages field, keyed by where npm put it (node_modules/a/node_modules/b)
+type npmPackage struct {
+	Name                 string            `json:"name"` // only set when it differs from the directory, e.g. for aliases
+	Version              string            `json:"version"`
+	Resolved             string            `json:"resolved"`
+	Integrity            string            `json:"integrity"`
+	Link                 bool              `json:"link"` // a symlink to the directory in resolved
+	Dependencies         map[string]string `json:"dependencies"`
+	OptionalDependencies map[string]string `json:"optionalDependencies"`
+}
+
+type npmTree struct {
+	packages map[string]npmPackage
+	registry string
+	nodes    map[string]*node // by location
+}
+
+// parseNpm reads a v2 or v3 package-lock.json. Their packages field is the node_modules layout npm
+// wrote, so dependencies are found the way node finds them, walking up from the package requiring them
+func parseNpm(data []byte, importers map[string]types.Dependencies, registry string) (tree, error) {
+	var lockfile npmLockfile
+	if err := json.Unmarshal(data, &lockfile); err != nil {
+		return nil, err
+	}
+	if lockfile.LockfileVersion < 2 || lockfile.Packages == nil {
+		return nil, fmt.Errorf("lockfileVersion %d isn't supported, run `npm install` with npm 7 or later to upgrade it", lockfile.LockfileVersion)
+	}
+
+	npm := &npmTree{packages: lockfile.Packages, registry: registry, nodes: make(map[string]*node)}
+	parsed := make(tree, len(importers))
+	for dir, deps := range importers {
+		from := dir
+		if dir == "." {
+			from = ""
+		}
+		parsed[dir] = make(map[string]*node, len(deps))
+		for name, specifier := range deps {
+			location, ok := npm.locate(from, name)
+			if !ok {
+				continue
+			}
+			n, err := npm.node(location, specifier)
+			if err != nil {
+				return nil, err
+			}
+			parsed[dir][name] = n
+		}
+	}
+	return parsed, nil
+}
+
+// locate finds the node_modules entry name resolves to from the package at location
+func (t *npmTree) locate(location, name string) (string, bool) {
+	for {
+		candidate := "node_modules/" + name
+		if location != "" {
+			candidate = location + "/" + candidate
+		}
+		if _, ok := t.packages[candidate]; ok {
+			return candidate, true
+		}
+		if location == "" {
+			return "", false
+		}
+		location = strings.TrimSuffix(location[:max(strings.LastIndex(location, "node_modules/"), 0)], "/")
+	}
+}
+
+// node builds the package at location. specifier is what the first package requiring it asked for,
+// which tells registry packages apart from tarball dependencies
+func (t *npmTree) node(location, specifier string) (*node, error) {
+	if n, ok := t.nodes[location]; ok {
+		return n, nil
+	}
+	entry := t.packages[location]
+	name := entry.Name
+	if name == "" {
+		name = location[strings.LastIndex(location, "node_modules/")+len("node_modules/"):]
+	}
+	n := &node{name: name, deps: make(map[string]*node)}
+	t.nodes[location] = n
+	if entry.Link {
+		// links point at workspace packages or local directories, relative to the root
+		n.version = "link:" + path.Clean(entry.Resolved)
+		return n, nil
+	}
+	if _, versionRange, ok := spec.ParseAlias(specifier); ok {
+		specifier = versionRange
+	}
+	n.version = pinnedVersion(name, specifier, entry.Version, entry.Resolved)
+	n.dist = newDist(name, n.version, withoutFragment(entry.Resolved), entry.Integrity, t.registry)
+
+	for _, deps := range []map[string]string{entry.Dependencies, entry.OptionalDependencies} {
+		for depName, depSpecifier := range deps {
+			depLocation, ok := t.locate(location, depName)
+			if !ok {
+				if _, optional := entry.OptionalDependencies[depName]; optional {
+					continue
+				}
+				return nil, fmt.Errorf("%s depends on %s, which isn't in the lockfile", location, depName)
+			}
+			dep, err := t.node(depLocation, depSpecifier)
+			if err != nil {
+				return nil, err
+			}
+			n.deps[depName] = dep
+		}
+	}
+	return n, nil
+}
@@ -0,0 +1,204 @@
+package lockimport
+
+import (
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/Eyepan/yap/src/spec"
+	"github.com/Eyepan/yap/src/types"
+)
+
+type pnpmTree struct {
+	majorVersion int
+	packages     map[string]any // resolutions, by package key
+	snapshots    map[string]any // dependencies, by package key with peers. pnpm 9 keeps them apart from packages
+	registry     string
+	nodes        map[string]*node
+}
+
+// parsePnpm reads a pnpm-lock.yaml written by pnpm 7 and older (lockfileVersion 5.x), pnpm 8 (6.x) or pnpm
+// 9 and later (9.x). They differ in how packages are keyed: /name/1.0.0, /name@1.0.0 and name@1.0.0
+func parsePnpm(data []byte, importers map[string]types.Dependencies, registry string) (tree, error) {
+	document, err := parseYAML(data)
+	if err != nil {
+		return nil, err
+	}
+	root, _ := document.(map[string]any)
+	lockfileVersion := yamlString(root["lockfileVersion"])
+	majorVersion, err := strconv.Atoi(strings.SplitN(lockfileVersion, ".", 2)[0])
+	if err != nil || majorVersion < 5 {
+		return nil, fmt.Errorf("lockfileVersion %q isn't supported, run `pnpm install` with pnpm 7 or later to upgrade it", lockfileVersion)
+	}
+
+	pnpm := &pnpmTree{
+		majorVersion: majorVersion,
+		packages:     yamlMap(root["packages"]),
+		snapshots:    yamlMap(root["snapshots"]),
+		registry:     registry,
+		nodes:        make(map[string]*node),
+	}
+	if pnpm.majorVersion < 9 {
+		pnpm.snapshots = pnpm.packages
+	}
+
+	// a project without workspaces keeps the dependencies of its root at the top level
+	lockedImporters := yamlMap(root["importers"])
+	if len(lockedImporters) == 0 {
+		lockedImporters = map[string]any{".": root}
+	}
+
+	parsed := make(tree, len(importers))
+	for dir, deps := range importers {
+		lockedImporter := yamlMap(lockedImporters[dir])
+		parsed[dir] = make(map[string]*node, len(deps))
+		for name := range deps {
+			ref := ""
+			for _, field := range []string{"dependencies", "devDependencies", "optionalDependencies"} {
+				if locked, ok := yamlMap(lockedImporter[field])[name]; ok {
+					ref = importerRef(locked)
+					break
+				}
+			}
+			if ref == "" {
+				continue
+			}
+			n, err := pnpm.resolve(dir, name, ref)
+			if err != nil {
+				return nil, err
+			}
+			parsed[dir][name] = n
+		}
+	}
+	return parsed, nil
+}
+
+// importerRef returns the version an importer locked, a plain string before lockfileVersion 6
+// and {specifier, version} after
+func importerRef(locked any) string {
+	if fields, ok := locked.(map[string]any); ok {
+		return yamlString(fields["version"])
+	}
+	return yamlString(locked)
+}
+
+// resolve finds the package a dependency reference points at. References are versions, optionally with
+// peers, which are joined with the name to get the package key, or a whole key for aliases
+func (t *pnpmTree) resolve(dir, name, ref string) (*node, error) {
+	if localPath, ok := spec.ParseLink(ref); ok {
+		return &node{name: name, version: "link:" + path.Join(dir, localPath)}, nil
+	}
+
+	var candidates []string
+	switch {
+	case t.majorVersion >= 9:
+		candidates = []string{name + "@" + ref, ref}
+	case t.majorVersion >= 6:
+		candidates = []string{"/" + name + "@" + ref, ref, "/" + ref}
+	default:
+		candidates = []string{"/" + name + "/" + ref, ref, "/" + ref}
+	}
+	for _, key := range candidates {
+		if _, ok := t.snapshots[key]; ok {
+			return t.node(key)
+		}
+	}
+	return nil, fmt.Errorf("%s@%s isn't in the packages of the lockfile", name, ref)
+}
+
+func (t *pnpmTree) node(key string) (*node, error) {
+	if n, ok := t.nodes[key]; ok {
+		return n, nil
+	}
+	snapshot := yamlMap(t.snapshots[key])
+	// registry packages are keyed by their version, everything else by where it came from
+	name, version := t.splitKey(key)
+	specifier := version
+	entry := snapshot
+	if t.majorVersion >= 9 {
+		entry = yamlMap(t.packages[t.packageKey(key)])
+	}
+	if entryName := yamlString(entry["name"]); entryName != "" {
+		name = entryName
+	}
+	if entryVersion := yamlString(entry["version"]); entryVersion != "" {
+		version = entryVersion
+	}
+
+	n := &node{name: name, deps: make(map[string]*node)}
+	t.nodes[key] = n
+	resolution := yamlMap(entry["resolution"])
+	tarball := yamlString(resolution["tarball"])
+	switch {
+	case yamlString(resolution["commit"]) != "":
+		repo := yamlString(resolution["repo"])
+		if !strings.HasPrefix(repo, "git+") && !strings.HasPrefix(repo, "git://") {
+			repo = "git+" + repo
+		}
+		n.version = pinnedVersion(name, specifier, version, repo+"#"+yamlString(resolution["commit"]))
+	case yamlString(resolution["directory"]) != "":
+		n.version = pinnedVersion(name, specifier, version, "file:"+yamlString(resolution["directory"]))
+	case tarball != "":
+		n.version = pinnedVersion(name, specifier, version, tarball)
+	default:
+		n.version = version
+	}
+	n.dist = newDist(name, n.version, tarball, yamlString(resolution["integrity"]), t.registry)
+
+	for _, field := range []string{"dependencies", "optionalDependencies"} {
+		for depName, ref := range yamlMap(snapshot[field]) {
+			dep, err := t.resolve("", depName, yamlString(ref))
+			if err != nil {
+				if field == "optionalDependencies" {
+					continue
+				}
+				return nil, fmt.Errorf("%s depends on %s: %w", key, depName, err)
+			}
+			n.deps[depName] = dep
+		}
+	}
+	return n, nil
+}
+
+// splitKey returns the name and version in a package key, without the peers pnpm resolved it with
+func (t *pnpmTree) splitKey(key string) (string, string) {
+	key = t.packageKey(strings.TrimPrefix(key, "/"))
+	if t.majorVersion < 6 {
+		at := strings.LastIndex(key, "/")
+		return key[:max(at, 0)], key[at+1:]
+	}
+	// only scopes start with an @, the version after it can have more of them in urls
+	at := strings.Index(key[min(1, len(key)):], "@") + 1
+	if at <= 0 {
+		return key, ""
+	}
+	return key[:at], key[at+1:]
+}
+
+// packageKey drops the peers from a key, which are in parentheses since lockfileVersion 6 and after an
+// underscore before that
+func (t *pnpmTree) packageKey(key string) string {
+	if t.majorVersion < 6 {
+		if at := strings.LastIndex(key, "/"); at >= 0 {
+			if underscore := strings.Index(key[at:], "_"); underscore >= 0 {
+				return key[:at+underscore]
+			}
+		}
+		return key
+	}
+	if parenthesis := strings.Index(key, "("); parenthesis > 0 {
+		return key[:parenthesis]
+	}
+	return key
+}
+
+func yamlMap(value any) map[string]any {
+	mapping, _ := value.(map[string]any)
+	return mapping
+}
+
+func yamlString(value any) string {
+	str, _ := value.(string)
+	return str
+}
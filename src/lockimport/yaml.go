@@ -0,0 +1,370 @@
+package lockimport
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// parseYAML reads the subset of YAML that pnpm writes its lockfile in: block mappings and sequences,
+// flow mappings and sequences, plain and quoted scalars and comments. Every scalar stays a string,
+// mappings become map[string]any and sequences []any
+func parseYAML(data []byte) (any, error) {
+	lines := yamlLines(string(data))
+	if len(lines) == 0 {
+		return map[string]any{}, nil
+	}
+	value, next, err := parseYAMLBlock(lines, 0, lines[0].indent)
+	if err != nil {
+		return nil, err
+	}
+	if next < len(lines) {
+		return nil, fmt.Errorf("line %d: unexpected indentation", lines[next].number)
+	}
+	return value, nil
+}
+
+type yamlLine struct {
+	indent int
+	text   string
+	number int
+}
+
+// yamlLines drops blank lines, comments and document markers, which don't change the structure
+func yamlLines(data string) []yamlLine {
+	var lines []yamlLine
+	for i, line := range strings.Split(data, "\n") {
+		line = stripYAMLComment(strings.TrimRight(line, " \t\r"))
+		text := strings.TrimLeft(line, " ")
+		if text == "" || text == "---" || text == "..." {
+			continue
+		}
+		lines = append(lines, yamlLine{indent: len(line) - len(text), text: text, number: i + 1})
+	}
+	return lines
+}
+
+// stripYAMLComment removes a # comment, which has to follow whitespace and can't be inside quotes
+func stripYAMLComment(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+			return strings.TrimRight(line[:i], " \t")
+		}
+	}
+	return line
+}
+
+func isYAMLSequenceItem(text string) bool {
+	return text == "-" || strings.HasPrefix(text, "- ")
+}
+
+func parseYAMLBlock(lines []yamlLine, i, indent int) (any, int, error) {
+	if isYAMLSequenceItem(lines[i].text) {
+		return parseYAMLSequence(lines, i, indent)
+	}
+	return parseYAMLMapping(lines, i, indent)
+}
+
+func parseYAMLSequence(lines []yamlLine, i, indent int) (any, int, error) {
+	items := []any{}
+	for i < len(lines) && lines[i].indent == indent && isYAMLSequenceItem(lines[i].text) {
+		line := lines[i]
+		rest := strings.TrimLeft(strings.TrimPrefix(line.text, "-"), " ")
+		var item any
+		var err error
+		switch {
+		case rest == "":
+			if i+1 < len(lines) && lines[i+1].indent > indent {
+				item, i, err = parseYAMLBlock(lines, i+1, lines[i+1].indent)
+			} else {
+				item, i = "", i+1
+			}
+		case rest[0] != '{' && rest[0] != '[' && isYAMLPair(rest):
+			// `- key: value` starts a mapping indented as far as the key
+			childIndent := indent + len(line.text) - len(rest)
+			lines[i] = yamlLine{indent: childIndent, text: rest, number: line.number}
+			item, i, err = parseYAMLMapping(lines, i, childIndent)
+		default:
+			item, i, err = parseYAMLInlineValue(lines, i, rest)
+		}
+		if err != nil {
+			return nil, i, err
+		}
+		items = append(items, item)
+	}
+	if i < len(lines) && lines[i].indent > indent {
+		return nil, i, fmt.Errorf("line %d: unexpected indentation", lines[i].number)
+	}
+	return items, i, nil
+}
+
+func parseYAMLMapping(lines []yamlLine, i, indent int) (any, int, error) {
+	mapping := map[string]any{}
+	for i < len(lines) && lines[i].indent == indent {
+		line := lines[i]
+		if isYAMLSequenceItem(line.text) {
+			return nil, i, fmt.Errorf("line %d: expected a key, found a sequence item", line.number)
+		}
+		key, value, ok := splitYAMLPair(line.text)
+		if !ok {
+			return nil, i, fmt.Errorf("line %d: expected `key: value`", line.number)
+		}
+		if strings.HasPrefix(value, "|") || strings.HasPrefix(value, ">") {
+			return nil, i, fmt.Errorf("line %d: block scalars aren't supported", line.number)
+		}
+
+		var item any
+		var err error
+		switch {
+		case value != "":
+			item, i, err = parseYAMLInlineValue(lines, i, value)
+		case i+1 < len(lines) && lines[i+1].indent > indent:
+			item, i, err = parseYAMLBlock(lines, i+1, lines[i+1].indent)
+		case i+1 < len(lines) && lines[i+1].indent == indent && isYAMLSequenceItem(lines[i+1].text):
+			// sequences are allowed at the same indentation as their key
+			item, i, err = parseYAMLSequence(lines, i+1, indent)
+		default:
+			item, i = "", i+1
+		}
+		if err != nil {
+			return nil, i, err
+		}
+		mapping[key] = item
+	}
+	if i < len(lines) && lines[i].indent > indent {
+		return nil, i, fmt.Errorf("line %d: unexpected indentation", lines[i].number)
+	}
+	return mapping, i, nil
+}
+
+// parseYAMLInlineValue parses the value after a key or a dash. Flow collections can continue on
+// the following, more indented lines
+func parseYAMLInlineValue(lines []yamlLine, i int, value string) (any, int, error) {
+	indent, number := lines[i].indent, lines[i].number
+	i++
+	if value[0] == '{' || value[0] == '[' {
+		for !isFlowClosed(value) && i < len(lines) && lines[i].indent > indent {
+			value += " " + lines[i].text
+			i++
+		}
+	}
+	parser := &flowParser{text: value}
+	item, err := parser.value(false)
+	if err == nil {
+		parser.skipSpaces()
+		if parser.pos < len(parser.text) {
+			err = fmt.Errorf("unexpected %q", parser.text[parser.pos:])
+		}
+	}
+	if err != nil {
+		return nil, i, fmt.Errorf("line %d: %w", number, err)
+	}
+	return item, i, nil
+}
+
+func isYAMLPair(text string) bool {
+	_, _, ok := splitYAMLPair(text)
+	return ok
+}
+
+// splitYAMLPair splits `key: value`, where the key may be quoted and the value may be empty
+func splitYAMLPair(text string) (string, string, bool) {
+	if text[0] == '"' || text[0] == '\'' {
+		parser := &flowParser{text: text}
+		key, err := parser.quoted()
+		if err != nil || parser.pos >= len(parser.text) || parser.text[parser.pos] != ':' {
+			return "", "", false
+		}
+		rest := text[parser.pos+1:]
+		if rest != "" && rest[0] != ' ' {
+			return "", "", false
+		}
+		return key, strings.TrimSpace(rest), true
+	}
+	if key, ok := strings.CutSuffix(text, ":"); ok && !strings.Contains(key, ": ") {
+		return key, "", true
+	}
+	key, value, ok := strings.Cut(text, ": ")
+	return key, strings.TrimSpace(value), ok
+}
+
+// isFlowClosed reports whether every bracket opened outside of quotes was closed
+func isFlowClosed(text string) bool {
+	depth := 0
+	var quote byte
+	for i := 0; i < len(text); i++ {
+		switch c := text[i]; {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '{' || c == '[':
+			depth++
+		case c == '}' || c == ']':
+			depth--
+		}
+	}
+	return depth <= 0
+}
+
+// flowParser reads a single value, which is either a scalar or a flow collection like {a: b, c: [d]}
+type flowParser struct {
+	text string
+	pos  int
+}
+
+func (p *flowParser) skipSpaces() {
+	for p.pos < len(p.text) && p.text[p.pos] == ' ' {
+		p.pos++
+	}
+}
+
+func (p *flowParser) value(inFlow bool) (any, error) {
+	p.skipSpaces()
+	if p.pos >= len(p.text) {
+		return "", nil
+	}
+	switch p.text[p.pos] {
+	case '{':
+		return p.mapping()
+	case '[':
+		return p.sequence()
+	case '"', '\'':
+		return p.quoted()
+	}
+	return p.plain(inFlow, false), nil
+}
+
+func (p *flowParser) mapping() (any, error) {
+	p.pos++
+	mapping := map[string]any{}
+	for {
+		p.skipSpaces()
+		if p.pos >= len(p.text) {
+			return nil, fmt.Errorf("unterminated flow mapping")
+		}
+		if p.text[p.pos] == '}' {
+			p.pos++
+			return mapping, nil
+		}
+
+		var key string
+		var err error
+		if c := p.text[p.pos]; c == '"' || c == '\'' {
+			key, err = p.quoted()
+		} else {
+			key = p.plain(true, true)
+		}
+		if err != nil {
+			return nil, err
+		}
+		p.skipSpaces()
+		var value any = ""
+		if p.pos < len(p.text) && p.text[p.pos] == ':' {
+			p.pos++
+			if value, err = p.value(true); err != nil {
+				return nil, err
+			}
+		}
+		mapping[key] = value
+
+		p.skipSpaces()
+		if p.pos < len(p.text) && p.text[p.pos] == ',' {
+			p.pos++
+		} else if p.pos >= len(p.text) || p.text[p.pos] != '}' {
+			return nil, fmt.Errorf("expected , or } in flow mapping")
+		}
+	}
+}
+
+func (p *flowParser) sequence() (any, error) {
+	p.pos++
+	items := []any{}
+	for {
+		p.skipSpaces()
+		if p.pos >= len(p.text) {
+			return nil, fmt.Errorf("unterminated flow sequence")
+		}
+		if p.text[p.pos] == ']' {
+			p.pos++
+			return items, nil
+		}
+		item, err := p.value(true)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+
+		p.skipSpaces()
+		if p.pos < len(p.text) && p.text[p.pos] == ',' {
+			p.pos++
+		} else if p.pos >= len(p.text) || p.text[p.pos] != ']' {
+			return nil, fmt.Errorf("expected , or ] in flow sequence")
+		}
+	}
+}
+
+// quoted reads a single or double quoted scalar. Single quotes escape themselves by doubling,
+// double quotes use backslash escapes
+func (p *flowParser) quoted() (string, error) {
+	quote := p.text[p.pos]
+	start := p.pos
+	p.pos++
+	var value strings.Builder
+	for p.pos < len(p.text) {
+		c := p.text[p.pos]
+		switch {
+		case quote == '\'' && c == '\'':
+			if p.pos+1 < len(p.text) && p.text[p.pos+1] == '\'' {
+				value.WriteByte('\'')
+				p.pos += 2
+				continue
+			}
+			p.pos++
+			return value.String(), nil
+		case quote == '"' && c == '\\':
+			p.pos += 2
+		case quote == '"' && c == '"':
+			p.pos++
+			unquoted, err := strconv.Unquote(p.text[start:p.pos])
+			if err != nil {
+				return "", fmt.Errorf("invalid double quoted string %s", p.text[start:p.pos])
+			}
+			return unquoted, nil
+		default:
+			value.WriteByte(c)
+			p.pos++
+		}
+	}
+	return "", fmt.Errorf("unterminated quoted string")
+}
+
+// plain reads an unquoted scalar. Inside flow collections it ends at , ] or }, and a key also ends at
+// a colon followed by a space or the end of the entry
+func (p *flowParser) plain(inFlow, isKey bool) string {
+	start := p.pos
+	for ; p.pos < len(p.text); p.pos++ {
+		c := p.text[p.pos]
+		if inFlow && (c == ',' || c == ']' || c == '}') {
+			break
+		}
+		if isKey && c == ':' && (p.pos+1 == len(p.text) || strings.ContainsRune(" ,}", rune(p.text[p.pos+1]))) {
+			break
+		}
+	}
+	return strings.TrimSpace(p.text[start:p.pos])
+}
@@ -0,0 +1,159 @@
+package lockimport
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Eyepan/yap/src/spec"
+	"github.com/Eyepan/yap/src/types"
+)
+
+// yarnEntry is what a yarn.lock block records for every name@range sharing it
+type yarnEntry struct {
+	version              string
+	resolved             string
+	integrity            string
+	dependencies         map[string]string
+	optionalDependencies map[string]string
+}
+
+type yarnTree struct {
+	entries  map[string]*yarnEntry // by name@range
+	registry string
+	nodes    map[*yarnEntry]*node
+}
+
+// parseYarn reads a yarn 1 lockfile, where every block lists the name@range specifiers it resolved.
+// Dependencies are found by the exact range they were asked with, there's no layout to walk
+func parseYarn(data []byte, importers map[string]types.Dependencies, registry string) (tree, error) {
+	entries, err := parseYarnEntries(string(data))
+	if err != nil {
+		return nil, err
+	}
+	yarn := &yarnTree{entries: entries, registry: registry, nodes: make(map[*yarnEntry]*node)}
+	parsed := make(tree, len(importers))
+	for dir, deps := range importers {
+		parsed[dir] = make(map[string]*node, len(deps))
+		for name, specifier := range deps {
+			if _, ok := entries[name+"@"+specifier]; !ok {
+				continue
+			}
+			n, err := yarn.node(name, specifier)
+			if err != nil {
+				return nil, err
+			}
+			parsed[dir][name] = n
+		}
+	}
+	return parsed, nil
+}
+
+func (t *yarnTree) node(name, specifier string) (*node, error) {
+	entry := t.entries[name+"@"+specifier]
+	if n, ok := t.nodes[entry]; ok {
+		return n, nil
+	}
+	realName, realSpecifier := name, specifier
+	if aliasName, versionRange, ok := spec.ParseAlias(specifier); ok {
+		realName, realSpecifier = aliasName, versionRange
+	}
+
+	n := &node{name: realName, deps: make(map[string]*node)}
+	t.nodes[entry] = n
+	n.version = pinnedVersion(realName, realSpecifier, entry.version, entry.resolved)
+	n.dist = newDist(realName, n.version, withoutFragment(entry.resolved), entry.integrity, t.registry)
+	// yarn appends the sha1 of the tarball to its url
+	if _, fragment, ok := strings.Cut(entry.resolved, "#"); ok && len(fragment) == 40 && spec.IsTarballURL(entry.resolved) {
+		n.dist.Shasum = fragment
+	}
+
+	for _, deps := range []map[string]string{entry.dependencies, entry.optionalDependencies} {
+		for depName, depSpecifier := range deps {
+			if _, ok := t.entries[depName+"@"+depSpecifier]; !ok {
+				if _, optional := entry.optionalDependencies[depName]; optional {
+					continue
+				}
+				return nil, fmt.Errorf("%s@%s depends on %s@%s, which isn't in the lockfile", name, specifier, depName, depSpecifier)
+			}
+			dep, err := t.node(depName, depSpecifier)
+			if err != nil {
+				return nil, err
+			}
+			n.deps[depName] = dep
+		}
+	}
+	return n, nil
+}
+
+// parseYarnEntries reads the blocks of a yarn.lock. Keys at the top level are comma separated name@range
+// lists, fields are `key value` and the dependency fields nest one more level
+func parseYarnEntries(data string) (map[string]*yarnEntry, error) {
+	if strings.Contains(data, "\n__metadata:") || strings.HasPrefix(data, "__metadata:") {
+		return nil, fmt.Errorf("it was written by yarn 2 or later, only yarn 1 lockfiles can be imported")
+	}
+	entries := make(map[string]*yarnEntry)
+	var entry *yarnEntry
+	var field map[string]string
+	for i, line := range strings.Split(data, "\n") {
+		line = strings.TrimRight(line, " \r")
+		text := strings.TrimLeft(line, " ")
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		indent := len(line) - len(text)
+
+		switch {
+		case indent == 0:
+			keys, ok := strings.CutSuffix(text, ":")
+			if !ok {
+				return nil, fmt.Errorf("line %d: expected a list of specifiers", i+1)
+			}
+			entry, field = &yarnEntry{}, nil
+			for _, key := range strings.Split(keys, ",") {
+				entries[unquoteYarn(strings.TrimSpace(key))] = entry
+			}
+		case entry == nil:
+			return nil, fmt.Errorf("line %d: unexpected indentation", i+1)
+		case indent > 2 && field != nil:
+			key, value := splitYarnField(text)
+			field[key] = value
+		case strings.HasSuffix(text, ":"):
+			field = make(map[string]string)
+			switch unquoteYarn(strings.TrimSuffix(text, ":")) {
+			case "dependencies":
+				entry.dependencies = field
+			case "optionalDependencies":
+				entry.optionalDependencies = field
+			}
+		default:
+			field = nil
+			key, value := splitYarnField(text)
+			switch key {
+			case "version":
+				entry.version = value
+			case "resolved":
+				entry.resolved = value
+			case "integrity":
+				entry.integrity = value
+			}
+		}
+	}
+	return entries, nil
+}
+
+func splitYarnField(text string) (string, string) {
+	var key string
+	if end := strings.Index(text[1:], `"`) + 1; strings.HasPrefix(text, `"`) && end > 0 {
+		key, text = text[1:end], text[end+1:]
+	} else {
+		key, text, _ = strings.Cut(text, " ")
+	}
+	return key, unquoteYarn(strings.TrimSpace(text))
+}
+
+func unquoteYarn(value string) string {
+	if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+		return strings.ReplaceAll(value[1:len(value)-1], `\"`, `"`)
+	}
+	return value
+}
@@ -10,10 +10,14 @@ type Stats struct {
 	TotalResolveCount  int
 	DownloadCount      int
 	TotalDownloadCount int
+	Quiet              bool // counts without printing, for installs running on behalf of another command
 	statsMu            sync.Mutex
 }
 
 func (s *Stats) PrettyPrintStats() {
+	if s.Quiet {
+		return
+	}
 	fmt.Printf("\r🔍[%d/%d] 🚚[%d/%d]\t", s.ResolveCount, s.TotalResolveCount, s.DownloadCount, s.TotalDownloadCount)
 }
 
@@ -0,0 +1,50 @@
+package logger
+
+import (
+	"bytes"
+	"io"
+	"sync"
+)
+
+// PrefixWriter prefixes every line written to it, so output of commands running side by side stays readable.
+// Writers sharing an underlying writer should share the mutex too, so lines never interleave
+type PrefixWriter struct {
+	Writer  io.Writer
+	Prefix  string
+	Mu      *sync.Mutex
+	pending []byte
+}
+
+func (pw *PrefixWriter) Write(p []byte) (int, error) {
+	pw.pending = append(pw.pending, p...)
+	for {
+		newline := bytes.IndexByte(pw.pending, '\n')
+		if newline < 0 {
+			return len(p), nil
+		}
+		if err := pw.writeLine(pw.pending[:newline+1]); err != nil {
+			return 0, err
+		}
+		pw.pending = pw.pending[newline+1:]
+	}
+}
+
+// Flush writes out whatever is left after the last newline
+func (pw *PrefixWriter) Flush() error {
+	if len(pw.pending) == 0 {
+		return nil
+	}
+	line := append(pw.pending, '\n')
+	pw.pending = nil
+	return pw.writeLine(line)
+}
+
+func (pw *PrefixWriter) writeLine(line []byte) error {
+	pw.Mu.Lock()
+	defer pw.Mu.Unlock()
+	if _, err := io.WriteString(pw.Writer, pw.Prefix); err != nil {
+		return err
+	}
+	_, err := pw.Writer.Write(line)
+	return err
+}
@@ -1,39 +1,47 @@
 package metadata
 
 import (
-	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
-	"os"
-	"path/filepath"
 
+	"github.com/Eyepan/yap/src/cache"
+	"github.com/Eyepan/yap/src/filelock"
 	"github.com/Eyepan/yap/src/types"
 	"github.com/Eyepan/yap/src/utils"
 )
 
-func FetchMetadata(pkg *types.Package, conf *types.YapConfig, forceFetchAndRefresh bool) (*types.Metadata, error) {
-	cacheDir, err := utils.GetCacheDir()
-	if err != nil {
-		return nil, fmt.Errorf("failed to get cache directory: %w", err)
-	}
-	cacheFile := filepath.Join(cacheDir, utils.SanitizePackageName(pkg.Name))
-
+// FetchMetadata returns the packument of a package from the metadata cache, fetching it from the registry
+// when it isn't cached. Its versions are decoded as they're asked for. Other yap processes wait for the fetch
+// and then find it in the cache instead of fetching it again
+func FetchMetadata(pkg *types.Package, conf *types.YapConfig, forceFetchAndRefresh bool) (*utils.LazyMetadata, error) {
 	// this if should only happen if force is false
-	// Check if the cache file exists
-	if _, err := os.Stat(cacheFile); !forceFetchAndRefresh && err == nil {
-		// Cache file exists, read its contents
-		data, err := os.ReadFile(cacheFile)
-		if err != nil {
-			return nil, err
+	if !forceFetchAndRefresh {
+		if metadata, err := cached(pkg.Name); metadata != nil || err != nil {
+			return metadata, err
 		}
+	}
 
-		buf := bytes.NewReader(data)
-		return utils.ReadMetadata(buf)
+	lockFile, err := utils.GetMetadataLockFile(pkg.Name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get metadata lock file: %w", err)
+	}
+	lock, err := filelock.Acquire(lockFile)
+	if err != nil {
+		return nil, err
+	}
+	defer lock.Release()
+	// another yap process may have fetched it while this one waited for the lock
+	if !forceFetchAndRefresh {
+		if metadata, err := cached(pkg.Name); metadata != nil || err != nil {
+			return metadata, err
+		}
 	}
 
-	// Cache file does not exist, fetch metadata from the server
+	// It isn't cached, fetch metadata from the server
 	registryURL := (*conf).Registry
 	authToken := (*conf).AuthToken
 	packageURL := fmt.Sprintf("%s/%s", registryURL, pkg.Name)
@@ -68,28 +76,21 @@ func FetchMetadata(pkg *types.Package, conf *types.YapConfig, forceFetchAndRefre
 		return nil, err
 	}
 
-	// Ensure the cache directory exists
-	if err := os.MkdirAll(cacheDir, os.ModePerm); err != nil {
-		return nil, err
-	}
-
-	// Write the metadata to the cache file in binary format
-	var buf bytes.Buffer
-	if err := utils.WriteMetadata(&buf, metadata); err != nil {
-		return nil, err
-	}
-
-	file, err := os.Create(cacheFile)
-	if err != nil {
-		return nil, err
-	}
-	defer file.Close()
+	// cached under the name it's asked for, whatever the registry calls it
+	metadata.Name = pkg.Name
+	return cache.Put(metadata)
+}
 
-	if _, err := file.Write(buf.Bytes()); err != nil {
-		return nil, err
+// cached returns the packument of a package from the metadata cache, or nil when it has to be fetched
+func cached(name string) (*utils.LazyMetadata, error) {
+	metadata, err := cache.Get(name)
+	var corrupt *utils.CorruptError
+	if errors.As(err, &corrupt) {
+		// it's only a cache, so fetching it again is all it takes
+		slog.Warn(fmt.Sprintf("discarding the cached metadata of %s: %v", name, err))
+		return nil, cache.Discard(name)
 	}
-
-	return &metadata, nil
+	return metadata, err
 }
 
 func FetchVersionMetadata(pkg *types.Package, npmrc *types.YapConfig, forceFetchAndRefresh bool) (types.VersionMetadata, error) {
@@ -97,12 +98,7 @@ func FetchVersionMetadata(pkg *types.Package, npmrc *types.YapConfig, forceFetch
 	if err != nil {
 		return types.VersionMetadata{}, fmt.Errorf("failed to fetch metadata for package %s@%s: %w", pkg.Name, pkg.Version, err)
 	}
-	versionsList := make([]string, len(md.Versions))
-	i := 0
-	for k := range md.Versions {
-		versionsList[i] = k
-		i++
-	}
+	versionsList := md.Versions()
 	var resolvedVersion string
 	switch pkg.Version {
 	case "latest":
@@ -116,7 +112,7 @@ func FetchVersionMetadata(pkg *types.Package, npmrc *types.YapConfig, forceFetch
 		return types.VersionMetadata{}, fmt.Errorf("failed to resolve version for package %s@%s: %w", pkg.Name, pkg.Version, err)
 	}
 
-	return md.Versions[resolvedVersion], nil
+	return md.Version(resolvedVersion)
 }
 
 func GetListOfDependenciesFromVersionMetadata(md *types.VersionMetadata) []types.Package {
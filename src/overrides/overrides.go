@@ -0,0 +1,260 @@
+package overrides
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/Eyepan/yap/src/types"
+	"github.com/Masterminds/semver/v3"
+)
+
+// Selector matches a package by name, and optionally by version range
+type Selector struct {
+	Name  string
+	Range string
+}
+
+// Rule forces Target to Version wherever it's required from inside the Parents chain. Parents don't have
+// to be direct, `a>b` matches b anywhere under a, which is what npm's nested overrides mean
+type Rule struct {
+	Parents []Selector
+	Target  Selector
+	Version string
+}
+
+type Overrides struct {
+	Rules []Rule
+}
+
+// Parse reads npm `overrides` and yarn `resolutions` from the root package.json
+func Parse(pkgJSON *types.PackageJSON) (*Overrides, error) {
+	o := &Overrides{}
+	if err := o.parseNpm(pkgJSON.Overrides, nil, pkgJSON); err != nil {
+		return nil, fmt.Errorf("failed to parse overrides: %w", err)
+	}
+	for key, version := range pkgJSON.Resolutions {
+		rule, err := parseYarnSelector(key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse resolutions: %w", err)
+		}
+		rule.Version = version
+		o.Rules = append(o.Rules, rule)
+	}
+	sort.Slice(o.Rules, func(i, j int) bool {
+		if o.Rules[i].Selector() != o.Rules[j].Selector() {
+			return o.Rules[i].Selector() < o.Rules[j].Selector()
+		}
+		return o.Rules[i].Version < o.Rules[j].Version
+	})
+	return o, nil
+}
+
+// parseNpm walks the nested overrides object, where a string sets the version and an object
+// scopes its entries to the key (with "." overriding the key itself)
+func (o *Overrides) parseNpm(entries map[string]json.RawMessage, parents []Selector, pkgJSON *types.PackageJSON) error {
+	for key, raw := range entries {
+		selector := parseSelector(key)
+
+		var version string
+		if err := json.Unmarshal(raw, &version); err == nil {
+			resolved, err := resolveReference(version, pkgJSON)
+			if err != nil {
+				return err
+			}
+			o.Rules = append(o.Rules, Rule{Parents: parents, Target: selector, Version: resolved})
+			continue
+		}
+
+		var nested map[string]json.RawMessage
+		if err := json.Unmarshal(raw, &nested); err != nil {
+			return fmt.Errorf("override for %s must be a string or an object", key)
+		}
+		if self, ok := nested["."]; ok {
+			delete(nested, ".")
+			if err := o.parseNpm(map[string]json.RawMessage{key: self}, parents, pkgJSON); err != nil {
+				return err
+			}
+		}
+		scoped := append(append([]Selector{}, parents...), selector)
+		if err := o.parseNpm(nested, scoped, pkgJSON); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resolveReference turns npm's `$name` into the version the root package.json asks for
+func resolveReference(version string, pkgJSON *types.PackageJSON) (string, error) {
+	name, ok := strings.CutPrefix(version, "$")
+	if !ok {
+		return version, nil
+	}
+	for _, deps := range []types.Dependencies{pkgJSON.Dependencies, pkgJSON.DevDependencies, pkgJSON.PeerDependencies} {
+		if referenced, ok := deps[name]; ok {
+			return referenced, nil
+		}
+	}
+	return "", fmt.Errorf("override references $%s but it isn't a dependency of the root package", name)
+}
+
+// parseYarnSelector splits keys like `**/a`, `parent/child` or `@scope/parent/**/child@^1` into a rule
+func parseYarnSelector(key string) (Rule, error) {
+	segments := strings.Split(key, "/")
+	var selectors []Selector
+	for i := 0; i < len(segments); i++ {
+		segment := segments[i]
+		if segment == "**" || segment == "" {
+			continue
+		}
+		if strings.HasPrefix(segment, "@") {
+			if i+1 >= len(segments) {
+				return Rule{}, fmt.Errorf("invalid selector %s", key)
+			}
+			i++
+			segment += "/" + segments[i]
+		}
+		selectors = append(selectors, parseSelector(segment))
+	}
+	if len(selectors) == 0 {
+		return Rule{}, fmt.Errorf("invalid selector %s", key)
+	}
+	return Rule{Parents: selectors[:len(selectors)-1], Target: selectors[len(selectors)-1]}, nil
+}
+
+func parseSelector(key string) Selector {
+	at := strings.LastIndex(key, "@")
+	if at <= 0 {
+		return Selector{Name: key}
+	}
+	return Selector{Name: key[:at], Range: key[at+1:]}
+}
+
+func (s Selector) String() string {
+	if s.Range == "" {
+		return s.Name
+	}
+	return s.Name + "@" + s.Range
+}
+
+// Selector is the canonical form of what the rule matches, e.g. `parent>child@^1`
+func (r Rule) Selector() string {
+	parts := make([]string, 0, len(r.Parents)+1)
+	for _, parent := range r.Parents {
+		parts = append(parts, parent.String())
+	}
+	return strings.Join(append(parts, r.Target.String()), ">")
+}
+
+// Apply returns the rule that decides what pkg resolves to, given the resolved packages that led to it.
+// When several rules match the most specific one (with the most parents) wins. Selectors are matched against
+// the range pkg asks for, but dependencies the lockfile pinned ask for the exact version they got instead, so
+// a rule also matches a version recorded says it forced the last time
+func (o *Overrides) Apply(pkg *types.Package, parents []types.Package, recorded []types.LockedOverride) *Rule {
+	if o == nil {
+		return nil
+	}
+	var best *Rule
+	for i := range o.Rules {
+		rule := &o.Rules[i]
+		if rule.Target.Name != pkg.Name || !rangeMatches(rule.Target.Range, pkg.Version) && !rule.forced(pkg, recorded) {
+			continue
+		}
+		if matchedParents(rule.Parents, parents) != len(rule.Parents) {
+			continue
+		}
+		if best == nil || len(rule.Parents) > len(best.Parents) {
+			best = rule
+		}
+	}
+	return best
+}
+
+// forced reports whether the lockfile recorded the rule forcing pkg
+func (r *Rule) forced(pkg *types.Package, recorded []types.LockedOverride) bool {
+	id := pkg.Name + "@" + pkg.Version
+	for _, override := range recorded {
+		if override.Selector == r.Selector() && override.Version == r.Version && slices.Contains(override.Applied, id) {
+			return true
+		}
+	}
+	return false
+}
+
+// Context sums up how far along their parents the scoped rules got in a chain of resolved packages, which is
+// all that decides how they apply below it. Packages reached in the same context get the same overrides
+// applied to their dependencies, whichever chain got there first
+func (o *Overrides) Context(parents []types.Package) string {
+	if o == nil {
+		return ""
+	}
+	var context strings.Builder
+	for _, rule := range o.Rules {
+		if len(rule.Parents) > 0 {
+			fmt.Fprintf(&context, "%d,", matchedParents(rule.Parents, parents))
+		}
+	}
+	return context.String()
+}
+
+// Equal reports whether the lockfile was written with the same set of rules
+func (o *Overrides) Equal(locked []types.LockedOverride) bool {
+	var rules []Rule
+	if o != nil {
+		rules = o.Rules
+	}
+	if len(rules) != len(locked) {
+		return false
+	}
+	for i, rule := range rules {
+		if rule.Selector() != locked[i].Selector || rule.Version != locked[i].Version {
+			return false
+		}
+	}
+	return true
+}
+
+// matchedParents returns how many of the parent selectors appear in order along the chain, all of them when
+// the rule applies
+func matchedParents(selectors []Selector, parents []types.Package) int {
+	next := 0
+	for _, parent := range parents {
+		if next == len(selectors) {
+			break
+		}
+		if parent.Name == selectors[next].Name && versionMatches(selectors[next].Range, parent.Version) {
+			next++
+		}
+	}
+	return next
+}
+
+func versionMatches(versionRange, version string) bool {
+	if versionRange == "" {
+		return true
+	}
+	constraint, err := semver.NewConstraint(versionRange)
+	if err != nil {
+		return false
+	}
+	parsedVersion, err := semver.NewVersion(version)
+	if err != nil {
+		return false
+	}
+	return constraint.Check(parsedVersion)
+}
+
+// rangeMatches checks a selector range against a requested range. The requested range isn't a version
+// yet, so its lower bound (1.2.0 for ^1.2.0) stands in for it
+func rangeMatches(selectorRange, requested string) bool {
+	if selectorRange == "" || selectorRange == requested {
+		return true
+	}
+	fields := strings.Fields(requested)
+	if len(fields) == 0 {
+		return false
+	}
+	return versionMatches(selectorRange, strings.TrimLeft(fields[0], "^~>=v"))
+}
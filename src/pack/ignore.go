@@ -0,0 +1,146 @@
+package pack
+
+import (
+	"bufio"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/Eyepan/yap/src/utils"
+)
+
+// ignoreRule is one line of a .npmignore/.gitignore, or one entry of the files field
+type ignoreRule struct {
+	segments []string
+	negated  bool
+	dirOnly  bool
+	anchored bool // matched from the directory of the ignore file instead of at any depth
+}
+
+// ignoreFile holds the rules of an ignore file, relative to the directory it's in
+type ignoreFile struct {
+	dir   string // slash separated, "" for the package root
+	rules []ignoreRule
+}
+
+// readIgnoreFile reads .npmignore in dir, falling back to .gitignore like npm does
+func readIgnoreFile(absDir, dir string) (*ignoreFile, error) {
+	for _, name := range []string{".npmignore", ".gitignore"} {
+		file, err := os.Open(filepath.Join(absDir, name))
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+
+		ignore := &ignoreFile{dir: dir}
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			line := strings.TrimRight(scanner.Text(), " \r")
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			ignore.rules = append(ignore.rules, parseRule(line))
+		}
+		return ignore, scanner.Err()
+	}
+	return nil, nil
+}
+
+func parseRule(pattern string) ignoreRule {
+	var rule ignoreRule
+	if rest, ok := strings.CutPrefix(pattern, "!"); ok {
+		rule.negated = true
+		pattern = rest
+	}
+	if rest, ok := strings.CutSuffix(pattern, "/"); ok {
+		rule.dirOnly = true
+		pattern = rest
+	}
+	pattern = strings.TrimPrefix(pattern, "./")
+	if strings.Contains(pattern, "/") {
+		rule.anchored = true
+		pattern = strings.TrimPrefix(pattern, "/")
+	}
+	rule.segments = strings.Split(pattern, "/")
+	return rule
+}
+
+// matches checks the rule against a path relative to the ignore file's directory
+func (r ignoreRule) matches(relativePath string, isDir bool) bool {
+	if r.dirOnly && !isDir {
+		return false
+	}
+	segments := strings.Split(relativePath, "/")
+	if r.anchored {
+		return utils.MatchSegments(r.segments, segments)
+	}
+	return utils.MatchSegments(r.segments, segments[len(segments)-1:])
+}
+
+// ignored applies the ignore files from the root down, the last rule that matches decides
+func ignored(ignoreFiles []*ignoreFile, file string, isDir bool) bool {
+	result := false
+	for _, ignore := range ignoreFiles {
+		relativePath := file
+		if ignore.dir != "" {
+			var ok bool
+			if relativePath, ok = strings.CutPrefix(file, ignore.dir+"/"); !ok {
+				continue
+			}
+		}
+		for _, rule := range ignore.rules {
+			if rule.matches(relativePath, isDir) {
+				result = !rule.negated
+			}
+		}
+	}
+	return result
+}
+
+// includedByFiles checks a path against the files field, where an entry matching a directory takes
+// everything inside it
+func includedByFiles(rules []ignoreRule, file string) bool {
+	segments := strings.Split(file, "/")
+	result := false
+	for _, rule := range rules {
+		for i := len(segments); i > 0; i-- {
+			if utils.MatchSegments(rule.segments, segments[:i]) {
+				result = !rule.negated
+				break
+			}
+		}
+	}
+	return result
+}
+
+// couldIncludeByFiles reports whether anything under dir can still be matched by the files field
+func couldIncludeByFiles(rules []ignoreRule, dir string) bool {
+	segments := strings.Split(dir, "/")
+	for _, rule := range rules {
+		if rule.negated {
+			continue
+		}
+		if matchPrefix(rule.segments, segments) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchPrefix reports whether the segments of a directory can be the start of a path matched by pattern
+func matchPrefix(pattern, segments []string) bool {
+	if len(segments) == 0 || len(pattern) == 0 {
+		// either the directory is on the way to a match, or the pattern matched one of its parents
+		return true
+	}
+	if pattern[0] == "**" {
+		return true
+	}
+	if ok, err := path.Match(pattern[0], segments[0]); err != nil || !ok {
+		return false
+	}
+	return matchPrefix(pattern[1:], segments[1:])
+}
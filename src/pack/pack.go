@@ -0,0 +1,230 @@
+package pack
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/Eyepan/yap/src/downloader"
+	"github.com/Eyepan/yap/src/packagejson"
+	"github.com/Eyepan/yap/src/utils"
+)
+
+// directories that never end up in a package
+var ignoredDirs = map[string]bool{
+	".git":         true,
+	".svn":         true,
+	".hg":          true,
+	"CVS":          true,
+	"node_modules": true,
+}
+
+// files that never end up in a package either, wherever they are
+var ignoredFiles = map[string]bool{
+	"yap.lockb":     true,
+	"yap.lock":      true,
+	".npmrc":        true,
+	".npmignore":    true,
+	".gitignore":    true,
+	".DS_Store":     true,
+	".lock-wscript": true,
+	"npm-debug.log": true,
+	"config.gypi":   true,
+}
+
+// lockfiles of any package manager are left out, but only at the root
+var ignoredRootFiles = map[string]bool{
+	"package-lock.json": true,
+	"yarn.lock":         true,
+	"pnpm-lock.yaml":    true,
+}
+
+// files at the root that are always in the package, whatever files and .npmignore say
+var alwaysIncludedPrefixes = []string{"readme", "license", "licence", "copying"}
+
+// ListFiles returns the files that go into the package in dir, relative to dir and sorted, picked the way
+// npm does. With a files field only what it lists is packed, otherwise everything that isn't excluded by a
+// .npmignore (or a .gitignore when there's none) in the same directory or above. package.json, the readme,
+// the license, main and the bin files are always packed
+func ListFiles(dir string) ([]string, error) {
+	pkgJSON, err := packagejson.ReadPackageJSON(dir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read package.json: %w", err)
+	}
+	// an empty files field packs only what's always packed, unlike a missing one
+	hasFiles := pkgJSON.Files != nil
+	var filesRules []ignoreRule
+	for _, pattern := range pkgJSON.Files {
+		rule := parseRule(strings.TrimPrefix(pattern, "/"))
+		rule.anchored, rule.dirOnly = true, false
+		filesRules = append(filesRules, rule)
+	}
+
+	required := make(map[string]bool)
+	if pkgJSON.Main != "" {
+		required[path.Clean(strings.TrimPrefix(filepath.ToSlash(string(pkgJSON.Main)), "./"))] = true
+	}
+	for _, binPath := range packagejson.GetBinaries(&pkgJSON, dir) {
+		required[path.Clean(strings.TrimPrefix(filepath.ToSlash(binPath), "./"))] = true
+	}
+
+	var files []string
+	var ignoreFiles []*ignoreFile
+	err = filepath.WalkDir(dir, func(filePath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		relativePath, err := filepath.Rel(dir, filePath)
+		if err != nil {
+			return err
+		}
+		relativePath = filepath.ToSlash(relativePath)
+		isRoot := relativePath == "."
+
+		if d.IsDir() {
+			if !isRoot {
+				if ignoredDirs[d.Name()] || ignored(ignoreFiles, relativePath, true) ||
+					(hasFiles && !couldIncludeByFiles(filesRules, relativePath) && !requiresFileIn(required, relativePath)) {
+					return filepath.SkipDir
+				}
+			}
+			// with a files field the root ignore file doesn't apply, nested ones still do
+			if isRoot && hasFiles {
+				return nil
+			}
+			ignoreDir := relativePath
+			if isRoot {
+				ignoreDir = ""
+			}
+			// ignore files only apply below their directory, so drop the ones of directories we've left
+			for len(ignoreFiles) > 0 && ignoreFiles[len(ignoreFiles)-1].dir != "" && !strings.HasPrefix(relativePath+"/", ignoreFiles[len(ignoreFiles)-1].dir+"/") {
+				ignoreFiles = ignoreFiles[:len(ignoreFiles)-1]
+			}
+			ignore, err := readIgnoreFile(filePath, ignoreDir)
+			if err != nil {
+				return fmt.Errorf("failed to read ignore file in %s: %w", relativePath, err)
+			}
+			if ignore != nil {
+				ignoreFiles = append(ignoreFiles, ignore)
+			}
+			return nil
+		}
+
+		if !d.Type().IsRegular() || !included(relativePath, d.Name(), hasFiles, filesRules, ignoreFiles, required) {
+			return nil
+		}
+		files = append(files, relativePath)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list files in %s: %w", dir, err)
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+func included(relativePath, name string, hasFiles bool, filesRules []ignoreRule, ignoreFiles []*ignoreFile, required map[string]bool) bool {
+	isRootFile := !strings.Contains(relativePath, "/")
+	if ignoredFiles[name] || (isRootFile && ignoredRootFiles[name]) || strings.HasPrefix(name, "._") || strings.HasSuffix(name, ".orig") {
+		return false
+	}
+	if required[relativePath] || (isRootFile && alwaysIncluded(name)) {
+		return true
+	}
+	if hasFiles && !includedByFiles(filesRules, relativePath) {
+		return false
+	}
+	return !ignored(ignoreFiles, relativePath, false)
+}
+
+func alwaysIncluded(name string) bool {
+	lowerName := strings.ToLower(name)
+	if lowerName == "package.json" {
+		return true
+	}
+	for _, prefix := range alwaysIncludedPrefixes {
+		if strings.HasPrefix(lowerName, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func requiresFileIn(required map[string]bool, dir string) bool {
+	for file := range required {
+		if strings.HasPrefix(file, dir+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// PackDirectory packs the package in dir into a tarball, the same way it'd be published
+func PackDirectory(dir string) (*bytes.Buffer, int, error) {
+	files, err := ListFiles(dir)
+	if err != nil {
+		return nil, 0, err
+	}
+	tarball, err := downloader.CreateTarball(dir, files)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to pack %s: %w", dir, err)
+	}
+	return tarball, len(files), nil
+}
+
+type File struct {
+	Path string
+	Size int64
+}
+
+// Tarball is a packed package, ready to be written out or published
+type Tarball struct {
+	Name         string
+	Version      string
+	Filename     string
+	Files        []File
+	Data         *bytes.Buffer
+	UnpackedSize int64
+	Shasum       string
+	Integrity    string
+}
+
+// Pack packs the package in dir into <name>-<version>.tgz
+func Pack(dir string) (*Tarball, error) {
+	pkgJSON, err := packagejson.ReadPackageJSON(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read package.json: %w", err)
+	}
+	if pkgJSON.Name == "" || pkgJSON.Version == "" {
+		return nil, fmt.Errorf("package.json must have a name and a version to be packed")
+	}
+	paths, err := ListFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+	tarball := &Tarball{Name: pkgJSON.Name, Version: pkgJSON.Version, Filename: TarballFilename(pkgJSON.Name, pkgJSON.Version)}
+	for _, file := range paths {
+		info, err := os.Stat(filepath.Join(dir, file))
+		if err != nil {
+			return nil, fmt.Errorf("failed to stat %s: %w", file, err)
+		}
+		tarball.Files = append(tarball.Files, File{Path: file, Size: info.Size()})
+		tarball.UnpackedSize += info.Size()
+	}
+	if tarball.Data, err = downloader.CreateTarball(dir, paths); err != nil {
+		return nil, fmt.Errorf("failed to pack %s: %w", dir, err)
+	}
+	tarball.Shasum = utils.ComputeShasum(tarball.Data.Bytes())
+	tarball.Integrity = utils.ComputeIntegrity(tarball.Data.Bytes())
+	return tarball, nil
+}
+
+// TarballFilename is the name npm gives tarballs, @scope/name@1.0.0 becomes scope-name-1.0.0.tgz
+func TarballFilename(name, version string) string {
+	return strings.ReplaceAll(strings.TrimPrefix(name, "@"), "/", "-") + "-" + version + ".tgz"
+}
@@ -0,0 +1,46 @@
+package pack
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		filePath := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+			t.Fatalf("failed to create %s: %v", filepath.Dir(filePath), err)
+		}
+		if err := os.WriteFile(filePath, []byte(content), 0644); err != nil {
+			t.Fatalf("failed to write %s: %v", name, err)
+		}
+	}
+}
+
+func TestListFilesFilesField(t *testing.T) {
+	for files, want := range map[string]string{
+		``:                  `README.md index.js lib/a.js package.json test/a.test.js`,
+		`"files": [],`:      `README.md package.json`,
+		`"files": ["lib"],`: `README.md lib/a.js package.json`,
+	} {
+		dir := t.TempDir()
+		writeFiles(t, dir, map[string]string{
+			"package.json":   `{"name": "pkg", "version": "1.0.0", ` + files + ` "license": "MIT"}`,
+			"README.md":      "# pkg\n",
+			"index.js":       "module.exports = 1\n",
+			"lib/a.js":       "module.exports = 2\n",
+			"test/a.test.js": "\n",
+		})
+		got, err := ListFiles(dir)
+		if err != nil {
+			t.Fatalf("ListFiles failed: %v", err)
+		}
+		if !reflect.DeepEqual(got, strings.Fields(want)) {
+			t.Errorf("with %q packed %v, want %v", files, got, want)
+		}
+	}
+}
@@ -0,0 +1,380 @@
+package packagejson
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/Eyepan/yap/src/types"
+)
+
+const byteOrderMark = "\xef\xbb\xbf"
+
+// Document is a package.json as it's written on disk. Writing it back keeps the key order, the indentation,
+// the byte order mark, the line endings, the trailing newline and every value that wasn't changed byte for
+// byte, so commands can edit the manifest without rewriting the parts they don't know about
+type Document struct {
+	root            *Object
+	indent          string
+	byteOrderMark   bool
+	crlf            bool
+	trailingNewline bool
+	original        []byte // returned as is by Bytes while nothing changed
+}
+
+// Object is a JSON object that remembers the order of its keys and the original text of its values
+type Object struct {
+	keys     []string
+	values   map[string]json.RawMessage
+	children map[string]*Object // nested objects opened for editing, rendered in place of their raw value
+	set      map[string]bool    // values encoded by Set, which still have to be indented
+	changed  bool
+}
+
+// NewDocument returns an empty document, indented with two spaces like npm writes them
+func NewDocument() *Document {
+	return &Document{root: newObject(), indent: "  ", trailingNewline: true}
+}
+
+// ReadDocument reads the package.json inside dir
+func ReadDocument(dir string) (*Document, error) {
+	data, err := os.ReadFile(filepath.Join(dir, "package.json"))
+	if err != nil {
+		return nil, err
+	}
+	document, err := ParseDocument(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse package.json: %w", err)
+	}
+	return document, nil
+}
+
+func ParseDocument(data []byte) (*Document, error) {
+	trimmed := bytes.TrimPrefix(data, []byte(byteOrderMark))
+	root, err := parseObject(trimmed)
+	if err != nil {
+		return nil, err
+	}
+	return &Document{
+		root:            root,
+		indent:          detectIndent(trimmed),
+		byteOrderMark:   len(trimmed) < len(data),
+		crlf:            bytes.Contains(trimmed, []byte("\r\n")),
+		trailingNewline: bytes.HasSuffix(trimmed, []byte("\n")),
+		original:        data,
+	}, nil
+}
+
+// detectIndent returns the whitespace before the first key, defaulting to two spaces for flat documents
+func detectIndent(data []byte) string {
+	for _, line := range strings.Split(string(data), "\n")[1:] {
+		line = strings.TrimSuffix(line, "\r")
+		indent := line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+		if indent != "" && len(indent) < len(line) {
+			return indent
+		}
+	}
+	return "  "
+}
+
+func newObject() *Object {
+	return &Object{values: make(map[string]json.RawMessage), children: make(map[string]*Object), set: make(map[string]bool)}
+}
+
+func parseObject(data []byte) (*Object, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	token, err := decoder.Token()
+	if err != nil {
+		return nil, err
+	}
+	if token != json.Delim('{') {
+		return nil, fmt.Errorf("expected an object")
+	}
+	object := newObject()
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return nil, err
+		}
+		key := token.(string)
+		var value json.RawMessage
+		if err := decoder.Decode(&value); err != nil {
+			return nil, err
+		}
+		if _, ok := object.values[key]; !ok {
+			object.keys = append(object.keys, key)
+		}
+		object.values[key] = value
+	}
+	if _, err := decoder.Token(); err != nil {
+		return nil, err
+	}
+	return object, nil
+}
+
+func (d *Document) Root() *Object {
+	return d.root
+}
+
+// Manifest decodes the document into the typed view of the fields yap understands
+func (d *Document) Manifest() (types.PackageJSON, error) {
+	var pkgJSON types.PackageJSON
+	err := d.Decode(&pkgJSON)
+	return pkgJSON, err
+}
+
+// Decode decodes the whole document into v, for the fields the typed view doesn't have
+func (d *Document) Decode(v any) error {
+	data, err := d.Bytes()
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(bytes.TrimPrefix(data, []byte(byteOrderMark)), v); err != nil {
+		return fmt.Errorf("failed to decode package.json: %w", err)
+	}
+	return nil
+}
+
+func (d *Document) Bytes() ([]byte, error) {
+	if d.original != nil && !d.root.isChanged() {
+		return d.original, nil
+	}
+	var buf bytes.Buffer
+	if d.byteOrderMark {
+		buf.WriteString(byteOrderMark)
+	}
+	if err := d.root.render(&buf, d.indent, 0); err != nil {
+		return nil, err
+	}
+	if d.trailingNewline {
+		buf.WriteByte('\n')
+	}
+	if !d.crlf {
+		return buf.Bytes(), nil
+	}
+	// unchanged values keep the line endings they were read with, JSON strings can't hold a raw newline
+	lf := bytes.ReplaceAll(buf.Bytes(), []byte("\r\n"), []byte("\n"))
+	return bytes.ReplaceAll(lf, []byte("\n"), []byte("\r\n")), nil
+}
+
+// Write writes the document to the package.json inside dir
+func (d *Document) Write(dir string) error {
+	data, err := d.Bytes()
+	if err != nil {
+		return fmt.Errorf("failed to encode package.json: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "package.json"), data, 0644); err != nil {
+		return fmt.Errorf("failed to write package.json: %w", err)
+	}
+	return nil
+}
+
+func (d *Document) String(key string) string {
+	var value string
+	_ = d.root.Get(key, &value)
+	return value
+}
+
+func (d *Document) SetString(key, value string) error {
+	return d.root.Set(key, value)
+}
+
+func (d *Document) Name() string {
+	return d.String("name")
+}
+
+func (d *Document) Version() string {
+	return d.String("version")
+}
+
+// Dependencies returns one of the dependency fields (dependencies, devDependencies, ...)
+func (d *Document) Dependencies(field string) types.Dependencies {
+	deps := make(types.Dependencies)
+	_ = d.root.Get(field, &deps)
+	return deps
+}
+
+// SetDependency adds or updates a dependency, keeping the field sorted like npm does
+func (d *Document) SetDependency(field, name, specifier string) error {
+	deps, err := d.root.Object(field)
+	if err != nil {
+		return err
+	}
+	if err := deps.Set(name, specifier); err != nil {
+		return err
+	}
+	deps.SortKeys()
+	return nil
+}
+
+// RemoveDependency removes a dependency and reports whether it was there
+func (d *Document) RemoveDependency(field, name string) bool {
+	if !d.root.Has(field) {
+		return false
+	}
+	deps, err := d.root.Object(field)
+	if err != nil {
+		return false
+	}
+	return deps.Delete(name)
+}
+
+func (o *Object) Keys() []string {
+	return append([]string{}, o.keys...)
+}
+
+func (o *Object) Has(key string) bool {
+	_, ok := o.values[key]
+	return ok
+}
+
+// Get decodes the value of key into v, leaving v untouched when the key isn't there
+func (o *Object) Get(key string, v any) error {
+	if child, ok := o.children[key]; ok {
+		var buf bytes.Buffer
+		if err := child.render(&buf, "", 0); err != nil {
+			return err
+		}
+		return json.Unmarshal(buf.Bytes(), v)
+	}
+	value, ok := o.values[key]
+	if !ok {
+		return nil
+	}
+	return json.Unmarshal(value, v)
+}
+
+// Set replaces the value of key where it is, or adds it at the end
+func (o *Object) Set(key string, v any) error {
+	value, err := marshal(v)
+	if err != nil {
+		return fmt.Errorf("failed to encode %s: %w", key, err)
+	}
+	if _, ok := o.values[key]; !ok {
+		o.keys = append(o.keys, key)
+	}
+	o.values[key] = value
+	o.set[key] = true
+	delete(o.children, key)
+	o.changed = true
+	return nil
+}
+
+func (o *Object) Delete(key string) bool {
+	if _, ok := o.values[key]; !ok {
+		return false
+	}
+	for i, existing := range o.keys {
+		if existing == key {
+			o.keys = append(o.keys[:i], o.keys[i+1:]...)
+			break
+		}
+	}
+	delete(o.values, key)
+	delete(o.set, key)
+	delete(o.children, key)
+	o.changed = true
+	return true
+}
+
+// Object opens the nested object under key for editing, adding an empty one when it isn't there
+func (o *Object) Object(key string) (*Object, error) {
+	if child, ok := o.children[key]; ok {
+		return child, nil
+	}
+	value, ok := o.values[key]
+	if !ok {
+		if err := o.Set(key, struct{}{}); err != nil {
+			return nil, err
+		}
+		value = o.values[key]
+	}
+	child, err := parseObject(value)
+	if err != nil {
+		return nil, fmt.Errorf("%s isn't an object: %w", key, err)
+	}
+	o.children[key] = child
+	return child, nil
+}
+
+func (o *Object) SortKeys() {
+	if !sort.StringsAreSorted(o.keys) {
+		sort.Strings(o.keys)
+		o.changed = true
+	}
+}
+
+func (o *Object) isChanged() bool {
+	if o.changed {
+		return true
+	}
+	for _, child := range o.children {
+		if child.isChanged() {
+			return true
+		}
+	}
+	return false
+}
+
+// render writes the object at the given depth. Unchanged values are written as they were read, which
+// keeps their formatting since they're put back at the same depth
+func (o *Object) render(buf *bytes.Buffer, indent string, depth int) error {
+	if len(o.keys) == 0 {
+		buf.WriteString("{}")
+		return nil
+	}
+	newline := "\n" + strings.Repeat(indent, depth+1)
+	if indent == "" {
+		newline = ""
+	}
+	buf.WriteByte('{')
+	for i, key := range o.keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		buf.WriteString(newline)
+		name, err := marshal(key)
+		if err != nil {
+			return err
+		}
+		buf.Write(name)
+		buf.WriteByte(':')
+		if indent != "" {
+			buf.WriteByte(' ')
+		}
+		if child, ok := o.children[key]; ok {
+			if err := child.render(buf, indent, depth+1); err != nil {
+				return err
+			}
+			continue
+		}
+		value := o.values[key]
+		if !o.set[key] || indent == "" {
+			buf.Write(value)
+			continue
+		}
+		if err := json.Indent(buf, value, strings.Repeat(indent, depth+1), indent); err != nil {
+			return err
+		}
+	}
+	if indent != "" {
+		buf.WriteString("\n" + strings.Repeat(indent, depth))
+	}
+	buf.WriteByte('}')
+	return nil
+}
+
+// marshal is json.Marshal without escaping <, > and &, which are common in scripts
+func marshal(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(v); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
@@ -1,23 +1,31 @@
 package packagejson
 
 import (
+	"bytes"
 	"encoding/json"
 	"os"
+	"path"
 	"path/filepath"
+	"strings"
 
 	"github.com/Eyepan/yap/src/types"
 )
 
 // ParsePackageJSON reads and parses package.json.
 func ParsePackageJSON() (types.PackageJSON, error) {
-	filePath := filepath.Join(".", "package.json")
+	return ReadPackageJSON(".")
+}
+
+// ReadPackageJSON reads and parses the package.json inside dir.
+func ReadPackageJSON(dir string) (types.PackageJSON, error) {
+	filePath := filepath.Join(dir, "package.json")
 	data, err := os.ReadFile(filePath)
 	if err != nil {
 		return types.PackageJSON{}, err
 	}
 
 	var pkgJSON types.PackageJSON
-	if err := json.Unmarshal(data, &pkgJSON); err != nil {
+	if err := json.Unmarshal(bytes.TrimPrefix(data, []byte(byteOrderMark)), &pkgJSON); err != nil {
 		return types.PackageJSON{}, err
 	}
 
@@ -37,3 +45,29 @@ func GetAllDependencies(pkgJSON *types.PackageJSON) types.Dependencies {
 	}
 	return deps
 }
+
+// GetBinaries returns the commands a package provides, mapped to paths relative to its directory
+func GetBinaries(pkgJSON *types.PackageJSON, pkgDir string) map[string]string {
+	binaries := make(map[string]string)
+	for name, path := range pkgJSON.Bin {
+		if name == "" {
+			// the string form is named after the package, without its scope
+			name = pkgJSON.Name[strings.LastIndex(pkgJSON.Name, "/")+1:]
+		}
+		binaries[name] = path
+	}
+	if len(binaries) > 0 || pkgJSON.Directories == nil || pkgJSON.Directories.Bin == "" {
+		return binaries
+	}
+
+	entries, err := os.ReadDir(filepath.Join(pkgDir, filepath.FromSlash(pkgJSON.Directories.Bin)))
+	if err != nil {
+		return binaries
+	}
+	for _, entry := range entries {
+		if entry.Type().IsRegular() && !strings.HasPrefix(entry.Name(), ".") {
+			binaries[entry.Name()] = path.Join(pkgJSON.Directories.Bin, entry.Name())
+		}
+	}
+	return binaries
+}
@@ -0,0 +1,162 @@
+package publish
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/Eyepan/yap/src/config"
+	"github.com/Eyepan/yap/src/pack"
+	"github.com/Eyepan/yap/src/packagejson"
+)
+
+type Options struct {
+	Registry      string
+	AuthToken     string
+	TokenRegistry string // the registry the auth token was configured for, it's never sent anywhere else
+	Tag           string // dist-tag the version is published under, latest when empty
+	Access        string // public or restricted, left to the registry when empty
+}
+
+// PublishConfig is the publishConfig field of package.json, which overrides the options it names
+type PublishConfig struct {
+	Registry string `json:"registry"`
+	Tag      string `json:"tag"`
+	Access   string `json:"access"`
+}
+
+type attachment struct {
+	ContentType string `json:"content_type"`
+	Data        string `json:"data"`
+	Length      int    `json:"length"`
+}
+
+// ReadPublishConfig returns the publishConfig of a package, and whether it's marked private
+func ReadPublishConfig(document *packagejson.Document) (PublishConfig, bool, error) {
+	var publishConfig PublishConfig
+	if err := document.Root().Get("publishConfig", &publishConfig); err != nil {
+		return publishConfig, false, fmt.Errorf("failed to parse publishConfig: %w", err)
+	}
+	private := false
+	_ = document.Root().Get("private", &private)
+	return publishConfig, private, nil
+}
+
+// Apply overrides the options with whatever publishConfig sets
+func (o *Options) Apply(publishConfig PublishConfig) {
+	if publishConfig.Registry != "" {
+		o.Registry = publishConfig.Registry
+	}
+	if publishConfig.Tag != "" {
+		o.Tag = publishConfig.Tag
+	}
+	if publishConfig.Access != "" {
+		o.Access = publishConfig.Access
+	}
+}
+
+// Document builds the body of the PUT request npm registries take for a new version: the packument with
+// only that version, the dist-tag pointing at it and the tarball attached as base64
+func Document(manifest *packagejson.Document, tarball *pack.Tarball, options *Options) ([]byte, error) {
+	tag := options.Tag
+	if tag == "" {
+		tag = "latest"
+	}
+
+	var version map[string]any
+	if err := manifest.Decode(&version); err != nil {
+		return nil, err
+	}
+	version["_id"] = tarball.Name + "@" + tarball.Version
+	version["dist"] = map[string]string{
+		"shasum":    tarball.Shasum,
+		"integrity": tarball.Integrity,
+		"tarball":   TarballURL(options.Registry, tarball.Name, tarball.Version),
+	}
+
+	document := map[string]any{
+		"_id":         tarball.Name,
+		"name":        tarball.Name,
+		"description": manifest.String("description"),
+		"dist-tags":   map[string]string{tag: tarball.Version},
+		"versions":    map[string]any{tarball.Version: version},
+		"access":      nil,
+		"_attachments": map[string]attachment{
+			tarball.Name + "-" + tarball.Version + ".tgz": {
+				ContentType: "application/octet-stream",
+				Data:        base64.StdEncoding.EncodeToString(tarball.Data.Bytes()),
+				Length:      tarball.Data.Len(),
+			},
+		},
+	}
+	if options.Access != "" {
+		document["access"] = options.Access
+	}
+	return json.Marshal(document)
+}
+
+// ValidateAccess checks an access level before anything is packed or sent, empty leaving it to the registry
+func ValidateAccess(access string) error {
+	if access != "" && access != "public" && access != "restricted" {
+		return fmt.Errorf("access must be public or restricted, not %s", access)
+	}
+	return nil
+}
+
+// Publish sends the document for the packed tarball to the registry
+func Publish(client *http.Client, manifest *packagejson.Document, tarball *pack.Tarball, options *Options) error {
+	if err := ValidateAccess(options.Access); err != nil {
+		return err
+	}
+	if options.AuthToken != "" && !config.SameOrigin(options.TokenRegistry, options.Registry) {
+		return fmt.Errorf("refusing to send the auth token of %s to %s", options.TokenRegistry, options.Registry)
+	}
+	document, err := Document(manifest, tarball, options)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("PUT", PackageURL(options.Registry, tarball.Name), bytes.NewReader(document))
+	if err != nil {
+		return fmt.Errorf("failed to create publish request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if options.AuthToken != "" {
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", options.AuthToken))
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send %s@%s to %s: %w", tarball.Name, tarball.Version, options.Registry, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+
+	// registries explain what went wrong in an error field
+	body, _ := io.ReadAll(resp.Body)
+	var registryError struct {
+		Error string `json:"error"`
+	}
+	message := strings.TrimSpace(string(body))
+	if json.Unmarshal(body, &registryError) == nil && registryError.Error != "" {
+		message = registryError.Error
+	}
+	return fmt.Errorf("registry responded with %s: %s", resp.Status, message)
+}
+
+// PackageURL is where the packument of a package lives, with the / of scoped names escaped
+func PackageURL(registry, name string) string {
+	return strings.TrimSuffix(registry, "/") + "/" + url.PathEscape(name)
+}
+
+// TarballURL is where npm registries serve a published tarball from
+func TarballURL(registry, name, version string) string {
+	return fmt.Sprintf("%s/%s/-/%s", strings.TrimSuffix(registry, "/"), name, pack.TarballFilename(name[strings.LastIndex(name, "/")+1:], version))
+}
@@ -0,0 +1,178 @@
+package publish
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Eyepan/yap/src/config"
+	"github.com/Eyepan/yap/src/pack"
+	"github.com/Eyepan/yap/src/packagejson"
+	"github.com/Eyepan/yap/src/types"
+)
+
+// request is what the test registry received
+type request struct {
+	method        string
+	path          string
+	authorization string
+	body          []byte
+}
+
+// newRegistry starts a registry that records every request and accepts them all
+func newRegistry(t *testing.T) (*httptest.Server, *[]request) {
+	var requests []request
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests = append(requests, request{method: r.Method, path: r.URL.EscapedPath(), authorization: r.Header.Get("Authorization"), body: body})
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"ok": true}`))
+	}))
+	t.Cleanup(server.Close)
+	return server, &requests
+}
+
+// packProject writes a package with manifest into a temp dir and packs it
+func packProject(t *testing.T, manifest string) (*packagejson.Document, *pack.Tarball) {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "package.json"), []byte(manifest), 0644); err != nil {
+		t.Fatalf("failed to write package.json: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "index.js"), []byte("module.exports = 1\n"), 0644); err != nil {
+		t.Fatalf("failed to write index.js: %v", err)
+	}
+	document, err := packagejson.ReadDocument(dir)
+	if err != nil {
+		t.Fatalf("failed to read package.json: %v", err)
+	}
+	tarball, err := pack.Pack(dir)
+	if err != nil {
+		t.Fatalf("failed to pack: %v", err)
+	}
+	return document, tarball
+}
+
+func TestPublishSendsTheVersionDocument(t *testing.T) {
+	server, requests := newRegistry(t)
+	manifest, tarball := packProject(t, `{"name": "@scope/name", "version": "1.2.3", "description": "a package"}`)
+
+	options := &Options{Registry: server.URL, AuthToken: "secret", TokenRegistry: server.URL, Tag: "next", Access: "public"}
+	if err := Publish(server.Client(), manifest, tarball, options); err != nil {
+		t.Fatalf("Publish failed: %v", err)
+	}
+	if len(*requests) != 1 {
+		t.Fatalf("registry got %d requests, want 1", len(*requests))
+	}
+	received := (*requests)[0]
+	if received.method != "PUT" || received.path != "/@scope%2Fname" {
+		t.Errorf("got %s %s, want PUT /@scope%%2Fname", received.method, received.path)
+	}
+	if received.authorization != "Bearer secret" {
+		t.Errorf("got Authorization %q, want the token", received.authorization)
+	}
+
+	var document struct {
+		Name     string            `json:"name"`
+		DistTags map[string]string `json:"dist-tags"`
+		Access   string            `json:"access"`
+		Versions map[string]struct {
+			Dist struct {
+				Shasum    string `json:"shasum"`
+				Integrity string `json:"integrity"`
+				Tarball   string `json:"tarball"`
+			} `json:"dist"`
+		} `json:"versions"`
+		Attachments map[string]attachment `json:"_attachments"`
+	}
+	if err := json.Unmarshal(received.body, &document); err != nil {
+		t.Fatalf("failed to decode the document: %v", err)
+	}
+	if document.Name != "@scope/name" || len(document.DistTags) != 1 || document.DistTags["next"] != "1.2.3" {
+		t.Errorf("got name %s and dist-tags %v, want @scope/name with next pointing at 1.2.3", document.Name, document.DistTags)
+	}
+	if document.Access != "public" {
+		t.Errorf("got access %q, want public", document.Access)
+	}
+
+	attached, ok := document.Attachments["@scope/name-1.2.3.tgz"]
+	if !ok {
+		t.Fatalf("the tarball isn't attached, got %v", document.Attachments)
+	}
+	data, err := base64.StdEncoding.DecodeString(attached.Data)
+	if err != nil {
+		t.Fatalf("the attachment isn't base64: %v", err)
+	}
+	if !bytes.Equal(data, tarball.Data.Bytes()) || attached.Length != len(data) {
+		t.Errorf("the attachment has %d bytes and a length of %d, want the %d bytes of the tarball", len(data), attached.Length, tarball.Data.Len())
+	}
+
+	dist := document.Versions["1.2.3"].Dist
+	sha512Sum := sha512.Sum512(data)
+	if want := "sha512-" + base64.StdEncoding.EncodeToString(sha512Sum[:]); dist.Integrity != want {
+		t.Errorf("got integrity %s, want %s", dist.Integrity, want)
+	}
+	sha1Sum := sha1.Sum(data)
+	if want := hex.EncodeToString(sha1Sum[:]); dist.Shasum != want {
+		t.Errorf("got shasum %s, want %s", dist.Shasum, want)
+	}
+	if want := server.URL + "/@scope/name/-/name-1.2.3.tgz"; dist.Tarball != want {
+		t.Errorf("got tarball %s, want %s", dist.Tarball, want)
+	}
+}
+
+func TestPublishKeepsTheTokenOffOtherRegistries(t *testing.T) {
+	configured, configuredRequests := newRegistry(t)
+	other, otherRequests := newRegistry(t)
+	manifest, tarball := packProject(t, `{"name": "pkg", "version": "1.0.0", "publishConfig": {"registry": "`+other.URL+`"}}`)
+
+	// the options are put together like yap publish does
+	conf := &types.YapConfig{Registry: configured.URL, AuthToken: "secret"}
+	publishConfig, _, err := ReadPublishConfig(manifest)
+	if err != nil {
+		t.Fatalf("failed to read publishConfig: %v", err)
+	}
+	options := &Options{Registry: conf.Registry}
+	options.Apply(publishConfig)
+	options.AuthToken, options.TokenRegistry = config.AuthTokenFor(conf, options.Registry), conf.Registry
+
+	if err := Publish(other.Client(), manifest, tarball, options); err != nil {
+		t.Fatalf("Publish failed: %v", err)
+	}
+	if len(*configuredRequests) != 0 || len(*otherRequests) != 1 {
+		t.Fatalf("got %d requests to the configured registry and %d to publishConfig.registry, want 0 and 1", len(*configuredRequests), len(*otherRequests))
+	}
+	if authorization := (*otherRequests)[0].authorization; authorization != "" {
+		t.Errorf("publishConfig.registry got Authorization %q", authorization)
+	}
+
+	// a token handed over anyway is refused rather than sent
+	options.AuthToken = conf.AuthToken
+	if err := Publish(other.Client(), manifest, tarball, options); err == nil {
+		t.Error("Publish sent the token of another registry")
+	}
+	if len(*otherRequests) != 1 {
+		t.Errorf("publishConfig.registry got %d requests, want 1", len(*otherRequests))
+	}
+}
+
+func TestPublishRejectsUnknownAccess(t *testing.T) {
+	server, requests := newRegistry(t)
+	manifest, tarball := packProject(t, `{"name": "pkg", "version": "1.0.0"}`)
+
+	if err := Publish(server.Client(), manifest, tarball, &Options{Registry: server.URL, Access: "bogus"}); err == nil {
+		t.Error("Publish accepted access bogus")
+	}
+	if len(*requests) != 0 {
+		t.Errorf("registry got %d requests, want none", len(*requests))
+	}
+}
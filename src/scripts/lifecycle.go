@@ -0,0 +1,198 @@
+package scripts
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/Eyepan/yap/src/linker"
+	"github.com/Eyepan/yap/src/packagejson"
+	"github.com/Eyepan/yap/src/spec"
+	"github.com/Eyepan/yap/src/types"
+)
+
+// InstallEvents are the scripts npm runs for a dependency once it's in node_modules, in order
+var InstallEvents = []string{"preinstall", "install", "postinstall"}
+
+// ImporterEvents are the scripts npm runs for the project itself (and its workspaces) after an install, in order
+var ImporterEvents = []string{"preinstall", "install", "postinstall", "preprepare", "prepare", "postprepare"}
+
+// builtMarker sits next to a package's node_modules in the virtual store once its scripts ran and holds
+// the integrity of what they ran on, so they run again when the package changes under the same version
+// (like a file: dependency that was packed again)
+const builtMarker = ".yap_built"
+
+// RunInstallScripts runs the install scripts of every package in the lockfile, dependencies before dependents.
+// Only packages listed in trusted may run anything, the others are reported so they can be allowed explicitly
+func RunInstallScripts(projectDir string, lockfile *types.Lockfile, trusted []string) error {
+	isTrusted := make(map[string]bool, len(trusted))
+	for _, name := range trusted {
+		isTrusted[name] = true
+	}
+
+	var blocked []string
+	for _, mPkg := range dependencyOrder(lockfile) {
+		if _, isLink := spec.ParseLink(mPkg.Version); isLink {
+			continue
+		}
+		modulesDir := linker.GetVirtualModulesDir(projectDir, mPkg.Name, mPkg.Version)
+		marker := filepath.Join(filepath.Dir(modulesDir), builtMarker)
+		if built, err := os.ReadFile(marker); err == nil && string(built) == mPkg.Dist.Integrity {
+			continue
+		}
+
+		pkgDir := filepath.Join(modulesDir, mPkg.Name)
+		pkgJSON, err := packagejson.ReadPackageJSON(pkgDir)
+		if err != nil {
+			return fmt.Errorf("failed to read package.json of %s@%s: %w", mPkg.Name, mPkg.Version, err)
+		}
+		events := installScripts(pkgDir, &pkgJSON)
+		if len(events) == 0 {
+			continue
+		}
+		if !isTrusted[mPkg.Name] {
+			blocked = append(blocked, mPkg.Name)
+			continue
+		}
+
+		if err := linker.DetachFromStore(pkgDir); err != nil {
+			return fmt.Errorf("failed to detach %s@%s from the store: %w", mPkg.Name, mPkg.Version, err)
+		}
+		binDirs := []string{
+			filepath.Join(pkgDir, "node_modules", ".bin"),
+			filepath.Join(modulesDir, ".bin"),
+			filepath.Join(projectDir, "node_modules", ".bin"),
+		}
+		for _, event := range events {
+			if err := runLifecycleScript(pkgDir, &pkgJSON, event, pkgJSON.Scripts[event], binDirs); err != nil {
+				return fmt.Errorf("%s script of %s@%s failed: %w", event, mPkg.Name, mPkg.Version, err)
+			}
+		}
+		if err := os.WriteFile(marker, []byte(mPkg.Dist.Integrity), 0644); err != nil {
+			return fmt.Errorf("failed to mark %s@%s as built: %w", mPkg.Name, mPkg.Version, err)
+		}
+	}
+
+	if len(blocked) > 0 {
+		sort.Strings(blocked)
+		slog.Warn(fmt.Sprintf("install scripts of %s were not run, add them to trustedDependencies in package.json to allow them", strings.Join(blocked, ", ")))
+	}
+	return nil
+}
+
+// RunImporterScripts runs the install and prepare scripts of the importers themselves once their
+// dependencies are in place, workspaces before the root like npm. Importers are directories relative to
+// projectDir, the root being "."
+func RunImporterScripts(projectDir string, importers []string, stdout, stderr io.Writer) error {
+	importers = append([]string{}, importers...)
+	sort.Slice(importers, func(i, j int) bool {
+		if (importers[i] == ".") != (importers[j] == ".") {
+			return importers[j] == "."
+		}
+		return importers[i] < importers[j]
+	})
+	for _, importer := range importers {
+		dir := filepath.Join(projectDir, filepath.FromSlash(importer))
+		pkgJSON, err := packagejson.ReadPackageJSON(dir)
+		if err != nil {
+			return fmt.Errorf("failed to read package.json of %s: %w", importer, err)
+		}
+		for _, event := range ImporterEvents {
+			if err := RunEvent(dir, &pkgJSON, event, stdout, stderr); err != nil {
+				return fmt.Errorf("failed to run the scripts of %s: %w", importer, err)
+			}
+		}
+	}
+	return nil
+}
+
+// installScripts returns the install events a package has scripts for. Like npm, a binding.gyp
+// without an install or preinstall script means `node-gyp rebuild`
+func installScripts(pkgDir string, pkgJSON *types.PackageJSON) []string {
+	_, hasInstall := pkgJSON.Scripts["install"]
+	_, hasPreinstall := pkgJSON.Scripts["preinstall"]
+	if !hasInstall && !hasPreinstall {
+		if _, err := os.Stat(filepath.Join(pkgDir, "binding.gyp")); err == nil {
+			if pkgJSON.Scripts == nil {
+				pkgJSON.Scripts = make(map[string]string)
+			}
+			pkgJSON.Scripts["install"] = "node-gyp rebuild"
+		}
+	}
+
+	var events []string
+	for _, event := range InstallEvents {
+		if _, ok := pkgJSON.Scripts[event]; ok {
+			events = append(events, event)
+		}
+	}
+	return events
+}
+
+// runLifecycleScript runs a single script, sending its output to the log
+func runLifecycleScript(pkgDir string, pkgJSON *types.PackageJSON, event, script string, binDirs []string) error {
+	slog.Info(fmt.Sprintf("[SCRIPT] %s@%s %s: %s", pkgJSON.Name, pkgJSON.Version, event, script))
+	var output bytes.Buffer
+	err := runShell(pkgDir, script, Environment(pkgDir, pkgJSON, event, script, binDirs), &output, &output)
+	for _, line := range strings.Split(strings.TrimRight(output.String(), "\n"), "\n") {
+		if line == "" {
+			continue
+		}
+		if err != nil {
+			slog.Error(fmt.Sprintf("[SCRIPT] %s@%s %s: %s", pkgJSON.Name, pkgJSON.Version, event, line))
+		} else {
+			slog.Info(fmt.Sprintf("[SCRIPT] %s@%s %s: %s", pkgJSON.Name, pkgJSON.Version, event, line))
+		}
+	}
+	return err
+}
+
+// dependencyOrder sorts the resolved packages so that every package comes after its dependencies.
+// Cycles are broken by whichever package of the cycle is reached first
+func dependencyOrder(lockfile *types.Lockfile) []*types.MPackage {
+	byID := make(map[string]*types.MPackage, len(lockfile.Resolutions))
+	for i := range lockfile.Resolutions {
+		mPkg := &lockfile.Resolutions[i]
+		byID[mPkg.Name+"@"+mPkg.Version] = mPkg
+	}
+
+	ordered := make([]*types.MPackage, 0, len(lockfile.Resolutions))
+	visited := make(map[string]bool, len(lockfile.Resolutions))
+	var visit func(mPkg *types.MPackage)
+	visit = func(mPkg *types.MPackage) {
+		id := mPkg.Name + "@" + mPkg.Version
+		if visited[id] {
+			return
+		}
+		visited[id] = true
+		for _, dep := range mPkg.Dependencies {
+			if resolved, ok := byID[dep.Name+"@"+dep.Version]; ok {
+				visit(resolved)
+			}
+		}
+		ordered = append(ordered, mPkg)
+	}
+	for i := range lockfile.Resolutions {
+		visit(&lockfile.Resolutions[i])
+	}
+	return ordered
+}
+
+// RunEvent runs a single lifecycle script of the package in dir, when it has one. Unlike RunScript
+// there's no pre/post, which is how npm runs the scripts around pack and publish
+func RunEvent(dir string, pkgJSON *types.PackageJSON, event string, stdout, stderr io.Writer) error {
+	script, ok := pkgJSON.Scripts[event]
+	if !ok {
+		return nil
+	}
+	fmt.Fprintf(stderr, "> %s@%s %s\n> %s\n", pkgJSON.Name, pkgJSON.Version, event, script)
+	if err := runShell(dir, script, Environment(dir, pkgJSON, event, script, BinDirs(dir)), stdout, stderr); err != nil {
+		return fmt.Errorf("%s script failed: %w", event, err)
+	}
+	return nil
+}
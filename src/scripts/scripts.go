@@ -0,0 +1,151 @@
+package scripts
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/Eyepan/yap/src/types"
+)
+
+// RunScript runs pre<name>, <name> and post<name> from the package.json in dir through the shell.
+// The extra args are only appended to the main script, like npm does
+func RunScript(dir string, pkgJSON *types.PackageJSON, name string, args []string, stdout, stderr io.Writer) error {
+	if _, ok := pkgJSON.Scripts[name]; !ok {
+		return fmt.Errorf("missing script: %s", name)
+	}
+	binDirs := BinDirs(dir)
+	for _, event := range []string{"pre" + name, name, "post" + name} {
+		script, ok := pkgJSON.Scripts[event]
+		if !ok {
+			continue
+		}
+		command := script
+		if event == name {
+			command = withArgs(script, args)
+		}
+		if err := runShell(dir, command, Environment(dir, pkgJSON, event, script, binDirs), stdout, stderr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// BinDirs returns node_modules/.bin of dir and of every directory above it, closest first
+func BinDirs(dir string) []string {
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return []string{filepath.Join(dir, "node_modules", ".bin")}
+	}
+	var binDirs []string
+	for current := absDir; ; current = filepath.Dir(current) {
+		binDirs = append(binDirs, filepath.Join(current, "node_modules", ".bin"))
+		if filepath.Dir(current) == current {
+			return binDirs
+		}
+	}
+}
+
+// ExitCode returns the exit code a failed script ended with, or 1 when it didn't get to exit on its own
+func ExitCode(err error) int {
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() > 0 {
+		return exitErr.ExitCode()
+	}
+	return 1
+}
+
+func runShell(dir, command string, env []string, stdout, stderr io.Writer) error {
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Dir = dir
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	cmd.Env = env
+	return cmd.Run()
+}
+
+// Environment returns the current environment plus what npm sets for scripts, with binDirs in front of PATH
+func Environment(dir string, pkgJSON *types.PackageJSON, event, script string, binDirs []string) []string {
+	env := os.Environ()
+	env = append(env,
+		"PATH="+strings.Join(append(binDirs, os.Getenv("PATH")), string(os.PathListSeparator)),
+		"npm_lifecycle_event="+event,
+		"npm_lifecycle_script="+script,
+		"npm_package_name="+pkgJSON.Name,
+		"npm_package_version="+pkgJSON.Version,
+		"npm_package_json="+filepath.Join(dir, "package.json"),
+		"npm_config_user_agent=yap",
+	)
+	env = append(env, packageVariables(filepath.Join(dir, "package.json"))...)
+	if self, err := os.Executable(); err == nil {
+		env = append(env, "npm_execpath="+self)
+	}
+	if _, ok := os.LookupEnv("INIT_CWD"); !ok {
+		if cwd, err := os.Getwd(); err == nil {
+			env = append(env, "INIT_CWD="+cwd)
+		}
+	}
+	return env
+}
+
+// withArgs appends the args to the script, single quoted so the shell passes them through untouched
+func withArgs(script string, args []string) string {
+	var builder strings.Builder
+	builder.WriteString(script)
+	for _, arg := range args {
+		builder.WriteString(" '")
+		builder.WriteString(strings.ReplaceAll(arg, "'", `'\''`))
+		builder.WriteString("'")
+	}
+	return builder.String()
+}
+
+// packageVariables flattens package.json into npm_package_<path> variables, e.g. npm_package_scripts_build
+func packageVariables(pkgJSONPath string) []string {
+	data, err := os.ReadFile(pkgJSONPath)
+	if err != nil {
+		return nil
+	}
+	var document map[string]any
+	if err := json.Unmarshal(data, &document); err != nil {
+		return nil
+	}
+	var variables []string
+	var flatten func(prefix string, value any)
+	flatten = func(prefix string, value any) {
+		switch v := value.(type) {
+		case map[string]any:
+			for key, child := range v {
+				flatten(prefix+"_"+variableName(key), child)
+			}
+		case []any:
+			for i, child := range v {
+				flatten(fmt.Sprintf("%s_%d", prefix, i), child)
+			}
+		case string:
+			variables = append(variables, prefix+"="+v)
+		case float64, bool:
+			variables = append(variables, fmt.Sprintf("%s=%v", prefix, v))
+		}
+	}
+	flatten("npm_package", document)
+	sort.Strings(variables)
+	return variables
+}
+
+// variableName makes a package.json key usable in an environment variable name
+func variableName(key string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '_' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return '_'
+	}, key)
+}
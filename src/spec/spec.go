@@ -0,0 +1,161 @@
+package spec
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/Eyepan/yap/src/types"
+)
+
+const aliasPrefix = "npm:"
+
+// ParseDependency turns an entry of a dependencies map into the package that has to be resolved for it.
+// Aliased entries ("react-17": "npm:react@^17") resolve the real package but keep the key as the alias
+func ParseDependency(name, specifier string) types.Package {
+	if realName, versionRange, ok := ParseAlias(specifier); ok {
+		pkg := types.Package{Name: realName, Version: versionRange}
+		if realName != name {
+			pkg.Alias = name
+		}
+		return pkg
+	}
+	return types.Package{Name: name, Version: specifier}
+}
+
+// ParseAlias splits an `npm:<name>@<range>` specifier into the real package name and its range
+func ParseAlias(specifier string) (string, string, bool) {
+	if !strings.HasPrefix(specifier, aliasPrefix) {
+		return "", "", false
+	}
+	rest := strings.TrimPrefix(specifier, aliasPrefix)
+	// scoped packages start with an @, so the version separator is never the first character
+	at := strings.LastIndex(rest, "@")
+	if at <= 0 {
+		return rest, "latest", true
+	}
+	versionRange := rest[at+1:]
+	if versionRange == "" {
+		versionRange = "latest"
+	}
+	return rest[:at], versionRange, true
+}
+
+// GitSpec is a dependency that has to be fetched from a git repository
+type GitSpec struct {
+	URL         string // something `git clone` understands
+	Committish  string // branch, tag or commit, empty for the default branch
+	SemverRange string // set for `#semver:<range>`, resolved against the repository tags
+}
+
+var gitHosts = map[string]string{
+	"github:":    "https://github.com/%s.git",
+	"gitlab:":    "https://gitlab.com/%s.git",
+	"bitbucket:": "https://bitbucket.org/%s.git",
+}
+
+// ParseGit recognises git+https, git+ssh, git+file, git:// and the hosted shorthands (github:org/repo, org/repo)
+func ParseGit(specifier string) (GitSpec, bool) {
+	repo, fragment, _ := strings.Cut(specifier, "#")
+	var gitSpec GitSpec
+
+	switch {
+	case strings.HasPrefix(repo, "git+"):
+		gitSpec.URL = strings.TrimPrefix(repo, "git+")
+		// git+ssh://git@github.com:org/repo.git is scp-like syntax wrapped in a url, git only accepts the bare form
+		if rest, ok := strings.CutPrefix(gitSpec.URL, "ssh://"); ok {
+			if host, path, found := strings.Cut(rest, ":"); found && !strings.Contains(host, "/") && path != "" && (path[0] < '0' || path[0] > '9') {
+				gitSpec.URL = host + ":" + path
+			}
+		}
+	case strings.HasPrefix(repo, "git://"):
+		gitSpec.URL = repo
+	default:
+		for prefix, format := range gitHosts {
+			if shorthand, ok := strings.CutPrefix(repo, prefix); ok {
+				gitSpec.URL = fmt.Sprintf(format, strings.TrimSuffix(shorthand, ".git"))
+			}
+		}
+		// npm treats a bare `org/repo` as a github shorthand
+		if gitSpec.URL == "" && isGithubShorthand(repo) {
+			gitSpec.URL = fmt.Sprintf(gitHosts["github:"], repo)
+		}
+	}
+	// git would take a url or committish starting with - for one of its options
+	if gitSpec.URL == "" || strings.HasPrefix(gitSpec.URL, "-") || strings.HasPrefix(fragment, "-") {
+		return GitSpec{}, false
+	}
+
+	if versionRange, ok := strings.CutPrefix(fragment, "semver:"); ok {
+		gitSpec.SemverRange = versionRange
+	} else {
+		gitSpec.Committish = fragment
+	}
+	return gitSpec, true
+}
+
+func isGithubShorthand(repo string) bool {
+	org, name, found := strings.Cut(repo, "/")
+	return found && org != "" && name != "" && !strings.ContainsAny(repo, ":@ ") &&
+		!strings.HasPrefix(org, ".") && !strings.Contains(name, "/")
+}
+
+// Resolved is the form recorded in the lockfile, pinned to an exact commit
+func (g GitSpec) Resolved(commit string) string {
+	return fmt.Sprintf("git+%s#%s", g.URL, commit)
+}
+
+// ParseFile returns the path of a `file:` specifier, npm also treats bare relative and absolute paths as one
+func ParseFile(specifier string) (string, bool) {
+	if path, ok := strings.CutPrefix(specifier, "file:"); ok {
+		return cleanPath(path), true
+	}
+	for _, prefix := range []string{"./", "../", "/"} {
+		if strings.HasPrefix(specifier, prefix) {
+			return cleanPath(specifier), true
+		}
+	}
+	return "", false
+}
+
+// ParseLink returns the path of a `link:` specifier, which is symlinked instead of going through the store
+func ParseLink(specifier string) (string, bool) {
+	if path, ok := strings.CutPrefix(specifier, "link:"); ok {
+		return cleanPath(path), true
+	}
+	return "", false
+}
+
+// Rebase makes the relative path of a `file:` or `link:` specifier declared in dir relative to the directory
+// dir is relative to, leaving absolute paths and every other specifier as they are
+func Rebase(specifier, dir string) string {
+	if localPath, ok := ParseLink(specifier); ok && !filepath.IsAbs(localPath) {
+		return "link:" + path.Join(dir, localPath)
+	}
+	if localPath, ok := ParseFile(specifier); ok && !filepath.IsAbs(localPath) {
+		return "file:" + path.Join(dir, localPath)
+	}
+	return specifier
+}
+
+// IsTarballURL reports whether specifier is a remote tarball rather than a registry range
+func IsTarballURL(specifier string) bool {
+	return strings.HasPrefix(specifier, "https://") || strings.HasPrefix(specifier, "http://")
+}
+
+// IsMutable reports whether a resolved version points at something that can change without its version changing
+func IsMutable(version string) bool {
+	_, isFile := ParseFile(version)
+	_, isLink := ParseLink(version)
+	return isFile || isLink
+}
+
+func cleanPath(path string) string {
+	return filepath.ToSlash(filepath.Clean(filepath.FromSlash(path)))
+}
+
+// ParseWorkspace returns the range of a `workspace:` specifier, which can only be satisfied by a workspace package
+func ParseWorkspace(specifier string) (string, bool) {
+	return strings.CutPrefix(specifier, "workspace:")
+}
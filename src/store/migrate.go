@@ -0,0 +1,112 @@
+package store
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Eyepan/yap/src/downloader"
+	"github.com/Eyepan/yap/src/filelock"
+	"github.com/Eyepan/yap/src/packagejson"
+	"github.com/Eyepan/yap/src/utils"
+)
+
+// the store layout, bumped when the directory names of packages change
+const storeLayout = "2"
+
+// MigrateLayout renames the packages in the store once after their directory names change. Up to layout 2
+// scope slashes became hyphens, which gave @a/b-c and @a-b/c the same directory, so the name and version
+// come from the store index instead of the directory name, or from the package.json of packages that were
+// extracted before there was an index. Packages with neither are left for prune
+func MigrateLayout() error {
+	storeDir, err := utils.GetStoreDir()
+	if err != nil {
+		return fmt.Errorf("failed to get store directory: %w", err)
+	}
+	if _, err := os.Stat(storeDir); os.IsNotExist(err) {
+		return nil
+	}
+	layoutFile, err := utils.GetStoreLayoutFile()
+	if err != nil {
+		return fmt.Errorf("failed to get store layout file: %w", err)
+	}
+	if layout, err := os.ReadFile(layoutFile); err == nil && strings.TrimSpace(string(layout)) == storeLayout {
+		return nil
+	}
+	lockFile, err := utils.GetStoreLockFile("layout")
+	if err != nil {
+		return fmt.Errorf("failed to get store layout lock file: %w", err)
+	}
+	lock, err := filelock.Acquire(lockFile)
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
+	// another yap process may have migrated it while this one waited for the lock
+	if layout, err := os.ReadFile(layoutFile); err == nil && strings.TrimSpace(string(layout)) == storeLayout {
+		return nil
+	}
+
+	dirs, err := packageDirs(storeDir)
+	if err != nil {
+		return err
+	}
+	for _, dir := range dirs {
+		if isCurrentID(dir.Name()) {
+			continue
+		}
+		indexFile := filepath.Join(storeDir, ".yap_index", dir.Name())
+		name, version, ok := storedPackage(filepath.Join(storeDir, dir.Name()), indexFile)
+		if !ok {
+			continue
+		}
+		id := utils.EncodePackageID(name, version)
+		if id == dir.Name() {
+			continue
+		}
+		oldDir, newDir := filepath.Join(storeDir, dir.Name()), filepath.Join(storeDir, id)
+		if _, err := os.Stat(newDir); err == nil {
+			// both layouts have it, the old copy isn't needed
+			if err := os.RemoveAll(oldDir); err != nil {
+				return fmt.Errorf("failed to remove %s: %w", oldDir, err)
+			}
+			if err := os.Remove(indexFile); err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("failed to remove %s: %w", indexFile, err)
+			}
+			continue
+		}
+		if err := os.Rename(oldDir, newDir); err != nil {
+			return fmt.Errorf("failed to move %s to %s: %w", oldDir, newDir, err)
+		}
+		if err := os.Rename(indexFile, filepath.Join(storeDir, ".yap_index", id)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to move the index of %s: %w", oldDir, err)
+		}
+	}
+	if err := os.WriteFile(layoutFile, []byte(storeLayout+"\n"), 0644); err != nil {
+		return fmt.Errorf("failed to write store layout file: %w", err)
+	}
+	return nil
+}
+
+// isCurrentID tells whether a directory name is already a package id of the current layout. Old scoped
+// names decode too, but without the slash a scoped name needs
+func isCurrentID(id string) bool {
+	name, version, ok := utils.DecodePackageID(id)
+	if !ok || strings.HasPrefix(name, "@") && !strings.Contains(name, "/") {
+		return false
+	}
+	return utils.EncodePackageID(name, version) == id
+}
+
+// storedPackage returns the name and version of the package extracted to packageDir
+func storedPackage(packageDir, indexFile string) (string, string, bool) {
+	if index, err := downloader.ReadStoreIndexFile(indexFile); err == nil {
+		return index.Name, index.Version, true
+	}
+	pkgJSON, err := packagejson.ReadPackageJSON(packageDir)
+	if err != nil || pkgJSON.Name == "" || pkgJSON.Version == "" {
+		return "", "", false
+	}
+	return pkgJSON.Name, pkgJSON.Version, true
+}
@@ -0,0 +1,358 @@
+package store
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Eyepan/yap/src/cache"
+	"github.com/Eyepan/yap/src/filelock"
+	"github.com/Eyepan/yap/src/spec"
+	"github.com/Eyepan/yap/src/utils"
+)
+
+type Status struct {
+	Size                 int64
+	Packages             int
+	ReferencedPackages   int
+	UnreferencedPackages int
+	Projects             int
+	CacheSize            int64
+	CacheEntries         int
+}
+
+type PruneResult struct {
+	Packages        int
+	CacheEntries    int
+	RemovedProjects int
+	Reclaimed       int64
+}
+
+// usage is what the live projects still need from the store
+type usage struct {
+	registered int
+	projects   []string        // projects that still have a lockfile
+	packages   map[string]bool // directory names inside the store
+	names      map[string]bool // package names, which metadata cache entries are kept for
+}
+
+// UnregisteredEnv is set for the installs yap runs in throwaway directories, like the checkout of a git
+// dependency it prepares, which aren't projects the store should keep packages for
+const UnregisteredEnv = "YAP_UNREGISTERED_INSTALL"
+
+// RegisterProject records that the project in projectDir links packages from the store, so prune keeps them
+func RegisterProject(projectDir string) error {
+	absDir, err := filepath.Abs(projectDir)
+	if err != nil {
+		return fmt.Errorf("failed to get absolute path of %s: %w", projectDir, err)
+	}
+	lock, err := lockProjects()
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
+	projects, err := ReadProjects()
+	if err != nil {
+		return err
+	}
+	for _, project := range projects {
+		if project == absDir {
+			return nil
+		}
+	}
+	return writeProjects(append(projects, absDir))
+}
+
+// Use keeps prune from removing anything until the returned lock is released. Installs hold it from before
+// they download anything until their project is registered, since the packages they extract aren't in any
+// registered lockfile until then. Any number of installs hold it at once, prune waits for all of them
+func Use() (*filelock.Lock, error) {
+	lockFile, err := usageLockFile()
+	if err != nil {
+		return nil, err
+	}
+	return filelock.AcquireShared(lockFile)
+}
+
+func usageLockFile() (string, error) {
+	lockFile, err := utils.GetStoreLockFile("usage")
+	if err != nil {
+		return "", fmt.Errorf("failed to get store usage lock file: %w", err)
+	}
+	return lockFile, nil
+}
+
+// lockProjects takes the lock held while the projects file is read and written again, so projects that
+// register at the same time don't drop each other
+func lockProjects() (*filelock.Lock, error) {
+	lockFile, err := utils.GetStoreLockFile("projects")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get projects lock file: %w", err)
+	}
+	return filelock.Acquire(lockFile)
+}
+
+// ReadProjects returns every project that registered itself, including ones that don't exist anymore
+func ReadProjects() ([]string, error) {
+	projectsFile, err := utils.GetProjectsFile()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get projects file path: %w", err)
+	}
+	data, err := os.ReadFile(projectsFile)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read projects file: %w", err)
+	}
+	return utils.ReadProjects(bytes.NewReader(data))
+}
+
+func writeProjects(projects []string) error {
+	sort.Strings(projects)
+	projectsFile, err := utils.GetProjectsFile()
+	if err != nil {
+		return fmt.Errorf("failed to get projects file path: %w", err)
+	}
+	var buf bytes.Buffer
+	if err := utils.WriteProjects(&buf, projects); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(projectsFile), os.ModePerm); err != nil {
+		return fmt.Errorf("failed to create store directory: %w", err)
+	}
+	// readers don't take the lock, so they get either the old file or the new one
+	tempFile := projectsFile + ".tmp"
+	if err := os.WriteFile(tempFile, buf.Bytes(), 0644); err != nil {
+		return fmt.Errorf("failed to write projects file: %w", err)
+	}
+	if err := os.Rename(tempFile, projectsFile); err != nil {
+		return fmt.Errorf("failed to write projects file: %w", err)
+	}
+	return nil
+}
+
+// collectUsage reads the lockfile of every registered project. Projects without a lockfile are gone, but a
+// lockfile that can't be read stops everything, since pruning would take packages the project still uses
+func collectUsage() (*usage, error) {
+	projects, err := ReadProjects()
+	if err != nil {
+		return nil, err
+	}
+	used := &usage{registered: len(projects), packages: make(map[string]bool), names: make(map[string]bool)}
+	for _, project := range projects {
+		if !utils.LockfileExistsIn(project) {
+			continue
+		}
+		lockfile, err := utils.ReadLockFrom(project)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the lockfile of %s, run `yap install` there or delete it: %w", project, err)
+		}
+		used.projects = append(used.projects, project)
+		for _, resolution := range lockfile.Resolutions {
+			if _, ok := spec.ParseLink(resolution.Version); ok {
+				continue
+			}
+			used.packages[utils.EncodePackageID(resolution.Name, resolution.Version)] = true
+			used.names[resolution.Name] = true
+		}
+	}
+	return used, nil
+}
+
+// packageDirs lists the package directories in the store, skipping yap's own dot directories
+func packageDirs(storeDir string) ([]os.DirEntry, error) {
+	entries, err := os.ReadDir(storeDir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read store directory: %w", err)
+	}
+	var dirs []os.DirEntry
+	for _, entry := range entries {
+		if entry.IsDir() && !strings.HasPrefix(entry.Name(), ".") {
+			dirs = append(dirs, entry)
+		}
+	}
+	return dirs, nil
+}
+
+func GetStatus() (*Status, error) {
+	storeDir, err := utils.GetStoreDir()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get store directory: %w", err)
+	}
+	used, err := collectUsage()
+	if err != nil {
+		return nil, err
+	}
+
+	status := &Status{Projects: len(used.projects)}
+	dirs, err := packageDirs(storeDir)
+	if err != nil {
+		return nil, err
+	}
+	for _, dir := range dirs {
+		status.Packages++
+		if used.packages[dir.Name()] {
+			status.ReferencedPackages++
+		} else {
+			status.UnreferencedPackages++
+		}
+		status.Size += diskUsage(filepath.Join(storeDir, dir.Name()))
+	}
+	cacheEntries, _ := cache.List()
+	for _, entry := range cacheEntries {
+		status.CacheEntries++
+		status.CacheSize += entry.Size
+	}
+	return status, nil
+}
+
+// Prune removes the packages and metadata cache entries no live project references, and forgets the
+// projects that are gone
+func Prune() (*PruneResult, error) {
+	storeDir, err := utils.GetStoreDir()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get store directory: %w", err)
+	}
+	// installs that haven't registered their project yet would lose the packages they just extracted. Taken
+	// before the projects lock, which installs take while holding this one to register
+	lockFile, err := usageLockFile()
+	if err != nil {
+		return nil, err
+	}
+	usageLock, err := filelock.Acquire(lockFile)
+	if err != nil {
+		return nil, err
+	}
+	defer usageLock.Release()
+	// a project registering while prune runs would otherwise be forgotten when the projects are written back
+	lock, err := lockProjects()
+	if err != nil {
+		return nil, err
+	}
+	defer lock.Release()
+	used, err := collectUsage()
+	if err != nil {
+		return nil, err
+	}
+
+	result := &PruneResult{RemovedProjects: used.registered - len(used.projects)}
+	if err := writeProjects(used.projects); err != nil {
+		return nil, err
+	}
+
+	dirs, err := packageDirs(storeDir)
+	if err != nil {
+		return nil, err
+	}
+	for _, dir := range dirs {
+		if used.packages[dir.Name()] {
+			continue
+		}
+		size, removed, err := removePackage(storeDir, dir.Name())
+		if err != nil {
+			return result, err
+		}
+		if !removed {
+			continue
+		}
+		result.Packages++
+		result.Reclaimed += size
+	}
+	result.Reclaimed += removeStaleExtractions()
+
+	cacheEntries, err := cache.List()
+	if err != nil {
+		return result, fmt.Errorf("failed to read the metadata cache: %w", err)
+	}
+	var unused []string
+	for _, entry := range cacheEntries {
+		if !used.names[entry.Name] {
+			unused = append(unused, entry.Name)
+		}
+	}
+	removed, freed, err := cache.Clean(unused)
+	if err != nil {
+		return result, err
+	}
+	result.CacheEntries += removed
+	result.Reclaimed += freed
+	return result, nil
+}
+
+// removePackage removes a package directory and its index, unless another yap process holds its lock and is
+// extracting or repairing it, reporting whether it did
+func removePackage(storeDir, id string) (int64, bool, error) {
+	lockFile, err := utils.GetStoreLockFile(id)
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to get package lock file: %w", err)
+	}
+	lock, err := filelock.TryAcquire(lockFile)
+	if err != nil || lock == nil {
+		return 0, false, err
+	}
+	defer lock.Release()
+
+	dirPath := filepath.Join(storeDir, id)
+	size := diskUsage(dirPath)
+	if err := os.RemoveAll(dirPath); err != nil {
+		return 0, false, fmt.Errorf("failed to remove %s: %w", dirPath, err)
+	}
+	indexFile := filepath.Join(storeDir, ".yap_index", id)
+	if err := os.Remove(indexFile); err != nil && !os.IsNotExist(err) {
+		return 0, false, fmt.Errorf("failed to remove %s: %w", indexFile, err)
+	}
+	return size, true, nil
+}
+
+// extractions that have been in the temp directory for this long were cut short, nobody is writing them anymore
+const staleExtractionAge = 24 * time.Hour
+
+// removeStaleExtractions removes what yap processes that got killed left in the temp directory of the store,
+// returning how many bytes that freed
+func removeStaleExtractions() int64 {
+	tempDir, err := utils.GetStoreTempDir()
+	if err != nil {
+		return 0
+	}
+	entries, err := os.ReadDir(tempDir)
+	if err != nil {
+		return 0
+	}
+	var freed int64
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || time.Since(info.ModTime()) < staleExtractionAge {
+			continue
+		}
+		path := filepath.Join(tempDir, entry.Name())
+		size := diskUsage(path)
+		if os.RemoveAll(path) == nil {
+			freed += size
+		}
+	}
+	return freed
+}
+
+// diskUsage adds up the size of every file under path
+func diskUsage(path string) int64 {
+	var size int64
+	_ = filepath.WalkDir(path, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if d.Type().IsRegular() {
+			if info, err := d.Info(); err == nil {
+				size += info.Size()
+			}
+		}
+		return nil
+	})
+	return size
+}
@@ -0,0 +1,190 @@
+package store
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Eyepan/yap/src/downloader"
+	"github.com/Eyepan/yap/src/filelock"
+	"github.com/Eyepan/yap/src/linker"
+	"github.com/Eyepan/yap/src/types"
+	"github.com/Eyepan/yap/src/utils"
+)
+
+// Problem is a package in the store that doesn't match its index
+type Problem struct {
+	ID       string // directory name inside the store
+	Reason   string
+	Repaired bool // downloaded again, otherwise it was removed for the next install to bring back
+}
+
+type VerifyResult struct {
+	Checked  int
+	Problems []Problem
+}
+
+// Verify hashes every file in the store against the index written when it was extracted. With repair set,
+// packages that were modified or never finished extracting are downloaded again when they came from a
+// tarball, or removed when they were built from git or local files so the next install rebuilds them.
+// Registered projects lose their copy of repaired packages, since those are hard links to the bad files
+func Verify(conf *types.YapConfig, repair bool) (*VerifyResult, error) {
+	storeDir, err := utils.GetStoreDir()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get store directory: %w", err)
+	}
+	dirs, err := packageDirs(storeDir)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &VerifyResult{}
+	for _, dir := range dirs {
+		result.Checked++
+		problem, err := verifyPackage(storeDir, dir.Name(), repair, conf)
+		if err != nil {
+			return result, err
+		}
+		if problem == nil {
+			continue
+		}
+		if repair {
+			if err := unlinkFromProjects(dir.Name()); err != nil {
+				return result, err
+			}
+		}
+		result.Problems = append(result.Problems, *problem)
+	}
+	return result, nil
+}
+
+// verifyPackage checks a package in the store, repairing it when asked to. It holds the lock of the package
+// throughout, so a package another yap process is extracting isn't taken for a broken one or removed under it
+func verifyPackage(storeDir, id string, repair bool, conf *types.YapConfig) (*Problem, error) {
+	lockFile, err := utils.GetStoreLockFile(id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get package lock file: %w", err)
+	}
+	lock, err := filelock.Acquire(lockFile)
+	if err != nil {
+		return nil, err
+	}
+	defer lock.Release()
+
+	pkgDir := filepath.Join(storeDir, id)
+	indexFile := filepath.Join(storeDir, ".yap_index", id)
+	if _, err := os.Stat(pkgDir); os.IsNotExist(err) {
+		// removed by another yap process while this one waited for the lock
+		return nil, nil
+	}
+	index, err := downloader.ReadStoreIndexFile(indexFile)
+	var reason string
+	switch {
+	case os.IsNotExist(err):
+		reason = "it has no index, so its extraction never finished"
+	case err != nil:
+		reason = "its index can't be read"
+	case !index.Complete:
+		reason = "its extraction never finished"
+	default:
+		reason = verifyFiles(pkgDir, index)
+	}
+	if reason == "" {
+		return nil, nil
+	}
+
+	problem := &Problem{ID: id, Reason: reason}
+	if repair {
+		if problem.Repaired, err = repairPackage(pkgDir, indexFile, index, conf); err != nil {
+			return nil, fmt.Errorf("failed to repair %s: %w", id, err)
+		}
+	}
+	return problem, nil
+}
+
+// verifyFiles returns what's wrong with the files of a package, or nothing when they match the index
+func verifyFiles(pkgDir string, index *types.StoreIndex) string {
+	expected := make(map[string]types.StoreFile, len(index.Files))
+	for _, file := range index.Files {
+		expected[file.Path] = file
+	}
+
+	var problems []string
+	err := filepath.WalkDir(pkgDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		relativePath, err := filepath.Rel(pkgDir, path)
+		if err != nil {
+			return err
+		}
+		relativePath = filepath.ToSlash(relativePath)
+		file, ok := expected[relativePath]
+		if !ok {
+			problems = append(problems, relativePath+" was added")
+			return nil
+		}
+		delete(expected, relativePath)
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if int64(len(data)) != file.Size || utils.ComputeIntegrity(data) != file.Integrity {
+			problems = append(problems, relativePath+" was modified")
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Sprintf("failed to read its files: %v", err)
+	}
+	for path := range expected {
+		problems = append(problems, path+" is missing")
+	}
+	return strings.Join(problems, ", ")
+}
+
+// repairPackage downloads a package again or removes it, for callers holding its lock
+func repairPackage(pkgDir, indexFile string, index *types.StoreIndex, conf *types.YapConfig) (bool, error) {
+	if index != nil && (strings.HasPrefix(index.Tarball, "http://") || strings.HasPrefix(index.Tarball, "https://")) {
+		tarball, err := downloader.DownloadTarball(&index.Tarball, conf)
+		if err != nil {
+			return false, fmt.Errorf("failed to download %s: %w", index.Tarball, err)
+		}
+		if index.Integrity != "" && utils.ComputeIntegrity(tarball.Bytes()) != index.Integrity {
+			return false, fmt.Errorf("%s doesn't match the integrity it was first downloaded with", index.Tarball)
+		}
+		if err := downloader.ExtractTarballLocked(tarball, index.Name, index.Version, index.Tarball); err != nil {
+			return false, err
+		}
+		return true, nil
+	}
+
+	if err := os.RemoveAll(pkgDir); err != nil {
+		return false, fmt.Errorf("failed to remove %s: %w", pkgDir, err)
+	}
+	if err := os.Remove(indexFile); err != nil && !os.IsNotExist(err) {
+		return false, fmt.Errorf("failed to remove %s: %w", indexFile, err)
+	}
+	return false, nil
+}
+
+// unlinkFromProjects removes a package from the virtual store of every registered project, so their next
+// install links the repaired files instead of keeping the bad ones
+func unlinkFromProjects(id string) error {
+	projects, err := ReadProjects()
+	if err != nil {
+		return err
+	}
+	for _, project := range projects {
+		virtualDir := filepath.Join(linker.GetVirtualStoreDir(project), id)
+		if err := os.RemoveAll(virtualDir); err != nil {
+			return fmt.Errorf("failed to remove %s: %w", virtualDir, err)
+		}
+	}
+	return nil
+}
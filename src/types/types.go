@@ -1,47 +1,192 @@
 package types
 
+import (
+	"bytes"
+	"encoding/json"
+)
+
 type YapConfigLogLevel string
 
 type YapConfig struct {
-	Registry  string
-	AuthToken string
-	LogLevel  string
+	Registry       string
+	AuthToken      string
+	LogLevel       string
+	CacheMaxSize   int64  // bytes the metadata cache may take up before the least recently used entries go, 0 for no limit
+	LockfileExport string // format of another package manager's lockfile to keep up to date on every install, e.g. npm
+	LockfileFormat string // binary (yap.lockb), text (yap.lock) or both, replacing the lockfile a project has in the other format
 }
 
 type Dependencies map[string]string
 
+// Workspaces accepts both the array form and the yarn `{"packages": [...]}` form of the workspaces field
+type Workspaces []string
+
+func (w *Workspaces) UnmarshalJSON(data []byte) error {
+	var patterns []string
+	if err := json.Unmarshal(data, &patterns); err == nil {
+		*w = patterns
+		return nil
+	}
+	var object struct {
+		Packages []string `json:"packages"`
+	}
+	if err := json.Unmarshal(data, &object); err != nil {
+		return err
+	}
+	*w = object.Packages
+	return nil
+}
+
+// Bin accepts both forms of the bin field, a single path (stored under "" and named after the package
+// when linked) or a map of command names to paths
+type Bin map[string]string
+
+func (b *Bin) UnmarshalJSON(data []byte) error {
+	var path string
+	if err := json.Unmarshal(data, &path); err == nil {
+		*b = Bin{"": path}
+		return nil
+	}
+	var commands map[string]string
+	if err := json.Unmarshal(data, &commands); err != nil {
+		return err
+	}
+	*b = commands
+	return nil
+}
+
+func (b Bin) MarshalJSON() ([]byte, error) {
+	if path, ok := b[""]; ok && len(b) == 1 {
+		return json.Marshal(path)
+	}
+	return json.Marshal(map[string]string(b))
+}
+
+// License accepts the license field both as an SPDX expression and in the deprecated
+// `{"type": "MIT", "url": ...}` form older published packages still use, keeping the type
+type License string
+
+func (l *License) UnmarshalJSON(data []byte) error {
+	var expression string
+	if err := json.Unmarshal(data, &expression); err == nil {
+		*l = License(expression)
+		return nil
+	}
+	var object struct {
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal(data, &object); err == nil {
+		*l = License(object.Type)
+	}
+	// anything else isn't worth failing an install over
+	return nil
+}
+
+// FilePath is a path field like main, left empty instead of failing when a published package has
+// something other than a string in it
+type FilePath string
+
+func (p *FilePath) UnmarshalJSON(data []byte) error {
+	var path string
+	if err := json.Unmarshal(data, &path); err == nil {
+		*p = FilePath(path)
+	}
+	return nil
+}
+
+// FileList is a list of paths or patterns like files, a single string counting as a list of one and
+// entries that aren't strings being skipped, since published packages don't always follow the schema.
+// It's nil when the field is missing and empty when it lists nothing
+type FileList []string
+
+func (f *FileList) UnmarshalJSON(data []byte) error {
+	if string(bytes.TrimSpace(data)) == "null" {
+		return nil
+	}
+	var path string
+	if err := json.Unmarshal(data, &path); err == nil {
+		*f = FileList{path}
+		return nil
+	}
+	var entries []json.RawMessage
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil
+	}
+	list := FileList{}
+	for _, entry := range entries {
+		if err := json.Unmarshal(entry, &path); err == nil {
+			list = append(list, path)
+		}
+	}
+	*f = list
+	return nil
+}
+
+type Directories struct {
+	Bin string `json:"bin,omitempty"` // every file in it becomes a command, when there's no bin field
+}
+
 type PackageJSON struct {
-	Name             string       `json:"name"`
-	Module           string       `json:"module"`
-	Type             string       `json:"type"`
-	DevDependencies  Dependencies `json:"devDependencies"`
-	PeerDependencies Dependencies `json:"peerDependencies"`
-	Dependencies     Dependencies `json:"dependencies"`
+	Name                string                     `json:"name,omitempty"`
+	Version             string                     `json:"version,omitempty"`
+	Description         string                     `json:"description,omitempty"`
+	Main                FilePath                   `json:"main,omitempty"`
+	Files               FileList                   `json:"files,omitempty"` // what gets packed, everything when it's missing
+	Scripts             map[string]string          `json:"scripts,omitempty"`
+	Bin                 Bin                        `json:"bin,omitempty"`
+	Directories         *Directories               `json:"directories,omitempty"`
+	Module              string                     `json:"module,omitempty"`
+	Type                string                     `json:"type,omitempty"`
+	License             License                    `json:"license,omitempty"`
+	DevDependencies     Dependencies               `json:"devDependencies,omitempty"`
+	PeerDependencies    Dependencies               `json:"peerDependencies,omitempty"`
+	Dependencies        Dependencies               `json:"dependencies,omitempty"`
+	Workspaces          Workspaces                 `json:"workspaces,omitempty"`
+	Overrides           map[string]json.RawMessage `json:"overrides,omitempty"`           // npm syntax, values are versions or nested objects
+	Resolutions         map[string]string          `json:"resolutions,omitempty"`         // yarn syntax
+	TrustedDependencies []string                   `json:"trustedDependencies,omitempty"` // packages allowed to run install scripts
 }
 
 type Package struct {
 	Name    string `json:"name"`
 	Version string `json:"version"`
+	Alias   string `json:"alias,omitempty"` // directory name under node_modules, when it differs from Name
 }
 
 type MPackage struct {
 	Name         string
 	Version      string
+	Alias        string
 	Dist         Dist
 	Dependencies []*MPackage
 }
 
 type Dist struct {
 	Shasum    string `json:"shasum"`
+	Integrity string `json:"integrity"`
 	Tarball   string `json:"tarball"`
 	FileCount int64  `json:"fileCount"`
 }
 
 type Lockfile struct {
 	CoreDependencies []Package
+	Importers        []Importer // workspace packages, the root's dependencies are CoreDependencies
+	Overrides        []LockedOverride
 	Resolutions      []MPackage
 }
 
+// LockedOverride is an override/resolution rule the lockfile was resolved with
+type LockedOverride struct {
+	Selector string   // what it matches, e.g. `parent>child@^1`
+	Version  string   // what it forces them to
+	Applied  []string // name@version of the packages it forced
+}
+
+type Importer struct {
+	Path         string // relative to the workspace root
+	Dependencies []Package
+}
+
 type Metadata struct {
 	Name     string `json:"name"`
 	DistTags struct {
@@ -57,3 +202,35 @@ type VersionMetadata struct {
 	Dist         Dist         `json:"dist"`
 	Dependencies Dependencies `json:"dependencies"`
 }
+
+// StoreIndex records where a package in the store came from and what was extracted, so the store can be
+// verified and repaired. It's only marked complete once every file is on disk
+type StoreIndex struct {
+	Name      string
+	Version   string
+	Tarball   string // where to download it again, empty for packages built from git or local files
+	Integrity string // of the tarball
+	Complete  bool
+	Files     []StoreFile
+}
+
+type StoreFile struct {
+	Path      string // relative to the package directory, slash separated
+	Size      int64
+	Integrity string
+}
+
+// CacheIndex locates the packuments in the metadata cache file. Records appended after Size aren't in it
+// yet and are found by reading the file from there
+type CacheIndex struct {
+	Size    int64
+	Entries []CacheIndexEntry
+}
+
+type CacheIndexEntry struct {
+	Name      string
+	Offset    int64 // of its record in the cache file
+	Length    int64
+	FetchedAt int64 // unix nanoseconds
+	LastUsed  int64
+}
@@ -0,0 +1,111 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+)
+
+// binaryFormat is the header every binary file yap writes starts with: magic bytes telling the files
+// apart and the version of the schema after them. A CRC32 of everything before it ends the file
+type binaryFormat struct {
+	name    string // what the file is, for errors
+	magic   [4]byte
+	version uint16
+	hint    string // what gets the user going again when the file can't be read
+}
+
+var (
+	metadataFormat   = binaryFormat{name: "metadata cache entry", magic: [4]byte{'Y', 'A', 'P', 'M'}, version: 1, hint: "run `yap cache clean` to fetch it again"}
+	lockfileFormat   = binaryFormat{name: "yap.lockb", magic: [4]byte{'Y', 'A', 'P', 'L'}, version: 1, hint: "delete it and run `yap install` to resolve again"}
+	configFormat     = binaryFormat{name: "config", magic: [4]byte{'Y', 'A', 'P', 'C'}, version: 1, hint: "delete ~/.yap_config and set it up again with `yap config set`"}
+	projectsFormat   = binaryFormat{name: "projects file", magic: [4]byte{'Y', 'A', 'P', 'P'}, version: 1, hint: "delete ~/.yap_store/.yap_projects and run `yap install` in your projects again"}
+	storeIndexFormat = binaryFormat{name: "store index", magic: [4]byte{'Y', 'A', 'P', 'I'}, version: 1, hint: "run `yap store verify` to extract the package again"}
+	cacheIndexFormat = binaryFormat{name: "metadata cache index", magic: [4]byte{'Y', 'A', 'P', 'X'}, version: 1, hint: "delete it, the cache is read again to rebuild it"}
+)
+
+// CorruptError is returned for a binary file that can't be decoded: it's truncated, its checksum doesn't match
+// or something in it is out of bounds. Caches discard such files and fetch them again
+type CorruptError struct {
+	File string // what the file is, e.g. yap.lockb
+	Hint string // what gets the user going again
+	Err  error
+}
+
+func (e *CorruptError) Error() string {
+	return fmt.Sprintf("%s is corrupted, %s: %v", e.File, e.Hint, e.Err)
+}
+
+func (e *CorruptError) Unwrap() error {
+	return e.Err
+}
+
+// NewerVersionError is returned for a binary file written by a newer yap, with a schema this one can't read
+type NewerVersionError struct {
+	File             string
+	Hint             string
+	Version, Current uint16
+}
+
+func (e *NewerVersionError) Error() string {
+	return fmt.Sprintf("%s was written by a newer yap (version %d, this one reads up to %d), upgrade yap or %s", e.File, e.Version, e.Current, e.Hint)
+}
+
+const (
+	headerSize   = 6 // magic and version
+	checksumSize = 4
+)
+
+// legacyVersion is what files written before the header are read as. Their bodies are laid out like yap
+// first wrote them, before aliases, integrities, importers and overrides
+const legacyVersion uint16 = 0
+
+func (f binaryFormat) writeHeader(buf *bytes.Buffer) {
+	buf.Write(f.magic[:])
+	_ = binary.Write(buf, binary.LittleEndian, f.version)
+}
+
+// writeChecksum ends the file that started at start in buf
+func (f binaryFormat) writeChecksum(buf *bytes.Buffer, start int) {
+	_ = binary.Write(buf, binary.LittleEndian, crc32.ChecksumIEEE(buf.Bytes()[start:]))
+}
+
+// open checks the header and checksum of the file left in buf and returns a reader over its body, along with
+// the version of the schema it's in. Files written before the header have none of it and are legacyVersion,
+// writing them again upgrades them
+func (f binaryFormat) open(buf *bytes.Reader) (*bytes.Reader, uint16, error) {
+	data, err := io.ReadAll(buf)
+	if err != nil {
+		return nil, 0, f.unreadable(err)
+	}
+	if !bytes.HasPrefix(data, f.magic[:]) {
+		return bytes.NewReader(data), legacyVersion, nil
+	}
+	if len(data) < headerSize+checksumSize {
+		return nil, 0, f.unreadable(fmt.Errorf("it's truncated"))
+	}
+	version := binary.LittleEndian.Uint16(data[len(f.magic):headerSize])
+	if version > f.version {
+		return nil, 0, &NewerVersionError{File: f.name, Hint: f.hint, Version: version, Current: f.version}
+	}
+	body, checksum := data[:len(data)-checksumSize], binary.LittleEndian.Uint32(data[len(data)-checksumSize:])
+	if crc32.ChecksumIEEE(body) != checksum {
+		return nil, 0, f.unreadable(fmt.Errorf("its checksum doesn't match"))
+	}
+	return bytes.NewReader(body[headerSize:]), version, nil
+}
+
+// unreadable explains what to do about a file that's corrupted
+func (f binaryFormat) unreadable(err error) error {
+	return &CorruptError{File: f.name, Hint: f.hint, Err: err}
+}
+
+// finish checks that decoding the body used all of it
+func (f binaryFormat) finish(body *bytes.Reader) error {
+	if body.Len() > 0 {
+		return f.unreadable(fmt.Errorf("%d bytes are left after it", body.Len()))
+	}
+	return nil
+}
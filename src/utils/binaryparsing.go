@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/binary"
 	"fmt"
+	"io"
 
 	"github.com/Eyepan/yap/src/types"
 )
@@ -28,6 +29,9 @@ func writeVersionMetadata(buf *bytes.Buffer, vm types.VersionMetadata) error {
 	if err := writeString(buf, vm.Dist.Shasum); err != nil {
 		return fmt.Errorf("failed to write version metadata shasum: %w", err)
 	}
+	if err := writeString(buf, vm.Dist.Integrity); err != nil {
+		return fmt.Errorf("failed to write version metadata integrity: %w", err)
+	}
 	if err := writeString(buf, vm.Dist.Tarball); err != nil {
 		return fmt.Errorf("failed to write version metadata tarball: %w", err)
 	}
@@ -50,7 +54,7 @@ func writeVersionMetadata(buf *bytes.Buffer, vm types.VersionMetadata) error {
 	return nil
 }
 
-func WriteMetadata(buf *bytes.Buffer, metadata types.Metadata) error {
+func writeMetadataBody(buf *bytes.Buffer, metadata types.Metadata) error {
 	if err := writeString(buf, metadata.Name); err != nil {
 		return fmt.Errorf("failed to write metadata name: %w", err)
 	}
@@ -76,21 +80,69 @@ func WriteMetadata(buf *bytes.Buffer, metadata types.Metadata) error {
 	return nil
 }
 
+// smallest encoding of each element a count is read for, so a count can be checked against what's left
+const (
+	stringSize          = 4
+	dependencyEntrySize = 2 * stringSize
+	versionEntrySize    = stringSize + 5*stringSize + 8 + 4
+	packageSize         = 3 * stringSize
+	mPackageSize        = 6*stringSize + 8 + 4
+	importerSize        = stringSize + 4
+	overrideSize        = 2*stringSize + 4
+	storeFileSize       = stringSize + 8 + stringSize
+	cacheIndexEntrySize = stringSize + 4*8
+	// legacy files have no aliases or integrities
+	legacyVersionEntrySize = stringSize + 4*stringSize + 8 + 4
+	legacyPackageSize      = 2 * stringSize
+	legacyMPackageSize     = 4*stringSize + 8 + 4
+)
+
+// sizeFor picks the smallest encoding of an element in a file of the given version
+func sizeFor(version uint16, size, legacySize int) int {
+	if version == legacyVersion {
+		return legacySize
+	}
+	return size
+}
+
+// the deepest mPackages nest in a lockfile, they only ever go one level down
+const maxMPackageDepth = 16
+
 func readString(buf *bytes.Reader) (string, error) {
 	var length int32
 	if err := binary.Read(buf, binary.LittleEndian, &length); err != nil {
 		return "", fmt.Errorf("failed to read string length: %w", err)
 	}
 
+	if length == 0 {
+		// reading nothing at the end of the buffer would report EOF
+		return "", nil
+	}
+	// a length that doesn't fit what's left is corruption, allocating it could take gigabytes
+	if length < 0 || int64(length) > int64(buf.Len()) {
+		return "", fmt.Errorf("string length %d is out of bounds, %d bytes are left", length, buf.Len())
+	}
 	strBytes := make([]byte, length)
-	if _, err := buf.Read(strBytes); err != nil {
+	if _, err := io.ReadFull(buf, strBytes); err != nil {
 		return "", fmt.Errorf("failed to read string content: %w", err)
 	}
 
 	return string(strBytes), nil
 }
 
-func readVersionMetadata(buf *bytes.Reader) (types.VersionMetadata, error) {
+// readCount reads the number of elements that follow, each taking at least elementSize bytes
+func readCount(buf *bytes.Reader, elementSize int) (int, error) {
+	var count int32
+	if err := binary.Read(buf, binary.LittleEndian, &count); err != nil {
+		return 0, err
+	}
+	if count < 0 || int64(count)*int64(elementSize) > int64(buf.Len()) {
+		return 0, fmt.Errorf("count %d is out of bounds, %d bytes are left", count, buf.Len())
+	}
+	return int(count), nil
+}
+
+func readVersionMetadata(buf *bytes.Reader, version uint16) (types.VersionMetadata, error) {
 	var vm types.VersionMetadata
 	var err error
 	if vm.Name, err = readString(buf); err != nil {
@@ -102,6 +154,11 @@ func readVersionMetadata(buf *bytes.Reader) (types.VersionMetadata, error) {
 	if vm.Dist.Shasum, err = readString(buf); err != nil {
 		return vm, fmt.Errorf("failed to read version metadata shasum: %w", err)
 	}
+	if version != legacyVersion {
+		if vm.Dist.Integrity, err = readString(buf); err != nil {
+			return vm, fmt.Errorf("failed to read version metadata integrity: %w", err)
+		}
+	}
 	if vm.Dist.Tarball, err = readString(buf); err != nil {
 		return vm, fmt.Errorf("failed to read version metadata tarball: %w", err)
 	}
@@ -109,8 +166,8 @@ func readVersionMetadata(buf *bytes.Reader) (types.VersionMetadata, error) {
 		return vm, fmt.Errorf("failed to read version metadata file count: %w", err)
 	}
 
-	var depCount int32
-	if err := binary.Read(buf, binary.LittleEndian, &depCount); err != nil {
+	depCount, err := readCount(buf, dependencyEntrySize)
+	if err != nil {
 		return vm, fmt.Errorf("failed to read dependencies count: %w", err)
 	}
 	vm.Dependencies = make(map[string]string, depCount)
@@ -129,7 +186,7 @@ func readVersionMetadata(buf *bytes.Reader) (types.VersionMetadata, error) {
 	return vm, nil
 }
 
-func ReadMetadata(buf *bytes.Reader) (*types.Metadata, error) {
+func readMetadataBody(buf *bytes.Reader, version uint16) (*types.Metadata, error) {
 	var metadata types.Metadata
 	var err error
 	if metadata.Name, err = readString(buf); err != nil {
@@ -142,8 +199,8 @@ func ReadMetadata(buf *bytes.Reader) (*types.Metadata, error) {
 		return nil, fmt.Errorf("failed to read metadata dist tag next: %w", err)
 	}
 
-	var versionCount int32
-	if err := binary.Read(buf, binary.LittleEndian, &versionCount); err != nil {
+	versionCount, err := readCount(buf, sizeFor(version, versionEntrySize, legacyVersionEntrySize))
+	if err != nil {
 		return nil, fmt.Errorf("failed to read versions count: %w", err)
 	}
 	metadata.Versions = make(map[string]types.VersionMetadata, versionCount)
@@ -152,7 +209,7 @@ func ReadMetadata(buf *bytes.Reader) (*types.Metadata, error) {
 		if err != nil {
 			return nil, fmt.Errorf("failed to read version key: %w", err)
 		}
-		vm, err := readVersionMetadata(buf)
+		vm, err := readVersionMetadata(buf, version)
 		if err != nil {
 			return nil, fmt.Errorf("failed to read version metadata: %w", err)
 		}
@@ -169,6 +226,9 @@ func writePackage(buf *bytes.Buffer, pkg types.Package) error {
 	if err := writeString(buf, pkg.Version); err != nil {
 		return fmt.Errorf("failed to write package version: %w", err)
 	}
+	if err := writeString(buf, pkg.Alias); err != nil {
+		return fmt.Errorf("failed to write package alias: %w", err)
+	}
 	return nil
 }
 
@@ -179,9 +239,15 @@ func writeMPackage(buf *bytes.Buffer, mPackage *types.MPackage) error {
 	if err := writeString(buf, mPackage.Version); err != nil {
 		return fmt.Errorf("failed to write mPackage version: %w", err)
 	}
+	if err := writeString(buf, mPackage.Alias); err != nil {
+		return fmt.Errorf("failed to write mPackage alias: %w", err)
+	}
 	if err := writeString(buf, mPackage.Dist.Shasum); err != nil {
 		return fmt.Errorf("failed to write mPackage shasum: %w", err)
 	}
+	if err := writeString(buf, mPackage.Dist.Integrity); err != nil {
+		return fmt.Errorf("failed to write mPackage integrity: %w", err)
+	}
 	if err := writeString(buf, mPackage.Dist.Tarball); err != nil {
 		return fmt.Errorf("failed to write mPackage tarball: %w", err)
 	}
@@ -201,7 +267,40 @@ func writeMPackage(buf *bytes.Buffer, mPackage *types.MPackage) error {
 	return nil
 }
 
-func WriteLockfile(buf *bytes.Buffer, lockfile types.Lockfile) error {
+func writeImporter(buf *bytes.Buffer, importer types.Importer) error {
+	if err := writeString(buf, importer.Path); err != nil {
+		return fmt.Errorf("failed to write importer path: %w", err)
+	}
+	if err := binary.Write(buf, binary.LittleEndian, int32(len(importer.Dependencies))); err != nil {
+		return fmt.Errorf("failed to write importer dependencies count: %w", err)
+	}
+	for _, pkg := range importer.Dependencies {
+		if err := writePackage(buf, pkg); err != nil {
+			return fmt.Errorf("failed to write importer dependency: %w", err)
+		}
+	}
+	return nil
+}
+
+func writeLockedOverride(buf *bytes.Buffer, override types.LockedOverride) error {
+	if err := writeString(buf, override.Selector); err != nil {
+		return fmt.Errorf("failed to write override selector: %w", err)
+	}
+	if err := writeString(buf, override.Version); err != nil {
+		return fmt.Errorf("failed to write override version: %w", err)
+	}
+	if err := binary.Write(buf, binary.LittleEndian, int32(len(override.Applied))); err != nil {
+		return fmt.Errorf("failed to write override applied count: %w", err)
+	}
+	for _, applied := range override.Applied {
+		if err := writeString(buf, applied); err != nil {
+			return fmt.Errorf("failed to write override applied package: %w", err)
+		}
+	}
+	return nil
+}
+
+func writeLockfileBody(buf *bytes.Buffer, lockfile types.Lockfile) error {
 	if err := binary.Write(buf, binary.LittleEndian, int32(len(lockfile.CoreDependencies))); err != nil {
 		return fmt.Errorf("failed to write core dependencies count: %w", err)
 	}
@@ -211,6 +310,24 @@ func WriteLockfile(buf *bytes.Buffer, lockfile types.Lockfile) error {
 		}
 	}
 
+	if err := binary.Write(buf, binary.LittleEndian, int32(len(lockfile.Importers))); err != nil {
+		return fmt.Errorf("failed to write importers count: %w", err)
+	}
+	for _, importer := range lockfile.Importers {
+		if err := writeImporter(buf, importer); err != nil {
+			return fmt.Errorf("failed to write importer: %w", err)
+		}
+	}
+
+	if err := binary.Write(buf, binary.LittleEndian, int32(len(lockfile.Overrides))); err != nil {
+		return fmt.Errorf("failed to write overrides count: %w", err)
+	}
+	for _, override := range lockfile.Overrides {
+		if err := writeLockedOverride(buf, override); err != nil {
+			return fmt.Errorf("failed to write override: %w", err)
+		}
+	}
+
 	if err := binary.Write(buf, binary.LittleEndian, int32(len(lockfile.Resolutions))); err != nil {
 		return fmt.Errorf("failed to write resolutions count: %w", err)
 	}
@@ -223,7 +340,7 @@ func WriteLockfile(buf *bytes.Buffer, lockfile types.Lockfile) error {
 	return nil
 }
 
-func readPackage(buf *bytes.Reader) (types.Package, error) {
+func readPackage(buf *bytes.Reader, version uint16) (types.Package, error) {
 	var pkg types.Package
 
 	var err error
@@ -233,11 +350,19 @@ func readPackage(buf *bytes.Reader) (types.Package, error) {
 	if pkg.Version, err = readString(buf); err != nil {
 		return pkg, fmt.Errorf("failed to read package version: %w", err)
 	}
+	if version != legacyVersion {
+		if pkg.Alias, err = readString(buf); err != nil {
+			return pkg, fmt.Errorf("failed to read package alias: %w", err)
+		}
+	}
 
 	return pkg, nil
 }
 
-func readMPackage(buf *bytes.Reader) (*types.MPackage, error) {
+func readMPackage(buf *bytes.Reader, depth int, version uint16) (*types.MPackage, error) {
+	if depth > maxMPackageDepth {
+		return nil, fmt.Errorf("mPackages are nested more than %d levels deep", maxMPackageDepth)
+	}
 	var mPackage types.MPackage
 
 	var err error
@@ -247,9 +372,19 @@ func readMPackage(buf *bytes.Reader) (*types.MPackage, error) {
 	if mPackage.Version, err = readString(buf); err != nil {
 		return nil, fmt.Errorf("failed to read mPackage version: %w", err)
 	}
+	if version != legacyVersion {
+		if mPackage.Alias, err = readString(buf); err != nil {
+			return nil, fmt.Errorf("failed to read mPackage alias: %w", err)
+		}
+	}
 	if mPackage.Dist.Shasum, err = readString(buf); err != nil {
 		return nil, fmt.Errorf("failed to read mPackage shasum: %w", err)
 	}
+	if version != legacyVersion {
+		if mPackage.Dist.Integrity, err = readString(buf); err != nil {
+			return nil, fmt.Errorf("failed to read mPackage integrity: %w", err)
+		}
+	}
 	if mPackage.Dist.Tarball, err = readString(buf); err != nil {
 		return nil, fmt.Errorf("failed to read mPackage tarball: %w", err)
 	}
@@ -257,13 +392,13 @@ func readMPackage(buf *bytes.Reader) (*types.MPackage, error) {
 		return nil, fmt.Errorf("failed to read mPackage file count: %w", err)
 	}
 
-	var depCount int32
-	if err := binary.Read(buf, binary.LittleEndian, &depCount); err != nil {
+	depCount, err := readCount(buf, sizeFor(version, mPackageSize, legacyMPackageSize))
+	if err != nil {
 		return nil, fmt.Errorf("failed to read mPackage dependencies count: %w", err)
 	}
 	mPackage.Dependencies = make([]*types.MPackage, depCount)
 	for i := 0; i < int(depCount); i++ {
-		dep, err := readMPackage(buf)
+		dep, err := readMPackage(buf, depth+1, version)
 		if err != nil {
 			return nil, fmt.Errorf("failed to read mPackage dependency: %w", err)
 		}
@@ -273,28 +408,96 @@ func readMPackage(buf *bytes.Reader) (*types.MPackage, error) {
 	return &mPackage, nil
 }
 
-func ReadLockfile(buf *bytes.Reader) (*types.Lockfile, error) {
+func readImporter(buf *bytes.Reader, version uint16) (types.Importer, error) {
+	var importer types.Importer
+
+	var err error
+	if importer.Path, err = readString(buf); err != nil {
+		return importer, fmt.Errorf("failed to read importer path: %w", err)
+	}
+	depCount, err := readCount(buf, packageSize)
+	if err != nil {
+		return importer, fmt.Errorf("failed to read importer dependencies count: %w", err)
+	}
+	importer.Dependencies = make([]types.Package, depCount)
+	for i := 0; i < int(depCount); i++ {
+		if importer.Dependencies[i], err = readPackage(buf, version); err != nil {
+			return importer, fmt.Errorf("failed to read importer dependency: %w", err)
+		}
+	}
+	return importer, nil
+}
+
+func readLockedOverride(buf *bytes.Reader) (types.LockedOverride, error) {
+	var override types.LockedOverride
+
+	var err error
+	if override.Selector, err = readString(buf); err != nil {
+		return override, fmt.Errorf("failed to read override selector: %w", err)
+	}
+	if override.Version, err = readString(buf); err != nil {
+		return override, fmt.Errorf("failed to read override version: %w", err)
+	}
+	appliedCount, err := readCount(buf, stringSize)
+	if err != nil {
+		return override, fmt.Errorf("failed to read override applied count: %w", err)
+	}
+	override.Applied = make([]string, appliedCount)
+	for i := 0; i < int(appliedCount); i++ {
+		if override.Applied[i], err = readString(buf); err != nil {
+			return override, fmt.Errorf("failed to read override applied package: %w", err)
+		}
+	}
+	return override, nil
+}
+
+func readLockfileBody(buf *bytes.Reader, version uint16) (*types.Lockfile, error) {
 	var lockfile types.Lockfile
 
 	var err error
-	var coreDepCount int32
-	if err = binary.Read(buf, binary.LittleEndian, &coreDepCount); err != nil {
+	coreDepCount, err := readCount(buf, sizeFor(version, packageSize, legacyPackageSize))
+	if err != nil {
 		return nil, fmt.Errorf("failed to read core dependencies count: %w", err)
 	}
 	lockfile.CoreDependencies = make([]types.Package, coreDepCount)
 	for i := 0; i < int(coreDepCount); i++ {
-		if lockfile.CoreDependencies[i], err = readPackage(buf); err != nil {
+		if lockfile.CoreDependencies[i], err = readPackage(buf, version); err != nil {
 			return nil, fmt.Errorf("failed to read core dependency package: %w", err)
 		}
 	}
 
-	var resCount int32
-	if err = binary.Read(buf, binary.LittleEndian, &resCount); err != nil {
+	// legacy lockfiles go straight on to the resolutions
+	if version != legacyVersion {
+		importerCount, err := readCount(buf, importerSize)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read importers count: %w", err)
+		}
+		lockfile.Importers = make([]types.Importer, importerCount)
+		for i := 0; i < int(importerCount); i++ {
+			if lockfile.Importers[i], err = readImporter(buf, version); err != nil {
+				return nil, fmt.Errorf("failed to read importer: %w", err)
+			}
+		}
+
+		overrideCount, err := readCount(buf, overrideSize)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read overrides count: %w", err)
+		}
+		lockfile.Overrides = make([]types.LockedOverride, overrideCount)
+		for i := 0; i < int(overrideCount); i++ {
+			if lockfile.Overrides[i], err = readLockedOverride(buf); err != nil {
+				return nil, fmt.Errorf("failed to read override: %w", err)
+			}
+		}
+	}
+
+	resCount, err := readCount(buf, sizeFor(version, mPackageSize, legacyMPackageSize))
+	if err != nil {
 		return nil, fmt.Errorf("failed to read resolutions count: %w", err)
 	}
 	lockfile.Resolutions = make([]types.MPackage, resCount)
 	for i := 0; i < int(resCount); i++ {
-		mPkg, err := readMPackage(buf)
+		mPkg, err := readMPackage(buf, 0, version)
 		if err != nil {
 			return nil, fmt.Errorf("failed to read resolution mPackage: %w", err)
 		}
@@ -304,7 +507,7 @@ func ReadLockfile(buf *bytes.Reader) (*types.Lockfile, error) {
 	return &lockfile, nil
 }
 
-func WriteConfig(buf *bytes.Buffer, conf *types.YapConfig) error {
+func writeConfigBody(buf *bytes.Buffer, conf *types.YapConfig) error {
 	if err := writeString(buf, conf.Registry); err != nil {
 		return fmt.Errorf("failed to write config registry: %w", err)
 	}
@@ -314,10 +517,19 @@ func WriteConfig(buf *bytes.Buffer, conf *types.YapConfig) error {
 	if err := writeString(buf, string(conf.LogLevel)); err != nil {
 		return fmt.Errorf("failed to write config log level: %w", err)
 	}
+	if err := binary.Write(buf, binary.LittleEndian, conf.CacheMaxSize); err != nil {
+		return fmt.Errorf("failed to write config cache max size: %w", err)
+	}
+	if err := writeString(buf, conf.LockfileExport); err != nil {
+		return fmt.Errorf("failed to write config lockfile export: %w", err)
+	}
+	if err := writeString(buf, conf.LockfileFormat); err != nil {
+		return fmt.Errorf("failed to write config lockfile format: %w", err)
+	}
 	return nil
 }
 
-func ReadConfig(buf *bytes.Reader) (*types.YapConfig, error) {
+func readConfigBody(buf *bytes.Reader) (*types.YapConfig, error) {
 	var conf types.YapConfig
 
 	var err error
@@ -330,6 +542,305 @@ func ReadConfig(buf *bytes.Reader) (*types.YapConfig, error) {
 	if conf.LogLevel, err = readString(buf); err != nil {
 		return nil, fmt.Errorf("failed to read config log level: %w", err)
 	}
+	// configs written before the cache size cap end here
+	if buf.Len() > 0 {
+		if err := binary.Read(buf, binary.LittleEndian, &conf.CacheMaxSize); err != nil {
+			return nil, fmt.Errorf("failed to read config cache max size: %w", err)
+		}
+	}
+	// and these before lockfile exports
+	if buf.Len() > 0 {
+		if conf.LockfileExport, err = readString(buf); err != nil {
+			return nil, fmt.Errorf("failed to read config lockfile export: %w", err)
+		}
+	}
+	// and these before text lockfiles
+	if buf.Len() > 0 {
+		if conf.LockfileFormat, err = readString(buf); err != nil {
+			return nil, fmt.Errorf("failed to read config lockfile format: %w", err)
+		}
+	}
 
 	return &conf, nil
 }
+
+func writeProjectsBody(buf *bytes.Buffer, projects []string) error {
+	if err := binary.Write(buf, binary.LittleEndian, int32(len(projects))); err != nil {
+		return fmt.Errorf("failed to write projects count: %w", err)
+	}
+	for _, project := range projects {
+		if err := writeString(buf, project); err != nil {
+			return fmt.Errorf("failed to write project: %w", err)
+		}
+	}
+	return nil
+}
+
+func readProjectsBody(buf *bytes.Reader) ([]string, error) {
+	count, err := readCount(buf, stringSize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read projects count: %w", err)
+	}
+	projects := make([]string, count)
+	for i := range projects {
+		var err error
+		if projects[i], err = readString(buf); err != nil {
+			return nil, fmt.Errorf("failed to read project: %w", err)
+		}
+	}
+	return projects, nil
+}
+
+func writeStoreIndexBody(buf *bytes.Buffer, index *types.StoreIndex) error {
+	for _, str := range []string{index.Name, index.Version, index.Tarball, index.Integrity} {
+		if err := writeString(buf, str); err != nil {
+			return fmt.Errorf("failed to write store index header: %w", err)
+		}
+	}
+	if err := binary.Write(buf, binary.LittleEndian, index.Complete); err != nil {
+		return fmt.Errorf("failed to write store index completion: %w", err)
+	}
+	if err := binary.Write(buf, binary.LittleEndian, int32(len(index.Files))); err != nil {
+		return fmt.Errorf("failed to write store index files count: %w", err)
+	}
+	for _, file := range index.Files {
+		if err := writeString(buf, file.Path); err != nil {
+			return fmt.Errorf("failed to write store index file path: %w", err)
+		}
+		if err := binary.Write(buf, binary.LittleEndian, file.Size); err != nil {
+			return fmt.Errorf("failed to write store index file size: %w", err)
+		}
+		if err := writeString(buf, file.Integrity); err != nil {
+			return fmt.Errorf("failed to write store index file integrity: %w", err)
+		}
+	}
+	return nil
+}
+
+func readStoreIndexBody(buf *bytes.Reader) (*types.StoreIndex, error) {
+	var index types.StoreIndex
+	for _, str := range []*string{&index.Name, &index.Version, &index.Tarball, &index.Integrity} {
+		var err error
+		if *str, err = readString(buf); err != nil {
+			return nil, fmt.Errorf("failed to read store index header: %w", err)
+		}
+	}
+	if err := binary.Read(buf, binary.LittleEndian, &index.Complete); err != nil {
+		return nil, fmt.Errorf("failed to read store index completion: %w", err)
+	}
+	count, err := readCount(buf, storeFileSize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read store index files count: %w", err)
+	}
+	index.Files = make([]types.StoreFile, count)
+	for i := range index.Files {
+		file := &index.Files[i]
+		var err error
+		if file.Path, err = readString(buf); err != nil {
+			return nil, fmt.Errorf("failed to read store index file path: %w", err)
+		}
+		if err := binary.Read(buf, binary.LittleEndian, &file.Size); err != nil {
+			return nil, fmt.Errorf("failed to read store index file size: %w", err)
+		}
+		if file.Integrity, err = readString(buf); err != nil {
+			return nil, fmt.Errorf("failed to read store index file integrity: %w", err)
+		}
+	}
+	return &index, nil
+}
+
+func writeCacheIndexBody(buf *bytes.Buffer, index *types.CacheIndex) error {
+	if err := binary.Write(buf, binary.LittleEndian, index.Size); err != nil {
+		return fmt.Errorf("failed to write cache index size: %w", err)
+	}
+	if err := binary.Write(buf, binary.LittleEndian, int32(len(index.Entries))); err != nil {
+		return fmt.Errorf("failed to write cache index entries count: %w", err)
+	}
+	for _, entry := range index.Entries {
+		if err := writeString(buf, entry.Name); err != nil {
+			return fmt.Errorf("failed to write cache index entry name: %w", err)
+		}
+		for _, value := range []int64{entry.Offset, entry.Length, entry.FetchedAt, entry.LastUsed} {
+			if err := binary.Write(buf, binary.LittleEndian, value); err != nil {
+				return fmt.Errorf("failed to write cache index entry: %w", err)
+			}
+		}
+	}
+	return nil
+}
+
+func readCacheIndexBody(buf *bytes.Reader) (*types.CacheIndex, error) {
+	var index types.CacheIndex
+	if err := binary.Read(buf, binary.LittleEndian, &index.Size); err != nil {
+		return nil, fmt.Errorf("failed to read cache index size: %w", err)
+	}
+	count, err := readCount(buf, cacheIndexEntrySize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cache index entries count: %w", err)
+	}
+	index.Entries = make([]types.CacheIndexEntry, count)
+	for i := range index.Entries {
+		entry := &index.Entries[i]
+		if entry.Name, err = readString(buf); err != nil {
+			return nil, fmt.Errorf("failed to read cache index entry name: %w", err)
+		}
+		for _, value := range []*int64{&entry.Offset, &entry.Length, &entry.FetchedAt, &entry.LastUsed} {
+			if err := binary.Read(buf, binary.LittleEndian, value); err != nil {
+				return nil, fmt.Errorf("failed to read cache index entry: %w", err)
+			}
+		}
+	}
+	return &index, nil
+}
+
+// the files themselves are the bodies above between a header and a checksum, see binaryFormat
+
+func WriteMetadata(buf *bytes.Buffer, metadata types.Metadata) error {
+	start := buf.Len()
+	metadataFormat.writeHeader(buf)
+	if err := writeMetadataBody(buf, metadata); err != nil {
+		return err
+	}
+	metadataFormat.writeChecksum(buf, start)
+	return nil
+}
+
+func ReadMetadata(buf *bytes.Reader) (*types.Metadata, error) {
+	body, version, err := metadataFormat.open(buf)
+	if err != nil {
+		return nil, err
+	}
+	metadata, err := readMetadataBody(body, version)
+	if err != nil {
+		return nil, metadataFormat.unreadable(err)
+	}
+	if err := metadataFormat.finish(body); err != nil {
+		return nil, err
+	}
+	return metadata, nil
+}
+
+func WriteLockfile(buf *bytes.Buffer, lockfile types.Lockfile) error {
+	start := buf.Len()
+	lockfileFormat.writeHeader(buf)
+	if err := writeLockfileBody(buf, lockfile); err != nil {
+		return err
+	}
+	lockfileFormat.writeChecksum(buf, start)
+	return nil
+}
+
+func ReadLockfile(buf *bytes.Reader) (*types.Lockfile, error) {
+	body, version, err := lockfileFormat.open(buf)
+	if err != nil {
+		return nil, err
+	}
+	lockfile, err := readLockfileBody(body, version)
+	if err != nil {
+		return nil, lockfileFormat.unreadable(err)
+	}
+	if err := lockfileFormat.finish(body); err != nil {
+		return nil, err
+	}
+	return lockfile, nil
+}
+
+func WriteConfig(buf *bytes.Buffer, conf *types.YapConfig) error {
+	start := buf.Len()
+	configFormat.writeHeader(buf)
+	if err := writeConfigBody(buf, conf); err != nil {
+		return err
+	}
+	configFormat.writeChecksum(buf, start)
+	return nil
+}
+
+func ReadConfig(buf *bytes.Reader) (*types.YapConfig, error) {
+	body, _, err := configFormat.open(buf)
+	if err != nil {
+		return nil, err
+	}
+	conf, err := readConfigBody(body)
+	if err != nil {
+		return nil, configFormat.unreadable(err)
+	}
+	if err := configFormat.finish(body); err != nil {
+		return nil, err
+	}
+	return conf, nil
+}
+
+func WriteProjects(buf *bytes.Buffer, projects []string) error {
+	start := buf.Len()
+	projectsFormat.writeHeader(buf)
+	if err := writeProjectsBody(buf, projects); err != nil {
+		return err
+	}
+	projectsFormat.writeChecksum(buf, start)
+	return nil
+}
+
+func ReadProjects(buf *bytes.Reader) ([]string, error) {
+	body, _, err := projectsFormat.open(buf)
+	if err != nil {
+		return nil, err
+	}
+	projects, err := readProjectsBody(body)
+	if err != nil {
+		return nil, projectsFormat.unreadable(err)
+	}
+	if err := projectsFormat.finish(body); err != nil {
+		return nil, err
+	}
+	return projects, nil
+}
+
+func WriteStoreIndex(buf *bytes.Buffer, index *types.StoreIndex) error {
+	start := buf.Len()
+	storeIndexFormat.writeHeader(buf)
+	if err := writeStoreIndexBody(buf, index); err != nil {
+		return err
+	}
+	storeIndexFormat.writeChecksum(buf, start)
+	return nil
+}
+
+func ReadStoreIndex(buf *bytes.Reader) (*types.StoreIndex, error) {
+	body, _, err := storeIndexFormat.open(buf)
+	if err != nil {
+		return nil, err
+	}
+	index, err := readStoreIndexBody(body)
+	if err != nil {
+		return nil, storeIndexFormat.unreadable(err)
+	}
+	if err := storeIndexFormat.finish(body); err != nil {
+		return nil, err
+	}
+	return index, nil
+}
+
+func WriteCacheIndex(buf *bytes.Buffer, index *types.CacheIndex) error {
+	start := buf.Len()
+	cacheIndexFormat.writeHeader(buf)
+	if err := writeCacheIndexBody(buf, index); err != nil {
+		return err
+	}
+	cacheIndexFormat.writeChecksum(buf, start)
+	return nil
+}
+
+func ReadCacheIndex(buf *bytes.Reader) (*types.CacheIndex, error) {
+	body, _, err := cacheIndexFormat.open(buf)
+	if err != nil {
+		return nil, err
+	}
+	index, err := readCacheIndexBody(body)
+	if err != nil {
+		return nil, cacheIndexFormat.unreadable(err)
+	}
+	if err := cacheIndexFormat.finish(body); err != nil {
+		return nil, err
+	}
+	return index, nil
+}
@@ -0,0 +1,220 @@
+package utils
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/Eyepan/yap/src/types"
+)
+
+// the seeds are written by the encoders, so the fuzzer starts from valid files and mutates them from there
+
+func seedMetadata() types.Metadata {
+	metadata := types.Metadata{Name: "@scope/pkg", Versions: map[string]types.VersionMetadata{
+		"1.0.0": {
+			Name:         "@scope/pkg",
+			Version:      "1.0.0",
+			Dist:         types.Dist{Shasum: "abc", Integrity: "sha512-AAA=", Tarball: "https://registry.npmjs.org/@scope/pkg/-/pkg-1.0.0.tgz", FileCount: 3},
+			Dependencies: types.Dependencies{"left-pad": "^1.0.0", "alias": "npm:right-pad@^2"},
+		},
+		"2.0.0-beta.1": {Name: "@scope/pkg", Version: "2.0.0-beta.1", Dependencies: types.Dependencies{}},
+	}}
+	metadata.DistTags.Latest = "1.0.0"
+	metadata.DistTags.Next = "2.0.0-beta.1"
+	return metadata
+}
+
+func seedLockfile() types.Lockfile {
+	leaf := &types.MPackage{Name: "right-pad", Version: "^2", Alias: "alias", Dependencies: []*types.MPackage{}}
+	return types.Lockfile{
+		CoreDependencies: []types.Package{{Name: "@scope/pkg", Version: "1.0.0"}, {Name: "right-pad", Version: "2.1.0", Alias: "alias"}},
+		Importers: []types.Importer{
+			{Path: "packages/a", Dependencies: []types.Package{{Name: "left-pad", Version: "1.3.0"}}},
+			{Path: "packages/b", Dependencies: []types.Package{}},
+		},
+		Overrides: []types.LockedOverride{
+			{Selector: "@scope/pkg>left-pad@^1", Version: "1.3.0", Applied: []string{"left-pad@1.3.0"}},
+			{Selector: "right-pad", Version: "2.1.0", Applied: []string{}},
+		},
+		Resolutions: []types.MPackage{
+			{
+				Name:         "@scope/pkg",
+				Version:      "1.0.0",
+				Dist:         types.Dist{Shasum: "abc", Integrity: "sha512-AAA=", Tarball: "https://registry.npmjs.org/@scope/pkg/-/pkg-1.0.0.tgz", FileCount: 3},
+				Dependencies: []*types.MPackage{leaf},
+			},
+			{Name: "right-pad", Version: "2.1.0", Dependencies: []*types.MPackage{}},
+		},
+	}
+}
+
+func seedConfigs() []*types.YapConfig {
+	return []*types.YapConfig{
+		{Registry: "https://registry.npmjs.org", LogLevel: "warn"},
+		{Registry: "http://localhost:4873", AuthToken: "token", LogLevel: "debug", CacheMaxSize: 1 << 30, LockfileExport: "npm", LockfileFormat: "both"},
+	}
+}
+
+func encodeMetadata(t testing.TB, metadata types.Metadata) []byte {
+	var buf bytes.Buffer
+	if err := WriteMetadata(&buf, metadata); err != nil {
+		t.Fatalf("failed to write metadata: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func encodeLockfile(t testing.TB, lockfile types.Lockfile) []byte {
+	var buf bytes.Buffer
+	if err := WriteLockfile(&buf, lockfile); err != nil {
+		t.Fatalf("failed to write lockfile: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func encodeConfig(t testing.TB, conf *types.YapConfig) []byte {
+	var buf bytes.Buffer
+	if err := WriteConfig(&buf, conf); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func encodeMetadataEntry(t testing.TB, metadata types.Metadata) []byte {
+	var buf bytes.Buffer
+	if err := WriteMetadataEntry(&buf, metadata); err != nil {
+		t.Fatalf("failed to write metadata entry: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func FuzzReadMetadata(f *testing.F) {
+	seed := seedMetadata()
+	data := encodeMetadata(f, seed)
+	decoded, err := ReadMetadata(bytes.NewReader(data))
+	if err != nil {
+		f.Fatalf("failed to read the seed back: %v", err)
+	}
+	if !reflect.DeepEqual(*decoded, seed) {
+		f.Fatalf("seed changed on the way through: got %+v, want %+v", *decoded, seed)
+	}
+	f.Add(data)
+	f.Add(encodeMetadata(f, types.Metadata{Versions: map[string]types.VersionMetadata{}}))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		metadata, err := ReadMetadata(bytes.NewReader(data))
+		if err != nil {
+			return
+		}
+		again, err := ReadMetadata(bytes.NewReader(encodeMetadata(t, *metadata)))
+		if err != nil {
+			t.Fatalf("failed to read what was written: %v", err)
+		}
+		if !reflect.DeepEqual(again, metadata) {
+			t.Fatalf("metadata changed on the way through: got %+v, want %+v", again, metadata)
+		}
+	})
+}
+
+func FuzzReadLockfile(f *testing.F) {
+	seed := seedLockfile()
+	data := encodeLockfile(f, seed)
+	decoded, err := ReadLockfile(bytes.NewReader(data))
+	if err != nil {
+		f.Fatalf("failed to read the seed back: %v", err)
+	}
+	if !reflect.DeepEqual(*decoded, seed) {
+		f.Fatalf("seed changed on the way through: got %+v, want %+v", *decoded, seed)
+	}
+	f.Add(data)
+	f.Add(encodeLockfile(f, types.Lockfile{}))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		lockfile, err := ReadLockfile(bytes.NewReader(data))
+		if err != nil {
+			return
+		}
+		// legacy files have no importers or overrides, which come back empty instead of nil, so the
+		// comparison starts from the first rewrite
+		first, err := ReadLockfile(bytes.NewReader(encodeLockfile(t, *lockfile)))
+		if err != nil {
+			t.Fatalf("failed to read what was written: %v", err)
+		}
+		again, err := ReadLockfile(bytes.NewReader(encodeLockfile(t, *first)))
+		if err != nil {
+			t.Fatalf("failed to read what was written: %v", err)
+		}
+		if !reflect.DeepEqual(again, first) {
+			t.Fatalf("lockfile changed on the way through: got %+v, want %+v", again, first)
+		}
+	})
+}
+
+func FuzzReadConfig(f *testing.F) {
+	for _, seed := range seedConfigs() {
+		data := encodeConfig(f, seed)
+		decoded, err := ReadConfig(bytes.NewReader(data))
+		if err != nil {
+			f.Fatalf("failed to read the seed back: %v", err)
+		}
+		if !reflect.DeepEqual(decoded, seed) {
+			f.Fatalf("seed changed on the way through: got %+v, want %+v", decoded, seed)
+		}
+		f.Add(data)
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		conf, err := ReadConfig(bytes.NewReader(data))
+		if err != nil {
+			return
+		}
+		again, err := ReadConfig(bytes.NewReader(encodeConfig(t, conf)))
+		if err != nil {
+			t.Fatalf("failed to read what was written: %v", err)
+		}
+		if !reflect.DeepEqual(again, conf) {
+			t.Fatalf("config changed on the way through: got %+v, want %+v", again, conf)
+		}
+	})
+}
+
+func FuzzReadMetadataEntry(f *testing.F) {
+	seed := seedMetadata()
+	data := encodeMetadataEntry(f, seed)
+	lazy, err := ReadMetadataEntry(seed.Name, data)
+	if err != nil {
+		f.Fatalf("failed to read the seed back: %v", err)
+	}
+	decoded, err := lazy.Metadata()
+	if err != nil {
+		f.Fatalf("failed to decode the versions of the seed: %v", err)
+	}
+	if !reflect.DeepEqual(*decoded, seed) {
+		f.Fatalf("seed changed on the way through: got %+v, want %+v", *decoded, seed)
+	}
+	f.Add(data)
+	f.Add(encodeMetadataEntry(f, types.Metadata{Versions: map[string]types.VersionMetadata{}}))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		lazy, err := ReadMetadataEntry("pkg", data)
+		if err != nil {
+			return
+		}
+		// a version that can't be decoded is reported when it's asked for, which mustn't panic either
+		metadata, err := lazy.Metadata()
+		if err != nil {
+			return
+		}
+		again, err := ReadMetadataEntry("pkg", encodeMetadataEntry(t, *metadata))
+		if err != nil {
+			t.Fatalf("failed to read what was written: %v", err)
+		}
+		againMetadata, err := again.Metadata()
+		if err != nil {
+			t.Fatalf("failed to decode the versions of what was written: %v", err)
+		}
+		if !reflect.DeepEqual(againMetadata, metadata) {
+			t.Fatalf("metadata changed on the way through: got %+v, want %+v", againMetadata, metadata)
+		}
+	})
+}
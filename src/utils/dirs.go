@@ -16,6 +16,24 @@ func GetCacheDir() (string, error) {
 	return cacheDir, nil
 }
 
+// GetGitCacheDir returns the directory bare clones of git dependencies are kept in
+func GetGitCacheDir() (string, error) {
+	storeDir, err := GetStoreDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(storeDir, ".yap_git"), nil
+}
+
+// GetDlxDir returns the directory the throwaway installs of `yap dlx` are cached in
+func GetDlxDir() (string, error) {
+	storeDir, err := GetStoreDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(storeDir, ".yap_dlx"), nil
+}
+
 func GetStoreDir() (string, error) {
 	homeDir, err := os.UserHomeDir()
 	if err != nil {
@@ -25,6 +43,77 @@ func GetStoreDir() (string, error) {
 	return storeDir, nil
 }
 
+// GetPackageStoreDir returns the directory a package version gets extracted to inside the store
+func GetPackageStoreDir(name, version string) (string, error) {
+	storeDir, err := GetStoreDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(storeDir, EncodePackageID(name, version)), nil
+}
+
+// GetPackageIndexFile returns the file describing what was extracted for a package version, kept outside
+// of the package directory so it doesn't end up in node_modules
+func GetPackageIndexFile(name, version string) (string, error) {
+	storeDir, err := GetStoreDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(storeDir, ".yap_index", EncodePackageID(name, version)), nil
+}
+
+// GetPackageLockFile returns the file that's locked while a package version is written to the store
+func GetPackageLockFile(name, version string) (string, error) {
+	return GetStoreLockFile(EncodePackageID(name, version))
+}
+
+// GetMetadataLockFile returns the file that's locked while the packument of a package is fetched into the cache
+func GetMetadataLockFile(name string) (string, error) {
+	return GetStoreLockFile(filepath.Join("metadata", EncodePackageName(name)))
+}
+
+// GetGitLockFile returns the file that's locked while the mirror repoName in the git cache is cloned or fetched
+func GetGitLockFile(repoName string) (string, error) {
+	return GetStoreLockFile(filepath.Join("git", repoName))
+}
+
+// GetStoreLockFile returns the lock file for the store directory id, which is what GetPackageStoreDir names
+// package versions
+func GetStoreLockFile(id string) (string, error) {
+	storeDir, err := GetStoreDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(storeDir, ".yap_locks", id+".lock"), nil
+}
+
+// GetStoreTempDir returns the directory packages are extracted in before they're moved into the store
+func GetStoreTempDir() (string, error) {
+	storeDir, err := GetStoreDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(storeDir, ".yap_tmp"), nil
+}
+
+// GetStoreLayoutFile returns the file recording which layout the store was migrated to
+func GetStoreLayoutFile() (string, error) {
+	storeDir, err := GetStoreDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(storeDir, ".yap_layout"), nil
+}
+
+// GetProjectsFile returns the file listing the projects that link packages from the store
+func GetProjectsFile() (string, error) {
+	storeDir, err := GetStoreDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(storeDir, ".yap_projects"), nil
+}
+
 func GetGlobalConfigDir() (string, error) {
 	homeDir, err := os.UserHomeDir()
 	if err != nil {
@@ -0,0 +1,26 @@
+package utils
+
+import "path"
+
+// MatchSegments matches the segments of a slash separated path against glob segments, where ** matches any
+// number of directories
+func MatchSegments(pattern, segments []string) bool {
+	if len(pattern) == 0 {
+		return len(segments) == 0
+	}
+	if pattern[0] == "**" {
+		for i := 0; i <= len(segments); i++ {
+			if MatchSegments(pattern[1:], segments[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(segments) == 0 {
+		return false
+	}
+	if ok, err := path.Match(pattern[0], segments[0]); err != nil || !ok {
+		return false
+	}
+	return MatchSegments(pattern[1:], segments[1:])
+}
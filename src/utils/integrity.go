@@ -0,0 +1,75 @@
+package utils
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// ComputeIntegrity returns the subresource integrity string npm uses for tarballs
+func ComputeIntegrity(data []byte) string {
+	sum := sha512.Sum512(data)
+	return "sha512-" + base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// ComputeShasum returns the legacy sha1 hex digest npm still sends along with the integrity
+func ComputeShasum(data []byte) string {
+	sum := sha1.Sum(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// the hashes a subresource integrity string can hold, weakest first
+var integrityAlgorithms = []string{"sha1", "sha256", "sha384", "sha512"}
+
+func digest(algorithm string, data []byte) []byte {
+	switch algorithm {
+	case "sha1":
+		sum := sha1.Sum(data)
+		return sum[:]
+	case "sha256":
+		sum := sha256.Sum256(data)
+		return sum[:]
+	case "sha384":
+		sum := sha512.Sum384(data)
+		return sum[:]
+	default:
+		sum := sha512.Sum512(data)
+		return sum[:]
+	}
+}
+
+// CheckIntegrity checks data against the integrity a lockfile or packument has for it. Like browsers do, only
+// the strongest hashes of the integrity string count, and any of them matching is enough. Without an integrity
+// the sha1 shasum of older packages is checked, and with neither there's nothing to check
+func CheckIntegrity(data []byte, integrity, shasum string) error {
+	byAlgorithm := make(map[string][]string)
+	for _, token := range strings.Fields(integrity) {
+		algorithm, hash, ok := strings.Cut(token, "-")
+		if !ok {
+			continue
+		}
+		hash, _, _ = strings.Cut(hash, "?")
+		byAlgorithm[algorithm] = append(byAlgorithm[algorithm], hash)
+	}
+	for i := len(integrityAlgorithms) - 1; i >= 0; i-- {
+		hashes, ok := byAlgorithm[integrityAlgorithms[i]]
+		if !ok {
+			continue
+		}
+		actual := base64.StdEncoding.EncodeToString(digest(integrityAlgorithms[i], data))
+		for _, hash := range hashes {
+			if hash == actual {
+				return nil
+			}
+		}
+		return fmt.Errorf("integrity mismatch, expected %s but got %s", integrity, ComputeIntegrity(data))
+	}
+	if shasum != "" && !strings.EqualFold(shasum, ComputeShasum(data)) {
+		return fmt.Errorf("shasum mismatch, expected %s but got %s", shasum, ComputeShasum(data))
+	}
+	return nil
+}
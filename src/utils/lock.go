@@ -3,24 +3,68 @@ package utils
 import (
 	"bytes"
 	"fmt"
+	"log/slog"
 	"os"
 	"path/filepath"
+	"sort"
 
 	"github.com/Eyepan/yap/src/types"
 )
 
+// lockfile formats, picked with `yap config set lockfileFormat`
+const (
+	LockfileFormatBinary = "binary" // yap.lockb, what projects without a lockfile get
+	LockfileFormatText   = "text"   // yap.lock
+	LockfileFormatBoth   = "both"
+)
+
+const (
+	binaryLockfileName = "yap.lockb"
+	textLockfileName   = "yap.lock"
+)
+
+// LockfileFormats are the values lockfileFormat can be set to
+var LockfileFormats = []string{LockfileFormatBinary, LockfileFormatText, LockfileFormatBoth}
+
+// LockfileNames are the files a lockfile can be written to
+var LockfileNames = []string{binaryLockfileName, textLockfileName}
+
 func ReadLock() (*types.Lockfile, error) {
-	lockFilePath := filepath.Join(".", "yap.lockb")
+	return ReadLockFrom(".")
+}
 
-	if _, err := os.Stat(lockFilePath); err != nil {
-		return nil, fmt.Errorf("something went wrong while reading the lockfile: %w", err)
+// ReadLockFrom reads the lockfile of the project in dir. yap.lock wins over yap.lockb, it's the one that's
+// reviewed and merged by hand, and a yap.lockb next to it that says something else is reported
+func ReadLockFrom(dir string) (*types.Lockfile, error) {
+	data, err := os.ReadFile(filepath.Join(dir, textLockfileName))
+	if os.IsNotExist(err) {
+		return readBinaryLock(dir)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("something went wrong while reading %s: %w", textLockfileName, err)
+	}
+	lockfile, err := ReadTextLockfile(data)
+	if err != nil {
+		return nil, fmt.Errorf("something went wrong while reading %s: %w", textLockfileName, err)
 	}
 
-	data, err := os.ReadFile(lockFilePath)
+	if binaryLockfile, err := readBinaryLock(dir); err == nil {
+		var fromText, fromBinary bytes.Buffer
+		WriteTextLockfile(&fromText, *lockfile)
+		WriteTextLockfile(&fromBinary, *binaryLockfile)
+		if !bytes.Equal(fromText.Bytes(), fromBinary.Bytes()) {
+			slog.Warn(fmt.Sprintf("%s and %s disagree, using %s. The next install writes both again", textLockfileName, binaryLockfileName, textLockfileName))
+		}
+	}
+	return lockfile, nil
+}
+
+func readBinaryLock(dir string) (*types.Lockfile, error) {
+	data, err := os.ReadFile(filepath.Join(dir, binaryLockfileName))
 	if err != nil {
 		return nil, fmt.Errorf("something went wrong while reading the lockfile: %w", err)
-
 	}
+
 	buf := bytes.NewReader(data)
 	lockBin, err := ReadLockfile(buf)
 	if err != nil {
@@ -29,30 +73,103 @@ func ReadLock() (*types.Lockfile, error) {
 	return lockBin, nil
 }
 
-func DoesLockfileExist() (bool, error) {
-	lockFile := filepath.Join(".", "yap.lockb")
+// LockfileExistsIn reports whether the project in dir has a lockfile in either format
+func LockfileExistsIn(dir string) bool {
+	for _, name := range LockfileNames {
+		if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+			return true
+		}
+	}
+	return false
+}
 
-	if _, err := os.Stat(lockFile); err != nil {
-		return false, fmt.Errorf("something went wrong while reading the lockfile: %w", err)
+func DoesLockfileExist() (bool, error) {
+	if !LockfileExistsIn(".") {
+		return false, fmt.Errorf("something went wrong while reading the lockfile: neither %s nor %s exist", binaryLockfileName, textLockfileName)
 	}
 	return true, nil
 }
 
-func WriteLock(lockBin types.Lockfile) error {
-	lockFilePath := filepath.Join(".", "yap.lockb")
+// WriteLock writes the lockfile in format. A single format replaces the lockfile in the other one, so a
+// project that switches formats doesn't keep a stale copy that drifts apart, and both writes both. Without a
+// format the project keeps the lockfiles it has, or gets a yap.lockb when it has none
+func WriteLock(lockBin types.Lockfile, format string) error {
+	writeBinary, writeText := fileExists(binaryLockfileName), fileExists(textLockfileName)
+	switch format {
+	case "":
+		writeBinary = writeBinary || !writeText
+	case LockfileFormatBinary:
+		writeBinary, writeText = true, false
+	case LockfileFormatText:
+		writeBinary, writeText = false, true
+	case LockfileFormatBoth:
+		writeBinary, writeText = true, true
+	default:
+		return fmt.Errorf("unknown lockfile format %s", format)
+	}
 
 	var buf bytes.Buffer
-	if err := WriteLockfile(&buf, lockBin); err != nil {
-		return fmt.Errorf("failed to write lockfile: %w", err)
+	if writeBinary {
+		if err := WriteLockfile(&buf, lockBin); err != nil {
+			return fmt.Errorf("failed to write lockfile: %w", err)
+		}
+		if err := os.WriteFile(binaryLockfileName, buf.Bytes(), 0644); err != nil {
+			return err
+		}
 	}
-	file, err := os.Create(lockFilePath)
-	if err != nil {
-		return err
+
+	buf.Reset()
+	if writeText {
+		WriteTextLockfile(&buf, lockBin)
+		if err := os.WriteFile(textLockfileName, buf.Bytes(), 0644); err != nil {
+			return err
+		}
 	}
-	defer file.Close()
 
-	if _, err := file.Write(buf.Bytes()); err != nil {
-		return err
+	// only once the new lockfile is written, so the project is never left without one
+	for name, write := range map[string]bool{binaryLockfileName: writeBinary, textLockfileName: writeText} {
+		if write {
+			continue
+		}
+		if err := os.Remove(name); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove %s: %w", name, err)
+		}
 	}
 	return nil
 }
+
+// SortPackages orders a dependency list the way lockfiles keep it, by the name it's installed under and then
+// by version, so two aliases of the same package come out in the same order every time
+func SortPackages(packages []types.Package) {
+	sort.Slice(packages, func(i, j int) bool {
+		return lessDependency(packages[i].Name, packages[i].Alias, packages[i].Version, packages[j].Name, packages[j].Alias, packages[j].Version)
+	})
+}
+
+// SortDependencies is SortPackages for the dependencies of a resolved package
+func SortDependencies(dependencies []*types.MPackage) {
+	sort.Slice(dependencies, func(i, j int) bool {
+		return lessDependency(dependencies[i].Name, dependencies[i].Alias, dependencies[i].Version, dependencies[j].Name, dependencies[j].Alias, dependencies[j].Version)
+	})
+}
+
+func lessDependency(nameA, aliasA, versionA, nameB, aliasB, versionB string) bool {
+	if aliasA == "" {
+		aliasA = nameA
+	}
+	if aliasB == "" {
+		aliasB = nameB
+	}
+	if aliasA != aliasB {
+		return aliasA < aliasB
+	}
+	if nameA != nameB {
+		return nameA < nameB
+	}
+	return versionA < versionB
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
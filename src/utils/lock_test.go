@@ -0,0 +1,89 @@
+package utils
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/Eyepan/yap/src/types"
+)
+
+// inTempDir runs the test from an empty directory, since lockfiles are written to the working directory
+func inTempDir(t *testing.T) {
+	t.Helper()
+	previous, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("failed to get the working directory: %v", err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatalf("failed to change directory: %v", err)
+	}
+	t.Cleanup(func() { os.Chdir(previous) })
+}
+
+func assertLockfiles(t *testing.T, binary, text bool) {
+	t.Helper()
+	if fileExists(binaryLockfileName) != binary || fileExists(textLockfileName) != text {
+		t.Fatalf("got %s: %t and %s: %t, want %t and %t", binaryLockfileName, fileExists(binaryLockfileName), textLockfileName, fileExists(textLockfileName), binary, text)
+	}
+}
+
+func TestWriteLockSwitchesFormats(t *testing.T) {
+	inTempDir(t)
+	lockfile := seedLockfile()
+
+	if err := WriteLock(lockfile, ""); err != nil {
+		t.Fatalf("failed to write the lockfile: %v", err)
+	}
+	assertLockfiles(t, true, false)
+
+	// switching to text replaces yap.lockb instead of keeping it around to drift apart
+	if err := WriteLock(lockfile, LockfileFormatText); err != nil {
+		t.Fatalf("failed to write the lockfile: %v", err)
+	}
+	assertLockfiles(t, false, true)
+	read, err := ReadLock()
+	if err != nil {
+		t.Fatalf("failed to read the lockfile: %v", err)
+	}
+	var got, want bytes.Buffer
+	WriteTextLockfile(&got, *read)
+	WriteTextLockfile(&want, lockfile)
+	if !bytes.Equal(got.Bytes(), want.Bytes()) {
+		t.Errorf("read\n%s\nfrom %s, want\n%s", got.String(), textLockfileName, want.String())
+	}
+
+	// without a format the project keeps what it has
+	if err := WriteLock(lockfile, ""); err != nil {
+		t.Fatalf("failed to write the lockfile: %v", err)
+	}
+	assertLockfiles(t, false, true)
+
+	if err := WriteLock(lockfile, LockfileFormatBoth); err != nil {
+		t.Fatalf("failed to write the lockfile: %v", err)
+	}
+	assertLockfiles(t, true, true)
+
+	if err := WriteLock(lockfile, LockfileFormatBinary); err != nil {
+		t.Fatalf("failed to write the lockfile: %v", err)
+	}
+	assertLockfiles(t, true, false)
+}
+
+func TestSortDependenciesOrdersAliases(t *testing.T) {
+	want := []string{"react-17:react@17.0.2", "react-18:react@18.3.1", ":scheduler@0.23.0"}
+	for _, dependencies := range [][]*types.MPackage{
+		{{Name: "react", Version: "18.3.1", Alias: "react-18"}, {Name: "scheduler", Version: "0.23.0"}, {Name: "react", Version: "17.0.2", Alias: "react-17"}},
+		{{Name: "scheduler", Version: "0.23.0"}, {Name: "react", Version: "17.0.2", Alias: "react-17"}, {Name: "react", Version: "18.3.1", Alias: "react-18"}},
+	} {
+		SortDependencies(dependencies)
+		var got []string
+		for _, dep := range dependencies {
+			got = append(got, dep.Alias+":"+dep.Name+"@"+dep.Version)
+		}
+		if strings.Join(got, " ") != strings.Join(want, " ") {
+			t.Errorf("sorted to %v, want %v", got, want)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"sort"
+
+	"github.com/Eyepan/yap/src/types"
+)
+
+// the table entry of a version: its key, then where its encoding starts after the table and how long it is
+const versionTableEntrySize = stringSize + 4 + 4
+
+// LazyMetadata is a packument from the metadata cache. Only the list of versions is read up front,
+// each version is decoded when it's asked for, since resolving a range needs one of them
+type LazyMetadata struct {
+	Name     string
+	DistTags struct {
+		Latest string
+		Next   string
+	}
+	versions map[string][]byte
+}
+
+// WriteMetadataEntry writes a packument the way the metadata cache keeps it: the dist-tags, a table of the
+// versions with where each of them is, and then the versions themselves
+func WriteMetadataEntry(buf *bytes.Buffer, metadata types.Metadata) error {
+	if err := writeString(buf, metadata.DistTags.Latest); err != nil {
+		return fmt.Errorf("failed to write metadata dist tag latest: %w", err)
+	}
+	if err := writeString(buf, metadata.DistTags.Next); err != nil {
+		return fmt.Errorf("failed to write metadata dist tag next: %w", err)
+	}
+
+	keys := make([]string, 0, len(metadata.Versions))
+	for key := range metadata.Versions {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var versions bytes.Buffer
+	if err := binary.Write(buf, binary.LittleEndian, int32(len(keys))); err != nil {
+		return fmt.Errorf("failed to write versions count: %w", err)
+	}
+	for _, key := range keys {
+		start := versions.Len()
+		if err := writeVersionMetadata(&versions, metadata.Versions[key]); err != nil {
+			return fmt.Errorf("failed to write version metadata: %w", err)
+		}
+		if err := writeString(buf, key); err != nil {
+			return fmt.Errorf("failed to write version key: %w", err)
+		}
+		for _, value := range []uint32{uint32(start), uint32(versions.Len() - start)} {
+			if err := binary.Write(buf, binary.LittleEndian, value); err != nil {
+				return fmt.Errorf("failed to write version offset: %w", err)
+			}
+		}
+	}
+	buf.Write(versions.Bytes())
+	return nil
+}
+
+// ReadMetadataEntry reads the dist-tags and the version table of a packument written by WriteMetadataEntry.
+// data has to stay as it is while the versions are read from it
+func ReadMetadataEntry(name string, data []byte) (*LazyMetadata, error) {
+	metadata, err := readMetadataEntry(name, data)
+	if err != nil {
+		return nil, metadataFormat.unreadable(err)
+	}
+	return metadata, nil
+}
+
+func readMetadataEntry(name string, data []byte) (*LazyMetadata, error) {
+	buf := bytes.NewReader(data)
+	metadata := &LazyMetadata{Name: name}
+	var err error
+	if metadata.DistTags.Latest, err = readString(buf); err != nil {
+		return nil, fmt.Errorf("failed to read metadata dist tag latest: %w", err)
+	}
+	if metadata.DistTags.Next, err = readString(buf); err != nil {
+		return nil, fmt.Errorf("failed to read metadata dist tag next: %w", err)
+	}
+	count, err := readCount(buf, versionTableEntrySize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read versions count: %w", err)
+	}
+
+	type location struct{ offset, length uint32 }
+	table := make(map[string]location, count)
+	for i := 0; i < count; i++ {
+		key, err := readString(buf)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read version key: %w", err)
+		}
+		var loc location
+		if err := binary.Read(buf, binary.LittleEndian, &loc.offset); err != nil {
+			return nil, fmt.Errorf("failed to read version offset: %w", err)
+		}
+		if err := binary.Read(buf, binary.LittleEndian, &loc.length); err != nil {
+			return nil, fmt.Errorf("failed to read version length: %w", err)
+		}
+		table[key] = loc
+	}
+
+	versions := data[len(data)-buf.Len():]
+	metadata.versions = make(map[string][]byte, count)
+	for key, loc := range table {
+		if uint64(loc.offset)+uint64(loc.length) > uint64(len(versions)) {
+			return nil, fmt.Errorf("version %s is out of bounds", key)
+		}
+		metadata.versions[key] = versions[loc.offset : loc.offset+loc.length]
+	}
+	return metadata, nil
+}
+
+// Versions lists the versions of the package, without decoding any of them
+func (m *LazyMetadata) Versions() []string {
+	versions := make([]string, 0, len(m.versions))
+	for version := range m.versions {
+		versions = append(versions, version)
+	}
+	return versions
+}
+
+// Version decodes a single version, which is empty when the package doesn't have it
+func (m *LazyMetadata) Version(version string) (types.VersionMetadata, error) {
+	data, ok := m.versions[version]
+	if !ok {
+		return types.VersionMetadata{}, nil
+	}
+	buf := bytes.NewReader(data)
+	vm, err := readVersionMetadata(buf, metadataFormat.version)
+	if err != nil {
+		return vm, metadataFormat.unreadable(fmt.Errorf("failed to read version %s: %w", version, err))
+	}
+	if buf.Len() > 0 {
+		return vm, metadataFormat.unreadable(fmt.Errorf("%d bytes are left after version %s", buf.Len(), version))
+	}
+	return vm, nil
+}
+
+// Metadata decodes every version, for when the whole packument is needed
+func (m *LazyMetadata) Metadata() (*types.Metadata, error) {
+	metadata := &types.Metadata{Name: m.Name, Versions: make(map[string]types.VersionMetadata, len(m.versions))}
+	metadata.DistTags.Latest = m.DistTags.Latest
+	metadata.DistTags.Next = m.DistTags.Next
+	for version := range m.versions {
+		vm, err := m.Version(version)
+		if err != nil {
+			return nil, err
+		}
+		metadata.Versions[version] = vm
+	}
+	return metadata, nil
+}
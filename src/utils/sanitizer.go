@@ -1,8 +1,105 @@
 package utils
 
-import "strings"
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
 
-// SanitizePackageName replaces slashes in the package name with a hyphen or another character
-func SanitizePackageName(pkgName string) string {
-	return strings.ReplaceAll(pkgName, "/", "-")
+// the longest package id that's used as a file name as it is, longer ones are cut short and hashed
+const maxPackageIDLength = 120
+
+// EncodePackageID turns name@version into a file name no other package version shares and that works on
+// every file system. The scope slash becomes a +, which package names can't have, and whatever else a file
+// name can't hold, like the slashes and colons of git, local and tarball versions, is percent-encoded.
+// Ids that would get too long are cut short and end in _ and a hash of the whole id
+func EncodePackageID(name, version string) string {
+	id := EncodePackageName(name) + "@" + escape(version, isVersionChar)
+	if len(id) <= maxPackageIDLength {
+		return id
+	}
+	sum := sha256.Sum256([]byte(name + "@" + version))
+	hash := hex.EncodeToString(sum[:16])
+	return id[:maxPackageIDLength-len(hash)-1] + "_" + hash
+}
+
+// DecodePackageID gets the name and version back from an id EncodePackageID made, which isn't possible for
+// the hashed ones
+func DecodePackageID(id string) (string, string, bool) {
+	at := strings.Index(id[min(1, len(id)):], "@") + 1
+	if at <= 0 || strings.Contains(id[at:], "_") {
+		return "", "", false
+	}
+	name, err := unescape(strings.ReplaceAll(id[:at], "+", "/"))
+	if err != nil {
+		return "", "", false
+	}
+	version, err := unescape(id[at+1:])
+	if err != nil {
+		return "", "", false
+	}
+	return name, version, true
+}
+
+// EncodePackageName is the name part of EncodePackageID, for files that are about every version of a package
+func EncodePackageName(name string) string {
+	var encoded strings.Builder
+	for i := 0; i < len(name); i++ {
+		switch c := name[i]; {
+		case c == '/':
+			encoded.WriteByte('+')
+		case c == '@' && i == 0, isNameChar(c):
+			encoded.WriteByte(c)
+		default:
+			fmt.Fprintf(&encoded, "%%%02X", c)
+		}
+	}
+	return encoded.String()
+}
+
+func escape(value string, keep func(byte) bool) string {
+	var encoded strings.Builder
+	for i := 0; i < len(value); i++ {
+		if c := value[i]; keep(c) {
+			encoded.WriteByte(c)
+		} else {
+			fmt.Fprintf(&encoded, "%%%02X", c)
+		}
+	}
+	return encoded.String()
+}
+
+func unescape(value string) (string, error) {
+	var decoded strings.Builder
+	for i := 0; i < len(value); i++ {
+		if value[i] != '%' {
+			decoded.WriteByte(value[i])
+			continue
+		}
+		if i+2 >= len(value) {
+			return "", fmt.Errorf("%s ends in the middle of an escape", value)
+		}
+		b, err := hex.DecodeString(value[i+1 : i+3])
+		if err != nil {
+			return "", fmt.Errorf("invalid escape in %s: %w", value, err)
+		}
+		decoded.Write(b)
+		i += 2
+	}
+	return decoded.String(), nil
+}
+
+func isAlphanumeric(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
+
+// the characters npm allows in package names, apart from the scope
+func isNameChar(c byte) bool {
+	return isAlphanumeric(c) || strings.IndexByte(".-_~!'()", c) >= 0
+}
+
+// the characters of semver versions. _ isn't one of them, which is what tells hashed ids apart
+func isVersionChar(c byte) bool {
+	return isAlphanumeric(c) || c == '.' || c == '-' || c == '+'
 }
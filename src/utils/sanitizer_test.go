@@ -0,0 +1,71 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPackageIDRoundTrip(t *testing.T) {
+	for _, pkg := range [][2]string{
+		{"left-pad", "1.3.0"},
+		{"@scope/pkg", "2.0.0-beta.1"},
+		{"@scope/under_score", "1.0.0"},
+		{"tilde~name", "0.0.1"},
+		{"lodash._basefor", "4.17.21"},
+		{"pkg", "1.0.0+build.5"},
+		{"@scope/pkg", "1.0.0-rc.1+sha.abc"},
+		{"pkg", "git+https://github.com/org/pkg.git#0123456789abcdef0123456789abcdef01234567"},
+		{"pkg", "git+ssh://git@github.com:org/pkg.git#v1.0.0"},
+		{"pkg", "https://example.com/pkg-1.0.0.tgz?token=a&b=c"},
+		{"@scope/pkg", "file:../pkg"},
+		{"pkg", "link:C:\\Users\\me\\pkg"},
+	} {
+		name, version := pkg[0], pkg[1]
+		id := EncodePackageID(name, version)
+		if strings.ContainsAny(id, `/\:*?"<>|`) {
+			t.Errorf("EncodePackageID(%s, %s) = %s, which isn't a valid file name everywhere", name, version, id)
+		}
+		decodedName, decodedVersion, ok := DecodePackageID(id)
+		if !ok || decodedName != name || decodedVersion != version {
+			t.Errorf("DecodePackageID(%s) = %s, %s, %t, want %s, %s", id, decodedName, decodedVersion, ok, name, version)
+		}
+	}
+}
+
+func TestPackageIDsDontCollide(t *testing.T) {
+	ids := make(map[string]string)
+	for _, pkg := range [][2]string{
+		{"@a/b-c", "1.0.0"},
+		{"@a-b/c", "1.0.0"},
+		{"@a/b", "c@1.0.0"},
+		{"a", "1.0.0"},
+		{"a", "1.0.0+build"},
+		{"a", "1.0.0-build"},
+		{"a_b", "1.0.0"},
+		{"a~b", "1.0.0"},
+		{"a.b", "1.0.0"},
+		{"pkg", "file:a/b"},
+		{"pkg", "file:a+b"},
+	} {
+		id := EncodePackageID(pkg[0], pkg[1])
+		if previous, ok := ids[id]; ok {
+			t.Errorf("%s@%s and %s both encode to %s", pkg[0], pkg[1], previous, id)
+		}
+		ids[id] = pkg[0] + "@" + pkg[1]
+	}
+}
+
+func TestLongPackageIDsAreHashed(t *testing.T) {
+	name := "@scope/" + strings.Repeat("long-name", 10)
+	first := EncodePackageID(name, "https://example.com/"+strings.Repeat("a", 100)+"/1.tgz")
+	second := EncodePackageID(name, "https://example.com/"+strings.Repeat("a", 100)+"/2.tgz")
+	if len(first) > maxPackageIDLength || len(second) > maxPackageIDLength {
+		t.Errorf("ids are %d and %d long, want at most %d", len(first), len(second), maxPackageIDLength)
+	}
+	if first == second {
+		t.Errorf("two long ids that only differ at the end both encode to %s", first)
+	}
+	if _, _, ok := DecodePackageID(first); ok {
+		t.Errorf("DecodePackageID(%s) decoded a hashed id", first)
+	}
+}
@@ -0,0 +1,48 @@
+package utils
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var sizeUnits = []struct {
+	suffix string
+	bytes  int64
+}{
+	{"GB", 1000 * 1000 * 1000},
+	{"MB", 1000 * 1000},
+	{"KB", 1000},
+	{"B", 1},
+}
+
+// ParseSize reads sizes like 500MB, 1.5GB or a plain number of bytes
+func ParseSize(size string) (int64, error) {
+	trimmed := strings.ToUpper(strings.TrimSpace(size))
+	multiplier := int64(1)
+	for _, unit := range sizeUnits {
+		if number, ok := strings.CutSuffix(trimmed, unit.suffix); ok {
+			trimmed, multiplier = strings.TrimSpace(number), unit.bytes
+			break
+		}
+	}
+	value, err := strconv.ParseFloat(trimmed, 64)
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("invalid size %s", size)
+	}
+	return int64(value * float64(multiplier)), nil
+}
+
+// FormatSize prints sizes in decimal units, the way npm does
+func FormatSize(size int64) string {
+	switch {
+	case size < 1000:
+		return fmt.Sprintf("%dB", size)
+	case size < 1000*1000:
+		return fmt.Sprintf("%.1fkB", float64(size)/1000)
+	case size < 1000*1000*1000:
+		return fmt.Sprintf("%.1fMB", float64(size)/1000/1000)
+	default:
+		return fmt.Sprintf("%.1fGB", float64(size)/1000/1000/1000)
+	}
+}
@@ -0,0 +1,242 @@
+package utils
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Eyepan/yap/src/types"
+)
+
+const textLockfileVersion = 1
+
+const textLockfileHeader = "# yap lockfile, generated by yap install. edit package.json and install again instead of editing it\n"
+
+// WriteTextLockfile writes the lockfile as yap.lock: a stanza per importer, override and package, each
+// sorted, so the same resolution always gives the same file and diffs show exactly what changed
+func WriteTextLockfile(buf *bytes.Buffer, lockfile types.Lockfile) {
+	buf.WriteString(textLockfileHeader)
+	fmt.Fprintf(buf, "lockfileVersion %d\n", textLockfileVersion)
+
+	importers := append([]types.Importer{{Path: ".", Dependencies: lockfile.CoreDependencies}}, lockfile.Importers...)
+	sort.SliceStable(importers, func(i, j int) bool {
+		return importers[i].Path < importers[j].Path
+	})
+	for _, importer := range importers {
+		fmt.Fprintf(buf, "\nimporter %s\n", quoteToken(importer.Path))
+		deps := append([]types.Package{}, importer.Dependencies...)
+		sort.Slice(deps, func(i, j int) bool {
+			return requiredName(deps[i].Name, deps[i].Alias) < requiredName(deps[j].Name, deps[j].Alias)
+		})
+		for _, dep := range deps {
+			writeTextDependency(buf, dep.Name, dep.Version, dep.Alias)
+		}
+	}
+
+	lockedOverrides := append([]types.LockedOverride{}, lockfile.Overrides...)
+	sort.SliceStable(lockedOverrides, func(i, j int) bool {
+		return lockedOverrides[i].Selector < lockedOverrides[j].Selector
+	})
+	for _, override := range lockedOverrides {
+		fmt.Fprintf(buf, "\noverride %s %s\n", quoteToken(override.Selector), quoteToken(override.Version))
+		applied := append([]string{}, override.Applied...)
+		sort.Strings(applied)
+		for _, id := range applied {
+			fmt.Fprintf(buf, "  applied %s\n", quoteToken(id))
+		}
+	}
+
+	resolutions := append([]types.MPackage{}, lockfile.Resolutions...)
+	sort.Slice(resolutions, func(i, j int) bool {
+		if resolutions[i].Name != resolutions[j].Name {
+			return resolutions[i].Name < resolutions[j].Name
+		}
+		return resolutions[i].Version < resolutions[j].Version
+	})
+	for _, mPkg := range resolutions {
+		fmt.Fprintf(buf, "\npackage %s %s\n", quoteToken(mPkg.Name), quoteToken(mPkg.Version))
+		if mPkg.Dist.Tarball != "" {
+			fmt.Fprintf(buf, "  resolved %s\n", quoteToken(mPkg.Dist.Tarball))
+		}
+		if mPkg.Dist.Integrity != "" {
+			fmt.Fprintf(buf, "  integrity %s\n", quoteToken(mPkg.Dist.Integrity))
+		}
+		if mPkg.Dist.Shasum != "" {
+			fmt.Fprintf(buf, "  shasum %s\n", quoteToken(mPkg.Dist.Shasum))
+		}
+		if mPkg.Dist.FileCount != 0 {
+			fmt.Fprintf(buf, "  fileCount %d\n", mPkg.Dist.FileCount)
+		}
+		deps := append([]*types.MPackage{}, mPkg.Dependencies...)
+		sort.Slice(deps, func(i, j int) bool {
+			return requiredName(deps[i].Name, deps[i].Alias) < requiredName(deps[j].Name, deps[j].Alias)
+		})
+		for _, dep := range deps {
+			writeTextDependency(buf, dep.Name, dep.Version, dep.Alias)
+		}
+	}
+}
+
+// writeTextDependency writes `dependency <name> <version>`, followed by `as <alias>` for aliases
+func writeTextDependency(buf *bytes.Buffer, name, version, alias string) {
+	fmt.Fprintf(buf, "  dependency %s %s", quoteToken(name), quoteToken(version))
+	if alias != "" {
+		fmt.Fprintf(buf, " as %s", quoteToken(alias))
+	}
+	buf.WriteString("\n")
+}
+
+// ReadTextLockfile reads a lockfile written by WriteTextLockfile
+func ReadTextLockfile(data []byte) (*types.Lockfile, error) {
+	var lockfile types.Lockfile
+	// the stanza the indented lines belong to
+	var importer *types.Importer
+	var override *types.LockedOverride
+	var mPkg *types.MPackage
+	version := 0
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := scanner.Text()
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		fields, err := splitTokens(trimmed)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNumber, err)
+		}
+		indented := line != strings.TrimLeft(line, " \t")
+
+		if !indented {
+			importer, override, mPkg = nil, nil, nil
+			switch {
+			case fields[0] == "lockfileVersion" && len(fields) == 2:
+				if version, err = strconv.Atoi(fields[1]); err != nil || version < 1 {
+					return nil, fmt.Errorf("line %d: invalid lockfileVersion %q", lineNumber, fields[1])
+				}
+				if version > textLockfileVersion {
+					return nil, fmt.Errorf("lockfileVersion %d was written by a newer yap, this one reads up to %d", version, textLockfileVersion)
+				}
+			case fields[0] == "importer" && len(fields) == 2:
+				if fields[1] == "." {
+					importer = &types.Importer{Path: "."}
+					continue
+				}
+				lockfile.Importers = append(lockfile.Importers, types.Importer{Path: fields[1]})
+				importer = &lockfile.Importers[len(lockfile.Importers)-1]
+			case fields[0] == "override" && len(fields) == 3:
+				lockfile.Overrides = append(lockfile.Overrides, types.LockedOverride{Selector: fields[1], Version: fields[2]})
+				override = &lockfile.Overrides[len(lockfile.Overrides)-1]
+			case fields[0] == "package" && len(fields) == 3:
+				lockfile.Resolutions = append(lockfile.Resolutions, types.MPackage{Name: fields[1], Version: fields[2]})
+				mPkg = &lockfile.Resolutions[len(lockfile.Resolutions)-1]
+			default:
+				return nil, fmt.Errorf("line %d: unexpected %q", lineNumber, trimmed)
+			}
+			continue
+		}
+
+		switch {
+		case importer != nil && fields[0] == "dependency":
+			pkg, err := parseTextDependency(fields)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", lineNumber, err)
+			}
+			if importer.Path == "." {
+				lockfile.CoreDependencies = append(lockfile.CoreDependencies, pkg)
+			} else {
+				importer.Dependencies = append(importer.Dependencies, pkg)
+			}
+		case override != nil && fields[0] == "applied" && len(fields) == 2:
+			override.Applied = append(override.Applied, fields[1])
+		case mPkg != nil && fields[0] == "dependency":
+			pkg, err := parseTextDependency(fields)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", lineNumber, err)
+			}
+			mPkg.Dependencies = append(mPkg.Dependencies, &types.MPackage{Name: pkg.Name, Version: pkg.Version, Alias: pkg.Alias})
+		case mPkg != nil && fields[0] == "resolved" && len(fields) == 2:
+			mPkg.Dist.Tarball = fields[1]
+		case mPkg != nil && fields[0] == "integrity" && len(fields) == 2:
+			mPkg.Dist.Integrity = fields[1]
+		case mPkg != nil && fields[0] == "shasum" && len(fields) == 2:
+			mPkg.Dist.Shasum = fields[1]
+		case mPkg != nil && fields[0] == "fileCount" && len(fields) == 2:
+			if mPkg.Dist.FileCount, err = strconv.ParseInt(fields[1], 10, 64); err != nil {
+				return nil, fmt.Errorf("line %d: invalid fileCount %q", lineNumber, fields[1])
+			}
+		default:
+			return nil, fmt.Errorf("line %d: unexpected %q", lineNumber, trimmed)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if version == 0 {
+		return nil, fmt.Errorf("missing lockfileVersion, this isn't a yap.lock")
+	}
+	return &lockfile, nil
+}
+
+func parseTextDependency(fields []string) (types.Package, error) {
+	switch {
+	case len(fields) == 3:
+		return types.Package{Name: fields[1], Version: fields[2]}, nil
+	case len(fields) == 5 && fields[3] == "as":
+		return types.Package{Name: fields[1], Version: fields[2], Alias: fields[4]}, nil
+	}
+	return types.Package{}, fmt.Errorf("expected `dependency <name> <version> [as <alias>]`")
+}
+
+func requiredName(name, alias string) string {
+	if alias != "" {
+		return alias
+	}
+	return name
+}
+
+// quoteToken leaves names, versions and urls as they are and quotes anything that would be read back
+// as more than one token
+func quoteToken(value string) string {
+	if value == "" || strings.ContainsAny(value, " \t\"#\\") {
+		return strconv.Quote(value)
+	}
+	for _, r := range value {
+		if !strconv.IsPrint(r) {
+			return strconv.Quote(value)
+		}
+	}
+	return value
+}
+
+// splitTokens splits a line on whitespace, reading quoted tokens the way quoteToken writes them
+func splitTokens(line string) ([]string, error) {
+	var tokens []string
+	for line = strings.TrimLeft(line, " \t"); line != ""; line = strings.TrimLeft(line, " \t") {
+		if line[0] == '"' {
+			quoted, err := strconv.QuotedPrefix(line)
+			if err != nil {
+				return nil, fmt.Errorf("unterminated string %s", line)
+			}
+			token, err := strconv.Unquote(quoted)
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, token)
+			line = line[len(quoted):]
+			continue
+		}
+		end := strings.IndexAny(line, " \t")
+		if end < 0 {
+			end = len(line)
+		}
+		tokens = append(tokens, line[:end])
+		line = line[end:]
+	}
+	return tokens, nil
+}
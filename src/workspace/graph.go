@@ -0,0 +1,226 @@
+package workspace
+
+import (
+	"fmt"
+	"log/slog"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/Eyepan/yap/src/packagejson"
+)
+
+// Graph holds the dependencies between workspace packages, keyed by package directory
+type Graph struct {
+	Packages     map[string]*Package
+	Dependencies map[string][]string
+	Dependents   map[string][]string
+}
+
+// BuildGraph links every package to the workspace packages it depends on, through any kind of dependency
+func BuildGraph(packages []Package) *Graph {
+	graph := &Graph{
+		Packages:     make(map[string]*Package, len(packages)),
+		Dependencies: make(map[string][]string, len(packages)),
+		Dependents:   make(map[string][]string, len(packages)),
+	}
+	for i := range packages {
+		graph.Packages[packages[i].Dir] = &packages[i]
+	}
+	for i := range packages {
+		pkg := &packages[i]
+		for name := range packagejson.GetAllDependencies(&pkg.Manifest) {
+			dependency := findPackage(packages, name)
+			if dependency == nil || dependency.Dir == pkg.Dir {
+				continue
+			}
+			graph.Dependencies[pkg.Dir] = append(graph.Dependencies[pkg.Dir], dependency.Dir)
+			graph.Dependents[dependency.Dir] = append(graph.Dependents[dependency.Dir], pkg.Dir)
+		}
+	}
+	for _, edges := range []map[string][]string{graph.Dependencies, graph.Dependents} {
+		for dir := range edges {
+			sort.Strings(edges[dir])
+		}
+	}
+	return graph
+}
+
+// Select returns the directories of the packages matched by the filters, or every package when there are none.
+// Filters follow pnpm: a package name (globs allowed) or a ./directory relative to cwdDir, with a `...` suffix to
+// add its dependencies, a `...` prefix to add its dependents and a `^` next to the dots to leave the package out
+func (g *Graph) Select(filters []string, rootDir, cwdDir string) ([]string, error) {
+	selected := make(map[string]bool)
+	if len(filters) == 0 {
+		for dir := range g.Packages {
+			selected[dir] = true
+		}
+	}
+
+	for _, filter := range filters {
+		pattern := filter
+		withDependents, withDependencies, excludeSelf := false, false, false
+		if rest, ok := strings.CutPrefix(pattern, "..."); ok {
+			withDependents = true
+			pattern = rest
+			if rest, ok := strings.CutPrefix(pattern, "^"); ok {
+				excludeSelf = true
+				pattern = rest
+			}
+		}
+		if rest, ok := strings.CutSuffix(pattern, "..."); ok {
+			withDependencies = true
+			pattern = rest
+			if rest, ok := strings.CutSuffix(pattern, "^"); ok {
+				excludeSelf = true
+				pattern = rest
+			}
+		}
+
+		matched, err := g.match(pattern, rootDir, cwdDir)
+		if err != nil {
+			return nil, fmt.Errorf("invalid filter %s: %w", filter, err)
+		}
+		if len(matched) == 0 {
+			slog.Warn(fmt.Sprintf("no workspace package matches the filter %s", filter))
+		}
+		for _, dir := range matched {
+			if !excludeSelf {
+				selected[dir] = true
+			}
+			if withDependencies {
+				g.walk(dir, g.Dependencies, selected)
+			}
+			if withDependents {
+				g.walk(dir, g.Dependents, selected)
+			}
+		}
+	}
+
+	dirs := make([]string, 0, len(selected))
+	for dir := range selected {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+	return dirs, nil
+}
+
+func (g *Graph) match(pattern, rootDir, cwdDir string) ([]string, error) {
+	isDir := strings.HasPrefix(pattern, ".") || strings.HasPrefix(pattern, "/")
+	if isDir {
+		absPattern := pattern
+		if !filepath.IsAbs(pattern) {
+			absPattern = filepath.Join(cwdDir, pattern)
+		}
+		relativePattern, err := filepath.Rel(rootDir, absPattern)
+		if err != nil {
+			return nil, err
+		}
+		pattern = filepath.ToSlash(relativePattern)
+	}
+
+	var matched []string
+	for dir, pkg := range g.Packages {
+		subject := pkg.Manifest.Name
+		if isDir {
+			subject = dir
+		}
+		ok, err := path.Match(pattern, subject)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			matched = append(matched, dir)
+		}
+	}
+	return matched, nil
+}
+
+func (g *Graph) walk(dir string, edges map[string][]string, selected map[string]bool) {
+	for _, next := range edges[dir] {
+		if selected[next] {
+			continue
+		}
+		selected[next] = true
+		g.walk(next, edges, selected)
+	}
+}
+
+// Run calls fn for every selected package once all of its selected dependencies are done, running up to
+// concurrency packages at the same time. With bail set nothing new is started after the first failure.
+// Dependency cycles are broken in directory order
+func (g *Graph) Run(selected []string, concurrency int, bail bool, fn func(pkg *Package) error) []error {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	isSelected := make(map[string]bool, len(selected))
+	for _, dir := range selected {
+		isSelected[dir] = true
+	}
+	pending := make(map[string]int, len(selected))
+	for _, dir := range selected {
+		for _, dependency := range g.Dependencies[dir] {
+			if isSelected[dependency] {
+				pending[dir]++
+			}
+		}
+	}
+
+	type result struct {
+		dir string
+		err error
+	}
+	results := make(chan result)
+	started := make(map[string]bool, len(selected))
+	var errs []error
+	running, done := 0, 0
+	failed := false
+
+	for done < len(selected) {
+		for running < concurrency && !(bail && failed) {
+			next := ""
+			for _, dir := range selected {
+				if !started[dir] && pending[dir] == 0 {
+					next = dir
+					break
+				}
+			}
+			if next == "" && running == 0 {
+				// everything left waits on something else that's left, so there's a cycle
+				for _, dir := range selected {
+					if !started[dir] {
+						slog.Warn(fmt.Sprintf("dependency cycle detected, running %s before its dependencies", dir))
+						next = dir
+						break
+					}
+				}
+			}
+			if next == "" {
+				break
+			}
+			started[next] = true
+			running++
+			go func(dir string) {
+				results <- result{dir: dir, err: fn(g.Packages[dir])}
+			}(next)
+		}
+		if running == 0 {
+			break
+		}
+
+		res := <-results
+		running--
+		done++
+		if res.err != nil {
+			failed = true
+			errs = append(errs, res.err)
+		}
+		for _, dependent := range g.Dependents[res.dir] {
+			if isSelected[dependent] {
+				pending[dependent]--
+			}
+		}
+	}
+	return errs
+}
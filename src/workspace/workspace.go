@@ -0,0 +1,232 @@
+package workspace
+
+import (
+	"bufio"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/Eyepan/yap/src/packagejson"
+	"github.com/Eyepan/yap/src/spec"
+	"github.com/Eyepan/yap/src/types"
+	"github.com/Eyepan/yap/src/utils"
+	"github.com/Masterminds/semver/v3"
+)
+
+// WorkspaceFile lists extra workspace globs, one per line. Lines starting with # are comments and ! excludes
+const WorkspaceFile = "yap-workspace"
+
+type Package struct {
+	Dir      string // relative to the workspace root, slash separated
+	Manifest types.PackageJSON
+}
+
+// FindRoot walks up from dir looking for the package.json that declares workspaces (or a yap-workspace file).
+// Outside of a workspace the nearest directory with a package.json is the root
+func FindRoot(dir string) (string, error) {
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+
+	root := ""
+	for current := absDir; ; current = filepath.Dir(current) {
+		if _, err := os.Stat(filepath.Join(current, WorkspaceFile)); err == nil {
+			return current, nil
+		}
+		if pkgJSON, err := packagejson.ReadPackageJSON(current); err == nil {
+			if len(pkgJSON.Workspaces) > 0 {
+				return current, nil
+			}
+			if root == "" {
+				root = current
+			}
+		}
+		if filepath.Dir(current) == current {
+			break
+		}
+	}
+	if root == "" {
+		return "", fmt.Errorf("no package.json found in %s or any of its parents", absDir)
+	}
+	return root, nil
+}
+
+// ReadPatterns returns the workspace globs declared in package.json and the yap-workspace file
+func ReadPatterns(rootDir string, pkgJSON *types.PackageJSON) ([]string, error) {
+	patterns := append([]string{}, pkgJSON.Workspaces...)
+
+	file, err := os.Open(filepath.Join(rootDir, WorkspaceFile))
+	if os.IsNotExist(err) {
+		return patterns, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", WorkspaceFile, err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		patterns = append(patterns, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", WorkspaceFile, err)
+	}
+	return patterns, nil
+}
+
+// DiscoverPackages finds every package under rootDir matched by the workspace globs, sorted by directory
+func DiscoverPackages(rootDir string) ([]Package, error) {
+	rootPkgJSON, err := packagejson.ReadPackageJSON(rootDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read root package.json: %w", err)
+	}
+	patterns, err := ReadPatterns(rootDir, &rootPkgJSON)
+	if err != nil {
+		return nil, err
+	}
+	if len(patterns) == 0 {
+		return nil, nil
+	}
+
+	var packages []Package
+	err = filepath.WalkDir(rootDir, func(dir string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		if d.Name() == "node_modules" || d.Name() == ".git" {
+			return filepath.SkipDir
+		}
+		relativeDir, err := filepath.Rel(rootDir, dir)
+		if err != nil || relativeDir == "." {
+			return err
+		}
+		relativeDir = filepath.ToSlash(relativeDir)
+		if !Matches(patterns, relativeDir) {
+			return nil
+		}
+		manifest, err := packagejson.ReadPackageJSON(dir)
+		if os.IsNotExist(err) {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("failed to read package.json of workspace package %s: %w", relativeDir, err)
+		}
+		packages = append(packages, Package{Dir: relativeDir, Manifest: manifest})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to discover workspace packages: %w", err)
+	}
+
+	sort.Slice(packages, func(i, j int) bool {
+		return packages[i].Dir < packages[j].Dir
+	})
+	return packages, nil
+}
+
+// Matches reports whether dir is matched by one of the patterns and not excluded by a later `!` pattern
+func Matches(patterns []string, dir string) bool {
+	matched := false
+	for _, pattern := range patterns {
+		pattern = strings.TrimPrefix(strings.TrimSuffix(pattern, "/"), "./")
+		if negated, ok := strings.CutPrefix(pattern, "!"); ok {
+			if utils.MatchSegments(strings.Split(strings.TrimPrefix(negated, "./"), "/"), strings.Split(dir, "/")) {
+				matched = false
+			}
+			continue
+		}
+		if utils.MatchSegments(strings.Split(pattern, "/"), strings.Split(dir, "/")) {
+			matched = true
+		}
+	}
+	return matched
+}
+
+// ImporterDependencies returns every dependency of a workspace package ready to be installed from the root.
+// Dependencies on other workspace packages become link: specs, either through the workspace: protocol or
+// because the local version satisfies the range, and relative file:/link: paths are rebased onto the root
+func ImporterDependencies(dir string, manifest *types.PackageJSON, packages []Package) (types.Dependencies, error) {
+	deps := packagejson.GetAllDependencies(manifest)
+	for name, specifier := range deps {
+		localPackage := findPackage(packages, name)
+
+		if versionRange, ok := spec.ParseWorkspace(specifier); ok {
+			if localPackage == nil {
+				return nil, fmt.Errorf("%s depends on %s@%s but there's no such workspace package", manifestName(dir, manifest), name, specifier)
+			}
+			if !satisfies(localPackage.Manifest.Version, versionRange) {
+				return nil, fmt.Errorf("%s depends on %s@%s but the workspace has %s", manifestName(dir, manifest), name, specifier, localPackage.Manifest.Version)
+			}
+			deps[name] = "link:" + localPackage.Dir
+			continue
+		}
+		if localPackage != nil && localPackage.Dir != dir && satisfies(localPackage.Manifest.Version, specifier) {
+			deps[name] = "link:" + localPackage.Dir
+			continue
+		}
+
+		deps[name] = spec.Rebase(specifier, dir)
+	}
+	return deps, nil
+}
+
+// Importers returns the dependencies of the root and of every workspace package, keyed by their directory
+// relative to rootDir ("." for the root)
+func Importers(rootDir string, rootManifest *types.PackageJSON) (map[string]types.Dependencies, error) {
+	packages, err := DiscoverPackages(rootDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read workspace: %w", err)
+	}
+	importers := make(map[string]types.Dependencies, len(packages)+1)
+	if importers["."], err = ImporterDependencies(".", rootManifest, packages); err != nil {
+		return nil, fmt.Errorf("failed to read dependencies: %w", err)
+	}
+	for _, pkg := range packages {
+		if importers[pkg.Dir], err = ImporterDependencies(pkg.Dir, &pkg.Manifest, packages); err != nil {
+			return nil, fmt.Errorf("failed to read dependencies of %s: %w", pkg.Dir, err)
+		}
+	}
+	return importers, nil
+}
+
+func findPackage(packages []Package, name string) *Package {
+	for i := range packages {
+		if packages[i].Manifest.Name == name {
+			return &packages[i]
+		}
+	}
+	return nil
+}
+
+// satisfies checks a local version against a range, where the workspace: shorthands *, ^ and ~ match anything
+func satisfies(version, versionRange string) bool {
+	switch versionRange {
+	case "*", "^", "~", "":
+		return true
+	}
+	constraint, err := semver.NewConstraint(versionRange)
+	if err != nil {
+		return false
+	}
+	parsedVersion, err := semver.NewVersion(version)
+	if err != nil {
+		return false
+	}
+	return constraint.Check(parsedVersion)
+}
+
+func manifestName(dir string, manifest *types.PackageJSON) string {
+	if manifest.Name != "" {
+		return manifest.Name
+	}
+	return dir
+}